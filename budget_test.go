@@ -0,0 +1,115 @@
+package sieve
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+func TestExecuteBudgets(t *testing.T) {
+	msgHdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(eml))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, script string, setLimit func(o *interp.Options)) error {
+		t.Helper()
+		opts := DefaultOptions()
+		setLimit(&opts.Interp)
+		loaded, err := Load(strings.NewReader(script), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := interp.NewRuntimeData(loaded, interp.DummyPolicy{}, interp.EnvelopeStatic{},
+			interp.MessageStatic{Size: len(eml), Header: msgHdr, RawMessage: []byte(eml)})
+		return loaded.Execute(context.Background(), data)
+	}
+
+	cases := []struct {
+		name     string
+		script   string
+		setLimit func(o *interp.Options)
+		kind     interp.BudgetKind
+	}{
+		{
+			name:     "commands",
+			script:   `keep; keep; keep; keep;`,
+			setLimit: func(o *interp.Options) { o.MaxCommands = 3 },
+			kind:     interp.BudgetCommands,
+		},
+		{
+			name:     "tests",
+			script:   `if allof(true, true, true) { keep; }`,
+			setLimit: func(o *interp.Options) { o.MaxTests = 3 },
+			kind:     interp.BudgetTests,
+		},
+		{
+			name:     "header scan",
+			script:   `if header :contains ["subject", "from", "to"] "nothing" { keep; }`,
+			setLimit: func(o *interp.Options) { o.MaxScanBytes = 40 },
+			kind:     interp.BudgetScanBytes,
+		},
+		{
+			name: "body scan",
+			script: `require "body";
+if body :raw :contains "nothing" { keep; }`,
+			setLimit: func(o *interp.Options) { o.MaxScanBytes = 100 },
+			kind:     interp.BudgetScanBytes,
+		},
+		{
+			name: "variables",
+			script: `require "variables";
+set "a" "0123456789";
+set "b" "0123456789";`,
+			setLimit: func(o *interp.Options) { o.MaxVariableBytes = 15 },
+			kind:     interp.BudgetVariableBytes,
+		},
+		{
+			name: "match variables",
+			script: `require "variables";
+if header :matches "subject" "*" { keep; }`,
+			setLimit: func(o *interp.Options) { o.MaxVariableBytes = 10 },
+			kind:     interp.BudgetVariableBytes,
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			err := run(t, c.script, c.setLimit)
+			var budgetErr *interp.BudgetError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("expected BudgetError, got %v", err)
+			}
+			if budgetErr.Kind != c.kind {
+				t.Errorf("wrong budget kind: %v", budgetErr.Kind)
+			}
+
+			// Same script should work without limits.
+			if err := run(t, c.script, func(*interp.Options) {}); err != nil {
+				t.Errorf("unexpected error without limits: %v", err)
+			}
+		})
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	loaded, err := Load(strings.NewReader(`keep;`), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := interp.NewRuntimeData(loaded, interp.DummyPolicy{}, interp.EnvelopeStatic{}, interp.MessageStatic{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := loaded.Execute(ctx, data); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(data.AppliedActions) != 0 {
+		t.Errorf("actions applied after cancellation: %v", data.AppliedActions)
+	}
+}
@@ -0,0 +1,291 @@
+// Package cache implements a cache of loaded Sieve scripts.
+//
+// Scripts are deduplicated by the hash of their source and load options,
+// so users with identical scripts share a single *sieve.Script. Compiled
+// :matches patterns are interned across all scripts in the cache.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/foxcpp/go-sieve"
+)
+
+// Key identifies the script source together with the options it was
+// loaded with.
+type Key [sha256.Size]byte
+
+// KeyOf returns the cache key for the script source loaded with opts.
+//
+// All options that affect the loaded script are part of the key, including
+// Lexer.Filename that is recorded in positions of loaded commands. Use the
+// same file name for all users to share identical scripts.
+// Interp.CompileMatcher is not, the cache assumes all matcher
+// implementations are equivalent. Interp.T is only checked for presence.
+func KeyOf(src []byte, opts sieve.Options) Key {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", len(src))
+	h.Write(src)
+
+	fmt.Fprintf(h, "lexer:%q,%v,%d;", opts.Lexer.Filename, opts.Lexer.NoPosition, opts.Lexer.MaxTokens)
+	fmt.Fprintf(h, "parser:%d,%d;", opts.Parser.MaxBlockNesting, opts.Parser.MaxTestNesting)
+	i := &opts.Interp
+	fmt.Fprintf(h, "interp:%d,%d,%d,%d;", i.MaxRedirects, i.MaxVariableCount, i.MaxVariableNameLen, i.MaxVariableLen)
+	fmt.Fprintf(h, "%d,%d,%d,%d,%d,%d;", i.MaxCommands, i.MaxTests, i.MaxScanBytes, i.MaxVariableBytes,
+		i.MaxBodyPartBytes, i.MaxBodyBytes)
+	fmt.Fprintf(h, "%q,%v,%d,%v,%q;", i.SubAddressSep, i.ASCIIAddresses, i.IDNDomains, i.T != nil, i.DisabledTests)
+	fmt.Fprintf(h, "errors:%d", opts.MaxErrors)
+
+	var key Key
+	h.Sum(key[:0])
+	return key
+}
+
+type RemoveReason int
+
+const (
+	// RemoveEvicted means the script was evicted to keep the cache size
+	// within Options.MaxScripts.
+	RemoveEvicted RemoveReason = iota
+	// RemoveInvalidated means all names the script was loaded for were
+	// invalidated.
+	RemoveInvalidated
+)
+
+func (r RemoveReason) String() string {
+	switch r {
+	case RemoveEvicted:
+		return "evicted"
+	case RemoveInvalidated:
+		return "invalidated"
+	}
+	return fmt.Sprintf("RemoveReason(%d)", int(r))
+}
+
+type Options struct {
+	// MaxScripts limits the number of cached scripts, least recently
+	// used ones are evicted first. Zero means no limit.
+	MaxScripts int
+
+	// MaxMatchers limits the number of interned compiled matchers.
+	// Zero means no limit.
+	MaxMatchers int
+
+	// OnRemove is called after the script is removed from the cache.
+	// names are the script names that referred to it. It is called
+	// without internal locks held and may use the cache.
+	OnRemove func(key Key, names []string, reason RemoveReason)
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	MatcherHits   uint64
+	MatcherMisses uint64
+}
+
+type entry struct {
+	script *sieve.Script
+	names  map[string]struct{}
+}
+
+type removal struct {
+	key    Key
+	names  []string
+	reason RemoveReason
+}
+
+// Cache is a concurrency-safe cache of loaded scripts.
+//
+// Scripts are looked up either by name (e.g. the user owning the script)
+// using Get, or by source using Load. Load binds the name to the loaded
+// script so subsequent Get calls can skip reading the source. Invalidate
+// should be called when the script for the name changes.
+type Cache struct {
+	opts     Options
+	matchers *Matchers
+
+	mu      sync.Mutex
+	scripts *lru[Key, *entry]
+	names   map[string]Key
+	stats   Stats
+}
+
+func New(opts Options) *Cache {
+	return &Cache{
+		opts:     opts,
+		matchers: NewMatchers(opts.MaxMatchers),
+		scripts:  newLRU[Key, *entry](opts.MaxScripts),
+		names:    map[string]Key{},
+	}
+}
+
+// Get returns the script last loaded for the name, if it is still cached.
+func (c *Cache) Get(name string) (*sieve.Script, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.names[name]
+	if !ok {
+		return nil, false
+	}
+	e, ok := c.scripts.get(key)
+	if !ok {
+		return nil, false
+	}
+	return e.script, true
+}
+
+// Load returns the cached script for src and opts or loads it using
+// sieve.Load and caches it. In both cases, the name is bound to the script.
+//
+// If opts.Interp.CompileMatcher is not set, the cache's matcher table is
+// used. Load errors are not cached.
+func (c *Cache) Load(name string, src []byte, opts sieve.Options) (*sieve.Script, error) {
+	key := KeyOf(src, opts)
+
+	c.mu.Lock()
+	if e, ok := c.scripts.get(key); ok {
+		c.stats.Hits++
+		removed := c.bind(name, key, e)
+		c.mu.Unlock()
+
+		c.notify(removed)
+		return e.script, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	if opts.Interp.CompileMatcher == nil {
+		opts.Interp.CompileMatcher = c.matchers.Compile
+	}
+	script, err := sieve.Load(bytes.NewReader(src), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var removed []removal
+	e, ok := c.scripts.peek(key)
+	if ok {
+		// Loaded concurrently by another goroutine.
+		script = e.script
+	} else {
+		e = &entry{script: script, names: map[string]struct{}{}}
+		for _, evicted := range c.scripts.add(key, e) {
+			c.stats.Evictions++
+			removed = append(removed, c.unbindAll(evicted.key, evicted.value, RemoveEvicted))
+		}
+	}
+	removed = append(removed, c.bind(name, key, e)...)
+	c.mu.Unlock()
+
+	c.notify(removed)
+	return script, nil
+}
+
+// Invalidate unbinds the name from its script. The script is removed
+// if no other names refer to it.
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	var removed []removal
+	if key, ok := c.names[name]; ok {
+		delete(c.names, name)
+		if e, ok := c.scripts.peek(key); ok {
+			delete(e.names, name)
+			if len(e.names) == 0 {
+				c.scripts.remove(key)
+				removed = append(removed, removal{key: key, names: []string{name}, reason: RemoveInvalidated})
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	c.notify(removed)
+}
+
+// Purge removes all scripts from the cache.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	var removed []removal
+	for _, key := range c.keys() {
+		e, _ := c.scripts.remove(key)
+		removed = append(removed, c.unbindAll(key, e, RemoveInvalidated))
+	}
+	c.mu.Unlock()
+
+	c.notify(removed)
+}
+
+// Len returns the number of cached scripts.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scripts.len()
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	c.matchers.mu.Lock()
+	stats.MatcherHits = c.matchers.hits
+	stats.MatcherMisses = c.matchers.misses
+	c.matchers.mu.Unlock()
+	return stats
+}
+
+func (c *Cache) keys() []Key {
+	keys := make([]Key, 0, c.scripts.len())
+	for key := range c.scripts.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// bind makes the name refer to the script, unbinding it from the
+// previous one. Like Invalidate, it removes the previous script if no
+// other names refer to it. Must be called with c.mu held.
+func (c *Cache) bind(name string, key Key, e *entry) []removal {
+	var removed []removal
+	if old, ok := c.names[name]; ok && old != key {
+		if oldEntry, ok := c.scripts.peek(old); ok {
+			delete(oldEntry.names, name)
+			if len(oldEntry.names) == 0 {
+				c.scripts.remove(old)
+				removed = append(removed, removal{key: old, names: []string{name}, reason: RemoveInvalidated})
+			}
+		}
+	}
+	c.names[name] = key
+	e.names[name] = struct{}{}
+	return removed
+}
+
+// unbindAll removes names referring to the removed script. Must be called
+// with c.mu held.
+func (c *Cache) unbindAll(key Key, e *entry, reason RemoveReason) removal {
+	names := make([]string, 0, len(e.names))
+	for name := range e.names {
+		if c.names[name] == key {
+			delete(c.names, name)
+		}
+		names = append(names, name)
+	}
+	return removal{key: key, names: names, reason: reason}
+}
+
+func (c *Cache) notify(removed []removal) {
+	if c.opts.OnRemove == nil {
+		return
+	}
+	for _, r := range removed {
+		c.opts.OnRemove(r.key, r.names, r.reason)
+	}
+}
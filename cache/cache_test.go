@@ -0,0 +1,392 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+const testScript = `require "fileinto";
+if header :matches "subject" "*[SPAM]*" {
+	fileinto "Junk";
+}
+`
+
+func TestCacheDeduplicates(t *testing.T) {
+	c := New(Options{})
+	opts := sieve.DefaultOptions()
+
+	a, err := c.Load("alice", []byte(testScript), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Load("bob", []byte(testScript), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Error("identical scripts are not shared")
+	}
+
+	opts.Interp.MaxRedirects = 1
+	other, err := c.Load("carol", []byte(testScript), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == a {
+		t.Error("script loaded with different options is shared")
+	}
+
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cached scripts, got %d", c.Len())
+	}
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if got, ok := c.Get("bob"); !ok || got != a {
+		t.Error("Get does not return the loaded script")
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	src := []byte(testScript)
+	opts := sieve.DefaultOptions()
+	key := KeyOf(src, opts)
+
+	opts.Interp.CompileMatcher = New(Options{}).matchers.Compile
+	if KeyOf(src, opts) != key {
+		t.Error("CompileMatcher changes the key")
+	}
+
+	changed := []func(o *sieve.Options){
+		func(o *sieve.Options) { o.Lexer.Filename = "a.sieve" },
+		func(o *sieve.Options) { o.Parser.MaxTestNesting++ },
+		func(o *sieve.Options) { o.Interp.MaxBodyBytes = 1 },
+		func(o *sieve.Options) { o.Interp.SubAddressSep = "-" },
+		func(o *sieve.Options) { o.Interp.DisabledTests = []string{"envelope"} },
+		func(o *sieve.Options) { o.Interp.T = t },
+	}
+	for i, change := range changed {
+		o := sieve.DefaultOptions()
+		change(&o)
+		if KeyOf(src, o) == key {
+			t.Errorf("option change %d does not change the key", i)
+		}
+	}
+
+	// Update KeyOf and this list if options are added.
+	covered := map[string]bool{
+		"Lexer.Filename":            true,
+		"Lexer.NoPosition":          true,
+		"Lexer.MaxTokens":           true,
+		"Parser.MaxBlockNesting":    true,
+		"Parser.MaxTestNesting":     true,
+		"Interp.MaxRedirects":       true,
+		"Interp.MaxVariableCount":   true,
+		"Interp.MaxVariableNameLen": true,
+		"Interp.MaxVariableLen":     true,
+		"Interp.MaxCommands":        true,
+		"Interp.MaxTests":           true,
+		"Interp.MaxScanBytes":       true,
+		"Interp.MaxVariableBytes":   true,
+		"Interp.MaxBodyPartBytes":   true,
+		"Interp.MaxBodyBytes":       true,
+		"Interp.SubAddressSep":      true,
+		"Interp.ASCIIAddresses":     true,
+		"Interp.IDNDomains":         true,
+		"Interp.CompileMatcher":     false, // Deliberately excluded.
+		"Interp.T":                  true,
+		"Interp.DisabledTests":      true,
+		"MaxErrors":                 true,
+	}
+	seen := map[string]bool{}
+	var walk func(prefix string, typ reflect.Type)
+	walk = func(prefix string, typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			path := prefix + f.Name
+			if f.Type.Kind() == reflect.Struct {
+				walk(path+".", f.Type)
+				continue
+			}
+			seen[path] = true
+			if _, ok := covered[path]; !ok {
+				t.Errorf("KeyOf does not cover %s", path)
+			}
+		}
+	}
+	walk("", reflect.TypeOf(opts))
+
+	for path, inKey := range covered {
+		if !inKey || !seen[path] {
+			continue
+		}
+		o := sieve.DefaultOptions()
+		v := reflect.ValueOf(&o).Elem()
+		for _, name := range strings.Split(path, ".") {
+			v = v.FieldByName(name)
+		}
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(v.String() + "x")
+		case reflect.Bool:
+			v.SetBool(!v.Bool())
+		case reflect.Int:
+			v.SetInt(v.Int() + 1)
+		case reflect.Slice:
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		case reflect.Pointer:
+			v.Set(reflect.New(v.Type().Elem()))
+		default:
+			t.Errorf("cannot change %s of kind %v", path, v.Kind())
+			continue
+		}
+		if KeyOf(src, o) == key {
+			t.Errorf("%s does not change the key", path)
+		}
+	}
+	for path := range covered {
+		if !seen[path] {
+			t.Errorf("%s is not an option anymore", path)
+		}
+	}
+}
+
+func TestCacheInternsMatchers(t *testing.T) {
+	c := New(Options{})
+	opts := sieve.DefaultOptions()
+
+	if _, err := c.Load("alice", []byte(testScript), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Different script, same pattern.
+	if _, err := c.Load("bob", []byte(testScript+"keep;\n"), opts); err != nil {
+		t.Fatal(err)
+	}
+	stats := c.Stats()
+	if stats.MatcherMisses != 1 || stats.MatcherHits != 1 {
+		t.Errorf("unexpected matcher stats: %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	var removed []string
+	c := New(Options{
+		MaxScripts: 2,
+		OnRemove: func(key Key, names []string, reason RemoveReason) {
+			if reason != RemoveEvicted {
+				t.Errorf("unexpected reason: %v", reason)
+			}
+			removed = append(removed, names...)
+		},
+	})
+	opts := sieve.DefaultOptions()
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := c.Load(name, []byte(testScript+"# "+name+"\n"), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Make "a" recently used.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	if _, err := c.Load("c", []byte(testScript+"# c\n"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(removed, []string{"b"}) {
+		t.Errorf("expected b to be evicted, got %v", removed)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("evicted script is returned by Get")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("recently used script was evicted")
+	}
+	if c.Stats().Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", c.Stats())
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	type removal struct {
+		names  []string
+		reason RemoveReason
+	}
+	var removed []removal
+	c := New(Options{
+		OnRemove: func(key Key, names []string, reason RemoveReason) {
+			sort.Strings(names)
+			removed = append(removed, removal{names, reason})
+		},
+	})
+	opts := sieve.DefaultOptions()
+
+	old, err := c.Load("alice", []byte(testScript), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Load("bob", []byte(testScript), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice uploads a new script, bob still uses the old one.
+	c.Invalidate("alice")
+	if len(removed) != 0 {
+		t.Errorf("shared script removed: %v", removed)
+	}
+	if _, ok := c.Get("alice"); ok {
+		t.Error("invalidated name is returned by Get")
+	}
+	updated, err := c.Load("alice", []byte("keep;"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == old {
+		t.Error("old script returned after invalidation")
+	}
+
+	c.Invalidate("bob")
+	if !reflect.DeepEqual(removed, []removal{{[]string{"bob"}, RemoveInvalidated}}) {
+		t.Errorf("unexpected removals: %v", removed)
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("cache is not empty after Purge: %d", c.Len())
+	}
+	if _, ok := c.Get("alice"); ok {
+		t.Error("purged script is returned by Get")
+	}
+}
+
+func TestCacheRebind(t *testing.T) {
+	type removal struct {
+		key    Key
+		names  []string
+		reason RemoveReason
+	}
+	var removed []removal
+	c := New(Options{
+		OnRemove: func(key Key, names []string, reason RemoveReason) {
+			removed = append(removed, removal{key, names, reason})
+		},
+	})
+	opts := sieve.DefaultOptions()
+
+	if _, err := c.Load("alice", []byte(testScript), opts); err != nil {
+		t.Fatal(err)
+	}
+	// alice uploads a new script without calling Invalidate.
+	updated, err := c.Load("alice", []byte("keep;"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey := KeyOf([]byte(testScript), opts)
+	if !reflect.DeepEqual(removed, []removal{{oldKey, []string{"alice"}, RemoveInvalidated}}) {
+		t.Errorf("unexpected removals: %v", removed)
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected 1 cached script, got %d", c.Len())
+	}
+	if got, ok := c.Get("alice"); !ok || got != updated {
+		t.Error("Get does not return the new script")
+	}
+
+	// Loading a cached script also rebinds the name.
+	removed = nil
+	if _, err := c.Load("alice", []byte(testScript), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Load("alice", []byte("keep;"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || c.Len() != 1 {
+		t.Errorf("unexpected removals: %v, %d cached scripts", removed, c.Len())
+	}
+}
+
+func TestCacheLoadError(t *testing.T) {
+	c := New(Options{})
+	if _, err := c.Load("alice", []byte("fileinto"), sieve.DefaultOptions()); err == nil {
+		t.Fatal("expected error")
+	}
+	if c.Len() != 0 {
+		t.Error("failed load was cached")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := New(Options{MaxScripts: 4, MaxMatchers: 4})
+	opts := sieve.DefaultOptions()
+	msg := interp.MessageStatic{
+		Size:   10,
+		Header: textproto.MIMEHeader{"Subject": {"Buy [SPAM] now"}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("user%d", (i+j)%10)
+				src := fmt.Sprintf("%s# %d\n", testScript, j%6)
+				script, err := c.Load(name, []byte(src), opts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if j%7 == 0 {
+					c.Invalidate(name)
+				}
+				d := sieve.NewRuntimeData(script, interp.DummyPolicy{}, interp.EnvelopeStatic{}, msg)
+				if err := script.Execute(context.Background(), d); err != nil {
+					t.Error(err)
+					return
+				}
+				if !reflect.DeepEqual(d.Mailboxes, []string{"Junk"}) {
+					t.Errorf("unexpected result: %v", d.Mailboxes)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if c.Len() > 4 {
+		t.Errorf("cache exceeds the limit: %d", c.Len())
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	opts := sieve.DefaultOptions()
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := sieve.Load(strings.NewReader(testScript), opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cache := New(Options{})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := cache.Load("alice", []byte(testScript), opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package cache
+
+import "container/list"
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// lru is a map with least-recently-used eviction. It is not safe for
+// concurrent use.
+type lru[K comparable, V any] struct {
+	max   int
+	ll    *list.List
+	items map[K]*list.Element
+}
+
+func newLRU[K comparable, V any](max int) *lru[K, V] {
+	return &lru[K, V]{
+		max:   max,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+}
+
+func (l *lru[K, V]) get(key K) (V, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.ll.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// peek is get that does not update the recency.
+func (l *lru[K, V]) peek(key K) (V, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// add inserts or replaces the value and returns entries evicted to keep
+// the size within the limit.
+func (l *lru[K, V]) add(key K, value V) []lruEntry[K, V] {
+	if elem, ok := l.items[key]; ok {
+		elem.Value.(*lruEntry[K, V]).value = value
+		l.ll.MoveToFront(elem)
+		return nil
+	}
+	l.items[key] = l.ll.PushFront(&lruEntry[K, V]{key: key, value: value})
+
+	var evicted []lruEntry[K, V]
+	for l.max > 0 && l.ll.Len() > l.max {
+		oldest := l.ll.Back()
+		entry := oldest.Value.(*lruEntry[K, V])
+		l.ll.Remove(oldest)
+		delete(l.items, entry.key)
+		evicted = append(evicted, *entry)
+	}
+	return evicted
+}
+
+func (l *lru[K, V]) remove(key K) (V, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.ll.Remove(elem)
+	delete(l.items, key)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+func (l *lru[K, V]) len() int {
+	return l.ll.Len()
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+type matcherKey struct {
+	regex string
+	octet bool
+}
+
+// Matchers interns compiled :matches patterns so scripts using the same
+// patterns share compiled regular expressions.
+//
+// Compile can be used as interp.Options.CompileMatcher. Matchers is safe
+// for concurrent use.
+type Matchers struct {
+	mu       sync.Mutex
+	matchers *lru[matcherKey, interp.CompiledMatcher]
+
+	hits   uint64
+	misses uint64
+}
+
+// NewMatchers creates the matcher table that keeps up to max matchers,
+// evicting least recently used ones. Zero max means no limit.
+//
+// Eviction only affects future compilations, loaded scripts keep using
+// the matchers they got.
+func NewMatchers(max int) *Matchers {
+	return &Matchers{
+		matchers: newLRU[matcherKey, interp.CompiledMatcher](max),
+	}
+}
+
+func (m *Matchers) Compile(regex string, octet bool) (interp.CompiledMatcher, error) {
+	key := matcherKey{regex: regex, octet: octet}
+
+	m.mu.Lock()
+	cm, ok := m.matchers.get(key)
+	if ok {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	m.mu.Unlock()
+	if ok {
+		return cm, nil
+	}
+
+	cm, err := interp.CompileMatcherRegex(regex, octet)
+	if err != nil {
+		return interp.CompiledMatcher{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Other goroutine might have compiled the same pattern meanwhile,
+	// prefer its matcher so all scripts share one.
+	if existing, ok := m.matchers.get(key); ok {
+		return existing, nil
+	}
+	m.matchers.add(key, cm)
+	return cm, nil
+}
+
+// Len returns the number of interned matchers.
+func (m *Matchers) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.matchers.len()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/delivery"
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+type config struct {
+	From, To, Auth string
+	Env            map[string]string
+
+	Maildir         string
+	CreateMailboxes bool
+	Sendmail        string
+
+	Script        string
+	Before, After []string
+}
+
+type jsonDelivery struct {
+	Mailbox string   `json:"mailbox"`
+	Flags   []string `json:"flags"`
+}
+
+type jsonScript struct {
+	Path     string `json:"path"`
+	Executed bool   `json:"executed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// result is the outcome of deliver, it is also printed as JSON.
+type result struct {
+	Scripts      []jsonScript   `json:"scripts"`
+	Delivered    []jsonDelivery `json:"delivered"`
+	Redirects    []string       `json:"redirects"`
+	Discarded    bool           `json:"discarded"`
+	Rejected     bool           `json:"rejected"`
+	RejectReason string         `json:"reject_reason,omitempty"`
+	Errors       []string       `json:"errors,omitempty"`
+	ExitCode     int            `json:"exit_code"`
+}
+
+func (r *result) fail(code int, err error) *result {
+	r.Errors = append(r.Errors, err.Error())
+	r.ExitCode = code
+	return r
+}
+
+// spool returns the message as io.ReaderAt. Regular files are used
+// directly, otherwise the message is copied to a temporary file.
+func spool(in *os.File) (io.ReaderAt, int64, func(), error) {
+	if info, err := in.Stat(); err == nil && info.Mode().IsRegular() {
+		return in, info.Size(), func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "sieve-deliver-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, in)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+func loadScript(path string) (*sieve.Script, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	opts := sieve.DefaultOptions()
+	opts.Lexer.Filename = path
+	return sieve.Load(bytes.NewReader(src), opts)
+}
+
+func deliver(ctx context.Context, cfg config, in *os.File) *result {
+	res := &result{
+		Delivered: []jsonDelivery{},
+		Redirects: []string{},
+		ExitCode:  exOK,
+	}
+
+	if _, err := os.Stat(filepath.Dir(filepath.Clean(cfg.Maildir))); err != nil {
+		return res.fail(exNoUser, fmt.Errorf("recipient home directory: %w", err))
+	}
+	store, err := delivery.NewMaildir(cfg.Maildir)
+	if err != nil {
+		return res.fail(exTempFail, err)
+	}
+
+	msgFile, size, cleanup, err := spool(in)
+	if err != nil {
+		return res.fail(exTempFail, fmt.Errorf("reading message: %w", err))
+	}
+	defer cleanup()
+	msg, err := interp.NewMessageReader(msgFile, size)
+	if err != nil {
+		return res.fail(exTempFail, fmt.Errorf("parsing message: %w", err))
+	}
+	envelope := interp.EnvelopeStatic{From: cfg.From, To: cfg.To, Auth: cfg.Auth}
+
+	type scriptRef struct {
+		path     string
+		optional bool
+	}
+	var scripts []scriptRef
+	for _, path := range cfg.Before {
+		scripts = append(scripts, scriptRef{path: path})
+	}
+	if cfg.Script != "" {
+		scripts = append(scripts, scriptRef{path: cfg.Script, optional: true})
+	}
+	for _, path := range cfg.After {
+		scripts = append(scripts, scriptRef{path: path})
+	}
+
+	// Scripts are loaded upfront, a script that fails to load ends the
+	// chain like a run-time error does if it is reached.
+	var (
+		chain   interp.ScriptChain
+		paths   []string
+		loadErr error
+	)
+	for _, ref := range scripts {
+		if _, err := os.Stat(ref.path); ref.optional && errors.Is(err, os.ErrNotExist) {
+			// No active script for the user.
+			continue
+		}
+		script, err := loadScript(ref.path)
+		if err != nil {
+			loadErr = err
+			paths = append(paths, ref.path)
+			break
+		}
+		chain = append(chain, script)
+		paths = append(paths, ref.path)
+	}
+
+	d := sieve.NewRuntimeData(nil, interp.DummyPolicy{}, envelope, msg)
+	d.Env = interp.MapEnv(cfg.Env)
+	runErr := chain.Execute(ctx, d)
+	// Index of the last executed script.
+	executed := -1
+	for i, script := range chain {
+		if script == d.Script {
+			executed = i
+		}
+	}
+	actions := d.AppliedActions
+	if runErr == nil && loadErr != nil && len(actions) != 0 {
+		if keep, ok := actions[len(actions)-1].(interp.ActionKeep); ok && keep.Implicit {
+			// Implicit keep is still in effect, so the broken script
+			// is reached.
+			runErr = loadErr
+			executed = len(chain)
+			actions = actions[:len(actions)-1]
+		}
+	}
+	if runErr != nil {
+		// Drop actions of the failed script and keep the message.
+		res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", paths[executed], runErr))
+		actions = append(actions, interp.ActionKeep{Implicit: true})
+	}
+	for i, path := range paths {
+		script := jsonScript{Path: path, Executed: i <= executed}
+		if runErr != nil && i == executed {
+			script.Error = runErr.Error()
+		}
+		res.Scripts = append(res.Scripts, script)
+	}
+
+	executor := &delivery.Executor{Store: store, CreateMailboxes: cfg.CreateMailboxes}
+	delivered, err := executor.Execute(ctx, actions, msgFile, size)
+	if delivered != nil {
+		for _, d := range delivered.Delivered {
+			res.Delivered = append(res.Delivered, jsonDelivery{Mailbox: d.Mailbox, Flags: d.Flags})
+		}
+		res.Redirects = append(res.Redirects, delivered.Redirects...)
+		res.Discarded = delivered.Discarded
+		res.Rejected = delivered.Rejected
+		res.RejectReason = delivered.RejectReason
+	}
+	if err != nil {
+		return res.fail(exTempFail, err)
+	}
+
+	if cfg.Sendmail != "" {
+		sent := false
+		for _, addr := range res.Redirects {
+			err := sendmail(ctx, cfg.Sendmail, cfg.From, addr, io.NewSectionReader(msgFile, 0, size))
+			if err == nil {
+				sent = true
+				continue
+			}
+			err = fmt.Errorf("redirect to %s: %w", addr, err)
+			if !sent && len(res.Delivered) == 0 {
+				// Nothing is delivered yet, so the MTA can safely retry.
+				return res.fail(exTempFail, err)
+			}
+			// A retry would deliver duplicates, report the failure only.
+			res.Errors = append(res.Errors, err.Error())
+		}
+	}
+
+	if res.Rejected {
+		res.ExitCode = exNoPerm
+	}
+	return res
+}
+
+func sendmail(ctx context.Context, command, from, to string, msg io.Reader) error {
+	cmd := exec.CommandContext(ctx, command, "-i", "-f", from, "--", to)
+	cmd.Stdin = msg
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
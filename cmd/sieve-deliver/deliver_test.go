@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testMessage = "From: alice@example.org\r\n" +
+	"To: bob@example.org\r\n" +
+	"Subject: [go-nuts] Hello\r\n" +
+	"\r\n" +
+	"Hello!\r\n"
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runDeliver(t *testing.T, cfg config) *result {
+	t.Helper()
+	msg, err := os.Open(writeFile(t, t.TempDir(), "msg.eml", testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msg.Close()
+	return deliver(context.Background(), cfg, msg)
+}
+
+func TestDeliverScripts(t *testing.T) {
+	home := t.TempDir()
+	cfg := config{
+		From:            "alice@example.org",
+		To:              "bob@example.org",
+		Maildir:         filepath.Join(home, "Maildir"),
+		CreateMailboxes: true,
+		Before: []string{writeFile(t, home, "before.sieve", `require ["fileinto", "copy"];
+fileinto :copy "Archive";`)},
+		Script: writeFile(t, home, "user.sieve", `require ["fileinto", "envelope"];
+if allof(header :contains "subject" "[go-nuts]", envelope "to" "bob@example.org") {
+	fileinto "Lists";
+}`),
+		After: []string{writeFile(t, home, "after.sieve", `discard;`)},
+	}
+
+	res := runDeliver(t, cfg)
+	if res.ExitCode != exOK {
+		t.Fatalf("unexpected exit code %d: %v", res.ExitCode, res.Errors)
+	}
+	wantDelivered := []jsonDelivery{
+		{Mailbox: "Archive", Flags: []string{}},
+		{Mailbox: "Lists", Flags: []string{}},
+	}
+	if !reflect.DeepEqual(res.Delivered, wantDelivered) {
+		t.Errorf("unexpected deliveries: %+v", res.Delivered)
+	}
+	wantScripts := []jsonScript{
+		{Path: cfg.Before[0], Executed: true},
+		{Path: cfg.Script, Executed: true},
+		{Path: cfg.After[0], Executed: false},
+	}
+	if !reflect.DeepEqual(res.Scripts, wantScripts) {
+		t.Errorf("unexpected scripts: %+v", res.Scripts)
+	}
+	for _, dir := range []string{".Archive", ".Lists"} {
+		names, _ := filepath.Glob(filepath.Join(cfg.Maildir, dir, "new", "*"))
+		if len(names) != 1 {
+			t.Errorf("%s: expected 1 message, got %d", dir, len(names))
+		}
+	}
+}
+
+func TestDeliverScriptError(t *testing.T) {
+	home := t.TempDir()
+	cfg := config{
+		Maildir: filepath.Join(home, "Maildir"),
+		Script:  writeFile(t, home, "user.sieve", `fileinto "Junk";`),
+	}
+
+	res := runDeliver(t, cfg)
+	if res.ExitCode != exOK {
+		t.Fatalf("unexpected exit code %d: %v", res.ExitCode, res.Errors)
+	}
+	if len(res.Errors) != 1 || res.Scripts[0].Error == "" {
+		t.Errorf("script error is not reported: %+v", res)
+	}
+	if !reflect.DeepEqual(res.Delivered, []jsonDelivery{{Mailbox: "INBOX", Flags: []string{}}}) {
+		t.Errorf("message is not kept: %+v", res.Delivered)
+	}
+
+	// The broken script is not reached.
+	cfg.Before = []string{writeFile(t, home, "before.sieve", `discard;`)}
+	res = runDeliver(t, cfg)
+	if res.ExitCode != exOK || len(res.Errors) != 0 || !res.Discarded {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	wantScripts := []jsonScript{
+		{Path: cfg.Before[0], Executed: true},
+		{Path: cfg.Script, Executed: false},
+	}
+	if !reflect.DeepEqual(res.Scripts, wantScripts) {
+		t.Errorf("unexpected scripts: %+v", res.Scripts)
+	}
+}
+
+func TestDeliverNoScript(t *testing.T) {
+	home := t.TempDir()
+	res := runDeliver(t, config{
+		Maildir: filepath.Join(home, "Maildir"),
+		Script:  filepath.Join(home, ".sieve"),
+	})
+	if res.ExitCode != exOK || len(res.Scripts) != 0 || len(res.Delivered) != 1 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestDeliverExitCodes(t *testing.T) {
+	home := t.TempDir()
+
+	res := runDeliver(t, config{
+		Maildir: filepath.Join(home, "Maildir"),
+		Script:  writeFile(t, home, "user.sieve", `require "ereject"; ereject "no thanks";`),
+	})
+	if res.ExitCode != exNoPerm || !res.Rejected || res.RejectReason != "no thanks" {
+		t.Errorf("unexpected result for ereject: %+v", res)
+	}
+
+	res = runDeliver(t, config{
+		Maildir: filepath.Join(home, "missing", "Maildir"),
+	})
+	if res.ExitCode != exNoUser {
+		t.Errorf("unexpected result for missing user: %+v", res)
+	}
+
+	res = runDeliver(t, config{
+		Maildir:  filepath.Join(home, "Maildir"),
+		Script:   writeFile(t, home, "redirect.sieve", `redirect "carol@example.org";`),
+		Sendmail: filepath.Join(home, "no-sendmail"),
+	})
+	if res.ExitCode != exTempFail || !reflect.DeepEqual(res.Redirects, []string{"carol@example.org"}) {
+		t.Errorf("unexpected result for failed redirect: %+v", res)
+	}
+
+	// The message is stored, so the failed redirect must not cause a retry.
+	res = runDeliver(t, config{
+		Maildir:  filepath.Join(home, "Maildir"),
+		Script:   writeFile(t, home, "copy.sieve", `require "copy"; redirect :copy "carol@example.org";`),
+		Sendmail: filepath.Join(home, "no-sendmail"),
+	})
+	if res.ExitCode != exOK || len(res.Delivered) != 1 || len(res.Errors) != 1 {
+		t.Errorf("unexpected result for failed redirect after keep: %+v", res)
+	}
+}
+
+func TestDeliverPipe(t *testing.T) {
+	home := t.TempDir()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.WriteString(testMessage)
+		w.Close()
+	}()
+	defer r.Close()
+
+	res := deliver(context.Background(), config{
+		Maildir: filepath.Join(home, "Maildir"),
+		Script: writeFile(t, home, "user.sieve", `require "fileinto";
+if header :contains "subject" "Hello" { fileinto "Hello"; }`),
+		CreateMailboxes: true,
+	}, r)
+	if res.ExitCode != exOK || !reflect.DeepEqual(res.Delivered, []jsonDelivery{{Mailbox: "Hello", Flags: []string{}}}) {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	names, _ := filepath.Glob(filepath.Join(home, "Maildir", ".Hello", "new", "*"))
+	if len(names) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(names))
+	}
+	if content, _ := os.ReadFile(names[0]); string(content) != testMessage {
+		t.Errorf("stored message differs: %q", content)
+	}
+}
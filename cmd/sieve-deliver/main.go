@@ -0,0 +1,122 @@
+// Command sieve-deliver is a local delivery agent that filters the message
+// read from stdin using Sieve scripts and stores it into Maildir.
+//
+// It can be used as Postfix or Exim mailbox_command, e.g.:
+//
+//	mailbox_command = /usr/bin/sieve-deliver -f "$SENDER" -a "$RECIPIENT"
+//
+// Global scripts given using -before are executed before the user's script
+// and -after scripts are executed after it, see interp.ScriptChain. The
+// next script is executed only if the implicit keep is still in effect
+// after the previous one. If
+// a script fails to load or execute, the actions it requested are dropped
+// and the message is kept.
+//
+// Exit codes are compatible with sendmail: EX_USAGE for invalid arguments,
+// EX_NOUSER if the home directory of the recipient does not exist,
+// EX_NOPERM if the message is rejected (the reason is written to stderr)
+// and EX_TEMPFAIL if delivery failed. Redirects are sent after the message
+// is stored, a failed redirect is only reported if a copy of the message
+// was already stored or sent, since a retry would duplicate it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes from sysexits.h.
+const (
+	exOK       = 0
+	exUsage    = 64
+	exNoUser   = 67
+	exTempFail = 75
+	exNoPerm   = 77
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.From, "f", "", "envelope sender")
+	flag.StringVar(&cfg.To, "a", "", "envelope recipient")
+	flag.StringVar(&cfg.Auth, "auth", "", "authenticated user name")
+	flag.StringVar(&cfg.Maildir, "maildir", "", "Maildir to deliver to (default $HOME/Maildir)")
+	flag.StringVar(&cfg.Script, "script", "", "active user script (default $HOME/.sieve)")
+	flag.Func("before", "global script executed before the user script (repeatable)", func(s string) error {
+		cfg.Before = append(cfg.Before, s)
+		return nil
+	})
+	flag.Func("after", "global script executed after the user script (repeatable)", func(s string) error {
+		cfg.After = append(cfg.After, s)
+		return nil
+	})
+	flag.BoolVar(&cfg.CreateMailboxes, "create-mailboxes", false, "create missing mailboxes for fileinto instead of using INBOX")
+	flag.StringVar(&cfg.Sendmail, "sendmail", "/usr/sbin/sendmail", "sendmail command used for redirect, empty to only report redirects")
+	env := map[string]string{}
+	flag.Func("env", "environment item for the environment extension as name=value (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value")
+		}
+		env[strings.ToLower(name)] = value
+		return nil
+	})
+	jsonOut := flag.Bool("json", false, "print delivery result as JSON")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		os.Exit(exUsage)
+	}
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(exUsage)
+	}
+
+	home := os.Getenv("HOME")
+	if cfg.Maildir == "" {
+		if home == "" {
+			fmt.Fprintln(os.Stderr, "sieve-deliver: -maildir is not set and $HOME is empty")
+			os.Exit(exUsage)
+		}
+		cfg.Maildir = filepath.Join(home, "Maildir")
+	}
+	if cfg.Script == "" && home != "" {
+		cfg.Script = filepath.Join(home, ".sieve")
+	}
+	cfg.Env = defaultEnv()
+	for k, v := range env {
+		cfg.Env[k] = v
+	}
+
+	res := deliver(context.Background(), cfg, os.Stdin)
+	for _, e := range res.Errors {
+		fmt.Fprintln(os.Stderr, "sieve-deliver:", e)
+	}
+	if res.Rejected {
+		fmt.Fprintln(os.Stderr, res.RejectReason)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			fmt.Fprintln(os.Stderr, "sieve-deliver:", err)
+		}
+	}
+	os.Exit(res.ExitCode)
+}
+
+func defaultEnv() map[string]string {
+	env := map[string]string{
+		"name":     "go-sieve",
+		"location": "MDA",
+		"phase":    "during",
+	}
+	if host, err := os.Hostname(); err == nil {
+		env["host"] = host
+	}
+	return env
+}
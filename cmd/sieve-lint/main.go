@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/lint"
+	"github.com/foxcpp/go-sieve/parser"
+)
+
+type jsonDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func lintFile(path string) ([]lint.Diagnostic, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := sieve.DefaultOptions()
+	opts.Lexer.Filename = path
+	opts.MaxErrors = 100
+
+	loadErr := func(err error) []lint.Diagnostic {
+		var errs sieve.ErrorList
+		if !errors.As(err, &errs) {
+			errs.Add(lexer.Position{File: path}, err)
+		}
+		diags := make([]lint.Diagnostic, 0, len(errs))
+		for _, e := range errs {
+			diags = append(diags, lint.Diagnostic{
+				Position: e.Position,
+				Severity: lint.SeverityError,
+				Code:     lint.CodeLoadError,
+				Message:  e.Message(),
+			})
+		}
+		return diags
+	}
+
+	toks, err := lexer.Lex(bytes.NewReader(src), &opts.Lexer)
+	if err != nil {
+		return loadErr(err), nil
+	}
+	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{
+		MaxBlockNesting: opts.Parser.MaxBlockNesting,
+		MaxTestNesting:  opts.Parser.MaxTestNesting,
+	})
+	if err != nil {
+		// Report all syntax errors.
+		_, err := sieve.Load(bytes.NewReader(src), opts)
+		return loadErr(err), nil
+	}
+
+	diags := lint.Lint(cmds)
+
+	if _, err := sieve.Load(bytes.NewReader(src), opts); err != nil {
+		diags = append(loadErr(err), diags...)
+	}
+
+	return diags, nil
+}
+
+func main() {
+	jsonOut := flag.Bool("json", false, "print diagnostics as JSON")
+	minSeverity := flag.String("severity", "info", "minimal severity to report (error, warning, info)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] script.sieve...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var threshold lint.Severity
+	switch *minSeverity {
+	case "error":
+		threshold = lint.SeverityError
+	case "warning":
+		threshold = lint.SeverityWarning
+	case "info":
+		threshold = lint.SeverityInfo
+	default:
+		log.Fatalln("unknown severity:", *minSeverity)
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	var jsonDiags []jsonDiagnostic
+	for _, path := range flag.Args() {
+		diags, err := lintFile(path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		for _, d := range diags {
+			if d.Severity > threshold {
+				continue
+			}
+			if d.Severity == lint.SeverityError {
+				failed = true
+			}
+			if d.Position.File == "" {
+				d.Position.File = path
+			}
+
+			if *jsonOut {
+				jsonDiags = append(jsonDiags, jsonDiagnostic{
+					File:     d.Position.File,
+					Line:     d.Position.Line,
+					Col:      d.Position.Col,
+					Severity: d.Severity.String(),
+					Code:     string(d.Code),
+					Message:  d.Message,
+				})
+				continue
+			}
+			fmt.Println(d)
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(jsonDiags); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+type docEntry struct {
+	Syntax string
+	Doc    string
+}
+
+var commandDocs = map[string]docEntry{
+	"require":    {`require <capabilities: string-list>`, "Declares extensions used by the script (RFC 5228)."},
+	"if":         {`if <test> <block>`, "Executes the block if the test is true (RFC 5228)."},
+	"elsif":      {`elsif <test> <block>`, "Executes the block if preceding tests were false and this test is true (RFC 5228)."},
+	"else":       {`else <block>`, "Executes the block if all preceding tests were false (RFC 5228)."},
+	"stop":       {`stop`, "Ends all processing of the script (RFC 5228)."},
+	"keep":       {`keep [:flags <list-of-flags: string-list>]`, "Saves the message into the default mailbox (RFC 5228)."},
+	"discard":    {`discard`, "Silently throws away the message and cancels the implicit keep (RFC 5228)."},
+	"redirect":   {`redirect [:copy] <address: string>`, "Forwards the message to the specified address (RFC 5228)."},
+	"fileinto":   {`fileinto [:copy] [:flags <list-of-flags: string-list>] <mailbox: string>`, "Saves the message into the specified mailbox. Requires \"fileinto\" (RFC 5228)."},
+	"reject":     {`reject <reason: string>`, "Refuses delivery of the message sending an MDN to the sender. Requires \"reject\" (RFC 5429)."},
+	"ereject":    {`ereject <reason: string>`, "Refuses delivery of the message at the protocol level if possible. Requires \"ereject\" (RFC 5429)."},
+	"setflag":    {`setflag [<variablename: string>] <list-of-flags: string-list>`, "Replaces the current set of IMAP flags. Requires \"imap4flags\" (RFC 5232)."},
+	"addflag":    {`addflag [<variablename: string>] <list-of-flags: string-list>`, "Adds flags to the current set of IMAP flags. Requires \"imap4flags\" (RFC 5232)."},
+	"removeflag": {`removeflag [<variablename: string>] <list-of-flags: string-list>`, "Removes flags from the current set of IMAP flags. Requires \"imap4flags\" (RFC 5232)."},
+	"set":        {`set [MODIFIER] <name: string> <value: string>`, "Assigns a value to the variable. Requires \"variables\" (RFC 5229)."},
+}
+
+var testDocs = map[string]docEntry{
+	"address":     {`address [COMPARATOR] [ADDRESS-PART] [MATCH-TYPE] <header-list: string-list> <key-list: string-list>`, "Tests addresses in structured headers (RFC 5228)."},
+	"allof":       {`allof <tests: test-list>`, "True if all of the tests are true (RFC 5228)."},
+	"anyof":       {`anyof <tests: test-list>`, "True if any of the tests is true (RFC 5228)."},
+	"envelope":    {`envelope [COMPARATOR] [ADDRESS-PART] [MATCH-TYPE] <envelope-part: string-list> <key-list: string-list>`, "Tests SMTP envelope addresses. Requires \"envelope\" (RFC 5228)."},
+	"exists":      {`exists <header-names: string-list>`, "True if all of the headers exist in the message (RFC 5228)."},
+	"false":       {`false`, "Always false (RFC 5228)."},
+	"true":        {`true`, "Always true (RFC 5228)."},
+	"header":      {`header [COMPARATOR] [MATCH-TYPE] <header-names: string-list> <key-list: string-list>`, "Tests header values (RFC 5228)."},
+	"not":         {`not <test>`, "Inverts the result of the test (RFC 5228)."},
+	"size":        {`size <":over" / ":under"> <limit: number>`, "Tests the size of the message (RFC 5228)."},
+	"string":      {`string [MATCH-TYPE] [COMPARATOR] <source: string-list> <key-list: string-list>`, "Tests strings, usually with variables. Requires \"variables\" (RFC 5229)."},
+	"hasflag":     {`hasflag [MATCH-TYPE] [COMPARATOR] [<variable-list: string-list>] <list-of-flags: string-list>`, "Tests whether flags are set. Requires \"imap4flags\" (RFC 5232)."},
+	"environment": {`environment [COMPARATOR] [MATCH-TYPE] <name: string> <key-list: string-list>`, "Tests environment items. Requires \"environment\" (RFC 5183)."},
+	"body":        {`body [COMPARATOR] [MATCH-TYPE] [BODY-TRANSFORM] <key-list: string-list>`, "Tests the message body. Requires \"body\" (RFC 5173)."},
+	"attachment":  {`attachment [COMPARATOR] [MATCH-TYPE] [":filename" / ":type" / ":size"] <key-list: string-list>`, "Tests file names, content types or sizes of attachments. Requires \"vnd.go-sieve.attachment\"."},
+}
+
+var tagDocs = map[string]docEntry{
+	"is":            {`:is`, "Match type: exact match."},
+	"contains":      {`:contains`, "Match type: substring match."},
+	"matches":       {`:matches`, "Match type: wildcard match, '*' matches any sequence and '?' matches any single character."},
+	"value":         {`:value <relational-match: string>`, "Match type: relational comparison (gt, ge, lt, le, eq, ne). Requires \"relational\" (RFC 5231)."},
+	"count":         {`:count <relational-match: string>`, "Match type: compares the number of values. Requires \"relational\" (RFC 5231)."},
+	"comparator":    {`:comparator <comparator-name: string>`, "Selects the comparator used for matching, e.g. \"i;octet\"."},
+	"all":           {`:all`, "Address part: the whole address."},
+	"localpart":     {`:localpart`, "Address part: the part before '@'."},
+	"domain":        {`:domain`, "Address part: the part after '@'."},
+	"user":          {`:user`, "Address part: the user part of the local-part. Requires \"subaddress\" (RFC 5233)."},
+	"detail":        {`:detail`, "Address part: the detail part of the local-part. Requires \"subaddress\" (RFC 5233)."},
+	"over":          {`:over`, "Size test: true if the message is larger than the limit."},
+	"under":         {`:under`, "Size test: true if the message is smaller than the limit."},
+	"copy":          {`:copy`, "Keeps the implicit keep in effect. Requires \"copy\" (RFC 3894)."},
+	"flags":         {`:flags <list-of-flags: string-list>`, "Sets IMAP flags for the stored message. Requires \"imap4flags\" (RFC 5232)."},
+	"raw":           {`:raw`, "Body transform: the undecoded message body."},
+	"text":          {`:text`, "Body transform: the text extracted from the message body."},
+	"content":       {`:content <content-types: string-list>`, "Body transform: MIME parts with the matching content types."},
+	"filename":      {`:filename`, "Attachment part: the file name of the attachment."},
+	"type":          {`:type`, "Attachment part: the content type of the attachment without parameters."},
+	"size":          {`:size`, "Attachment part: the decoded size of the attachment in bytes."},
+	"lower":         {`:lower`, "Variable modifier: converts the value to lower case."},
+	"upper":         {`:upper`, "Variable modifier: converts the value to upper case."},
+	"lowerfirst":    {`:lowerfirst`, "Variable modifier: converts the first character to lower case."},
+	"upperfirst":    {`:upperfirst`, "Variable modifier: converts the first character to upper case."},
+	"quotewildcard": {`:quotewildcard`, "Variable modifier: escapes wildcard characters."},
+	"length":        {`:length`, "Variable modifier: replaces the value with its length in characters."},
+}
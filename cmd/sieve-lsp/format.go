@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+)
+
+// formatScript re-indents the script using one tab per block level and
+// strips trailing whitespace. Comments and strings are preserved as is,
+// lines that start inside a multi-line string or comment are not touched.
+func formatScript(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var (
+		depth        int
+		inQuoted     bool
+		inMultiline  bool
+		inComment    bool
+		out          strings.Builder
+		escapeQuoted bool
+	)
+	for i, line := range lines {
+		if i != 0 {
+			out.WriteByte('\n')
+		}
+
+		if inMultiline {
+			if line == "." {
+				inMultiline = false
+			}
+			out.WriteString(line)
+			continue
+		}
+
+		verbatim := inQuoted || inComment
+		trimmed := strings.TrimSpace(line)
+		if !verbatim {
+			lineDepth := depth
+			if strings.HasPrefix(trimmed, "}") {
+				lineDepth--
+			}
+			if lineDepth < 0 {
+				lineDepth = 0
+			}
+			if trimmed != "" {
+				out.WriteString(strings.Repeat("\t", lineDepth))
+			}
+			out.WriteString(trimmed)
+		} else {
+			out.WriteString(strings.TrimRight(line, " \t"))
+		}
+
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			switch {
+			case inComment:
+				if c == '*' && j+1 < len(line) && line[j+1] == '/' {
+					inComment = false
+					j++
+				}
+			case inQuoted:
+				if escapeQuoted {
+					escapeQuoted = false
+				} else if c == '\\' {
+					escapeQuoted = true
+				} else if c == '"' {
+					inQuoted = false
+				}
+			case c == '#':
+				j = len(line)
+			case c == '/' && j+1 < len(line) && line[j+1] == '*':
+				inComment = true
+				j++
+			case c == '"':
+				inQuoted = true
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+			case strings.HasPrefix(line[j:], "text:") && (j == 0 || !isIdentChar(line[j-1])):
+				inMultiline = true
+				j = len(line)
+			}
+		}
+	}
+	return out.String()
+}
+
+func isIdentChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
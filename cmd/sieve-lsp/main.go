@@ -0,0 +1,34 @@
+// Command sieve-lsp is a Language Server Protocol implementation for Sieve.
+//
+// It communicates with the editor over stdin/stdout and provides
+// diagnostics, hover, completion, go-to-definition for variables
+// and formatting.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	logPath := flag.String("log", "", "write log to the file instead of stderr")
+	flag.Parse()
+
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	if err := newServer(os.Stdin, os.Stdout).Run(); err != nil {
+		if errors.Is(err, errNoShutdown) {
+			os.Exit(1)
+		}
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/interp"
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/lint"
+	"github.com/foxcpp/go-sieve/parser"
+)
+
+const maxReportedErrors = 100
+
+type document struct {
+	text  string
+	lines []string
+}
+
+func newDocument(text string) *document {
+	return &document{
+		text:  text,
+		lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"),
+	}
+}
+
+// toLSP converts the script position (1-based line, 1-based byte column)
+// into LSP position (0-based line, 0-based UTF-16 offset).
+func (d *document) toLSP(pos lexer.Position) lspPosition {
+	line := pos.Line - 1
+	if line < 0 {
+		line = 0
+	}
+	if line >= len(d.lines) {
+		line = len(d.lines) - 1
+	}
+	col := pos.Col - 1
+	if col < 0 {
+		col = 0
+	}
+	text := d.lines[line]
+	if col > len(text) {
+		col = len(text)
+	}
+	return lspPosition{Line: line, Character: utf16Len(text[:col])}
+}
+
+// byteOffset returns the line text and the byte offset within it
+// for the LSP position.
+func (d *document) byteOffset(pos lspPosition) (string, int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return "", 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return text, i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return text, len(text)
+}
+
+// wordRange returns the range of the identifier or string
+// starting at the script position.
+func (d *document) wordRange(pos lexer.Position) lspRange {
+	start := d.toLSP(pos)
+	text, offset := d.byteOffset(start)
+	end := offset
+	for end < len(text) && isIdentChar(text[end]) {
+		end++
+	}
+	if end == offset && end < len(text) {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return lspRange{
+		Start: start,
+		End:   lspPosition{Line: start.Line, Character: utf16Len(text[:end])},
+	}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+type server struct {
+	in   rpcReader
+	out  *rpcWriter
+	docs map[string]*document
+
+	// shutdown is set once 'shutdown' request is received, after that
+	// only 'exit' notification is accepted.
+	shutdown bool
+}
+
+// errNoShutdown is returned by Run if 'exit' notification is received
+// without a preceding 'shutdown' request.
+var errNoShutdown = errors.New("exit without shutdown request")
+
+func newServer(in io.Reader, out io.Writer) *server {
+	return &server{
+		in:   rpcReader{r: bufio.NewReader(in)},
+		out:  &rpcWriter{w: out},
+		docs: map[string]*document{},
+	}
+}
+
+// Run processes messages until 'exit' notification is received
+// or the input stream is closed.
+//
+// errNoShutdown is returned if the client exits without sending
+// 'shutdown' first, the server process should exit with status 1 then.
+func (s *server) Run() error {
+	for {
+		msg, err := s.in.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			var rpcErr *rpcError
+			if errors.As(err, &rpcErr) {
+				if err := s.out.Reply(nil, nil, rpcErr); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errNoShutdown
+			}
+			return nil
+		}
+
+		var result interface{}
+		if s.shutdown {
+			// Notifications are dropped, requests are rejected.
+			err = &rpcError{Code: codeInvalidRequest, Message: "server is shut down"}
+		} else {
+			result, err = s.handle(msg)
+		}
+		if msg.ID == nil {
+			if err != nil && !s.shutdown {
+				log.Printf("notification %s: %v", msg.Method, err)
+			}
+			continue
+		}
+		if err := s.out.Reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(msg *rpcMessage) (interface{}, error) {
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(msg.Params, v); err != nil {
+			return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		res := initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync: 1, // full
+				HoverProvider:    true,
+				CompletionProvider: &completionOptions{
+					TriggerCharacters: []string{":", "\""},
+				},
+				DefinitionProvider:         true,
+				DocumentFormattingProvider: true,
+			},
+		}
+		res.ServerInfo.Name = "sieve-lsp"
+		return res, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		s.docs[params.TextDocument.URI] = newDocument(params.TextDocument.Text)
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		s.docs[params.TextDocument.URI] = newDocument(text)
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.out.Notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []lspDiagnostic{},
+		})
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.hover(params.Position), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(params.Position), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.definition(params.TextDocument.URI, params.Position), nil
+	case "textDocument/formatting":
+		var params formattingParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.formatting(), nil
+	}
+
+	if msg.ID == nil {
+		// Unknown notifications ($/cancelRequest, etc) are ignored.
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+func (s *server) doc(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "unknown document: " + uri}
+	}
+	return doc, nil
+}
+
+func (s *server) publishDiagnostics(uri string) error {
+	return s.out.Notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: s.docs[uri].diagnostics(),
+	})
+}
+
+func (d *document) parse() ([]parser.Cmd, error) {
+	toks, err := lexer.Lex(strings.NewReader(d.text), &lexer.Options{})
+	if err != nil {
+		return nil, err
+	}
+	opts := sieve.DefaultOptions().Parser
+	return parser.ParseRecover(lexer.NewStream(toks), &opts, maxReportedErrors)
+}
+
+func (d *document) diagnostics() []lspDiagnostic {
+	diags := []lspDiagnostic{}
+
+	opts := sieve.DefaultOptions()
+	opts.MaxErrors = maxReportedErrors
+	if _, err := sieve.Load(strings.NewReader(d.text), opts); err != nil {
+		var errs sieve.ErrorList
+		if !errors.As(err, &errs) {
+			errs.Add(lexer.Position{}, err)
+		}
+		for _, e := range errs {
+			diags = append(diags, lspDiagnostic{
+				Range:    d.wordRange(e.Position),
+				Severity: severityError,
+				Code:     string(lint.CodeLoadError),
+				Source:   "go-sieve",
+				Message:  e.Message(),
+			})
+		}
+	}
+
+	cmds, err := d.parse()
+	if err != nil {
+		return diags
+	}
+	for _, ld := range lint.Lint(cmds) {
+		severity := severityInformation
+		switch ld.Severity {
+		case lint.SeverityError:
+			severity = severityError
+		case lint.SeverityWarning:
+			severity = severityWarning
+		}
+		diags = append(diags, lspDiagnostic{
+			Range:    d.wordRange(ld.Position),
+			Severity: severity,
+			Code:     string(ld.Code),
+			Source:   "go-sieve",
+			Message:  ld.Message,
+		})
+	}
+	return diags
+}
+
+func (d *document) hover(pos lspPosition) interface{} {
+	_, offset := d.byteOffset(pos)
+	col := offset + 1
+
+	toks, err := lexer.Lex(strings.NewReader(d.text), &lexer.Options{})
+	if err != nil {
+		return nil
+	}
+	for i, tok := range toks {
+		id, ok := tok.(lexer.Identifier)
+		if !ok || id.Line != pos.Line+1 {
+			continue
+		}
+		if col < id.Col || col >= id.Col+len(id.Text) {
+			continue
+		}
+
+		name := strings.ToLower(id.Text)
+		var (
+			entry docEntry
+			found bool
+		)
+		if i > 0 {
+			if _, isTag := toks[i-1].(lexer.Colon); isTag {
+				entry, found = tagDocs[name]
+			}
+		}
+		if !found {
+			entry, found = commandDocs[name]
+		}
+		if !found {
+			entry, found = testDocs[name]
+		}
+		if !found {
+			return nil
+		}
+
+		rng := d.wordRange(id.Position)
+		return hover{
+			Contents: markupContent{
+				Kind:  "markdown",
+				Value: "```sieve\n" + entry.Syntax + "\n```\n\n" + entry.Doc,
+			},
+			Range: &rng,
+		}
+	}
+	return nil
+}
+
+var (
+	tagPrefix     = regexp.MustCompile(`:[a-zA-Z_]*$`)
+	requirePrefix = regexp.MustCompile(`(?i)require\s*(\[[^\]]*)?"[^"]*$`)
+	wordPrefix    = regexp.MustCompile(`[a-zA-Z_]*$`)
+	testContext   = regexp.MustCompile(`(?i)(\bif|\belsif|\bnot|\(|,)\s*$`)
+)
+
+func docItems(docs map[string]docEntry, kind int) []completionItem {
+	items := make([]completionItem, 0, len(docs))
+	for name, entry := range docs {
+		items = append(items, completionItem{
+			Label:         name,
+			Kind:          kind,
+			Detail:        entry.Syntax,
+			Documentation: entry.Doc,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+func (d *document) completion(pos lspPosition) []completionItem {
+	line, offset := d.byteOffset(pos)
+	prefix := line[:offset]
+
+	if requirePrefix.MatchString(prefix) {
+		exts := interp.SupportedExtensions()
+		items := make([]completionItem, 0, len(exts))
+		for _, ext := range exts {
+			items = append(items, completionItem{Label: ext, Kind: completionKindModule})
+		}
+		return items
+	}
+	if tagPrefix.MatchString(prefix) {
+		return docItems(tagDocs, completionKindProperty)
+	}
+
+	before := strings.TrimSuffix(prefix, wordPrefix.FindString(prefix))
+	if testContext.MatchString(before) {
+		return docItems(testDocs, completionKindFunction)
+	}
+	return docItems(commandDocs, completionKindKeyword)
+}
+
+var variableRef = regexp.MustCompile(`\${([a-zA-Z_][a-zA-Z0-9_]*)}`)
+
+func (d *document) definition(uri string, pos lspPosition) []lspLocation {
+	line, offset := d.byteOffset(pos)
+
+	var name string
+	for _, loc := range variableRef.FindAllStringSubmatchIndex(line, -1) {
+		if offset >= loc[0] && offset < loc[1] {
+			name = strings.ToLower(line[loc[2]:loc[3]])
+			break
+		}
+	}
+	if name == "" {
+		return []lspLocation{}
+	}
+
+	cmds, _ := d.parse()
+	locations := []lspLocation{}
+	var walk func(cmds []parser.Cmd)
+	walk = func(cmds []parser.Cmd) {
+		for _, cmd := range cmds {
+			switch strings.ToLower(cmd.Id) {
+			case "set", "setflag", "addflag", "removeflag":
+				var strArgs []parser.StringArg
+				for _, a := range cmd.Args {
+					if a, ok := a.(parser.StringArg); ok {
+						strArgs = append(strArgs, a)
+					}
+				}
+				if len(strArgs) == 0 || !strings.EqualFold(strArgs[0].Value, name) {
+					break
+				}
+				if !strings.EqualFold(cmd.Id, "set") && len(cmd.Args) < 2 {
+					break
+				}
+				start := d.toLSP(strArgs[0].Position)
+				locations = append(locations, lspLocation{
+					URI: uri,
+					Range: lspRange{
+						Start: start,
+						End: lspPosition{
+							Line:      start.Line,
+							Character: start.Character + utf16Len(strArgs[0].Value) + 2,
+						},
+					},
+				})
+			}
+			walk(cmd.Block)
+		}
+	}
+	walk(cmds)
+	return locations
+}
+
+func (d *document) formatting() []textEdit {
+	formatted := formatScript(d.text)
+	if formatted == strings.ReplaceAll(d.text, "\r\n", "\n") {
+		return []textEdit{}
+	}
+	last := len(d.lines) - 1
+	return []textEdit{{
+		Range: lspRange{
+			Start: lspPosition{},
+			End:   lspPosition{Line: last, Character: utf16Len(d.lines[last])},
+		},
+		NewText: formatted,
+	}}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite expected server messages in testdata/*.session")
+
+// Session files contain client messages prefixed with "--> " and
+// expected server messages prefixed with "<-- ", one JSON value per line.
+// Lines starting with '#' are comments.
+
+func frame(msg string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(msg), msg)
+}
+
+func runSession(t *testing.T, path string) {
+	t.Helper()
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		input    bytes.Buffer
+		expected []string
+		header   []string
+	)
+	for _, line := range strings.Split(string(blob), "\n") {
+		switch {
+		case strings.HasPrefix(line, "--> "):
+			input.WriteString(frame(line[4:]))
+			header = append(header, line)
+		case strings.HasPrefix(line, "<-- "):
+			expected = append(expected, line[4:])
+		case strings.HasPrefix(line, "#"):
+			header = append(header, line)
+		}
+	}
+
+	var output bytes.Buffer
+	if err := newServer(&input, &output).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	r := rpcReader{r: bufio.NewReader(&output)}
+	for {
+		msg, err := r.Read()
+		if err != nil {
+			break
+		}
+		blob, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, string(blob))
+	}
+
+	if *update {
+		var out strings.Builder
+		for _, l := range header {
+			out.WriteString(l + "\n")
+		}
+		for _, l := range actual {
+			out.WriteString("<-- " + l + "\n")
+		}
+		if err := os.WriteFile(path, []byte(out.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d server messages, got %d:\n%s", len(expected), len(actual), strings.Join(actual, "\n"))
+	}
+	for i := range expected {
+		var exp, act interface{}
+		if err := json.Unmarshal([]byte(expected[i]), &exp); err != nil {
+			t.Fatalf("malformed expected message %d: %v", i, err)
+		}
+		if err := json.Unmarshal([]byte(actual[i]), &act); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(exp, act) {
+			t.Errorf("message %d mismatch\nexpected: %s\nactual:   %s", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	sessions, err := filepath.Glob(filepath.Join("testdata", "*.session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) == 0 {
+		t.Fatal("no sessions found")
+	}
+	for _, path := range sessions {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			runSession(t, path)
+		})
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	input := frame(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`) +
+		frame(`{"jsonrpc":"2.0","method":"exit"}`)
+	var output bytes.Buffer
+	if err := newServer(strings.NewReader(input), &output).Run(); !errors.Is(err, errNoShutdown) {
+		t.Errorf("expected errNoShutdown, got %v", err)
+	}
+}
+
+func TestFormatScript(t *testing.T) {
+	in := `require "fileinto";
+if header :is "subject" "a" {
+  # comment {
+fileinto "a";   
+      if true {
+keep;
+}
+    /* multi
+       line */
+}
+set "a" text:
+  {
+.
+;
+`
+	expected := `require "fileinto";
+if header :is "subject" "a" {
+	# comment {
+	fileinto "a";
+	if true {
+		keep;
+	}
+	/* multi
+       line */
+}
+set "a" text:
+  {
+.
+;
+`
+	if actual := formatScript(in); actual != expected {
+		t.Errorf("wrong formatting:\n%s", actual)
+	}
+}
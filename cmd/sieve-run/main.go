@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/textproto"
 	"os"
 	"strings"
 	"time"
@@ -20,6 +19,7 @@ func main() {
 	scriptPath := flag.String("scriptPath", "", "scriptPath to run")
 	envFrom := flag.String("from", "", "envelope from")
 	envTo := flag.String("to", "", "envelope to")
+	trace := flag.Bool("trace", false, "print executed commands and evaluated tests")
 	flag.Parse()
 
 	msg, err := os.Open(*msgPath)
@@ -31,19 +31,18 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	msgHdr, err := textproto.NewReader(bufio.NewReader(msg)).ReadMIMEHeader()
+	msgData, err := interp.NewMessageReader(msg, fileInfo.Size())
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	script, err := os.Open(*scriptPath)
+	script, err := os.ReadFile(*scriptPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer script.Close()
 
 	start := time.Now()
-	loadedScript, err := sieve.Load(script, sieve.DefaultOptions())
+	loadedScript, err := sieve.Load(bytes.NewReader(script), sieve.DefaultOptions())
 	end := time.Now()
 	if err != nil {
 		log.Fatalln(err)
@@ -54,12 +53,12 @@ func main() {
 		From: *envFrom,
 		To:   *envTo,
 	}
-	msgData := interp.MessageStatic{
-		Size:   int(fileInfo.Size()),
-		Header: msgHdr,
-	}
 	data := sieve.NewRuntimeData(loadedScript, interp.DummyPolicy{},
 		envData, msgData)
+	recorder := &interp.TraceRecorder{}
+	if *trace {
+		data.Tracer = recorder
+	}
 
 	ctx := context.Background()
 	start = time.Now()
@@ -69,6 +68,10 @@ func main() {
 	end = time.Now()
 	log.Println("script executed in", end.Sub(start))
 
+	if *trace {
+		printTrace(os.Stdout, recorder.Entries, data.AppliedActions)
+	}
+
 	fmt.Println("redirect:", data.RedirectAddr)
 	fmt.Println("fileinfo:", data.Mailboxes)
 	fmt.Println("keep:", data.ImplicitKeep || data.Keep)
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+func printTrace(w io.Writer, entries []interp.TraceEntry, actions []interp.AppliedAction) {
+	fmt.Fprintln(w, "trace:")
+	for _, e := range entries {
+		indent := strings.Repeat("  ", e.Depth+1)
+		if e.Kind == interp.TraceImplicitKeep {
+			fmt.Fprintf(w, "%simplicit keep", indent)
+		} else {
+			fmt.Fprintf(w, "%s%v: %s %s -> %v", indent, e.Position, e.Kind, e.Name, e.Result)
+		}
+		if len(e.Compared) != 0 {
+			quoted := make([]string, len(e.Compared))
+			for i, v := range e.Compared {
+				quoted[i] = strconv.Quote(v)
+			}
+			fmt.Fprintf(w, " compared [%s]", strings.Join(quoted, ", "))
+		}
+		for _, v := range e.Variables {
+			fmt.Fprintf(w, " ${%s}=%q", v.Name, v.Value)
+		}
+		for _, i := range e.Actions {
+			fmt.Fprintf(w, " => %#v", actions[i])
+		}
+		fmt.Fprintln(w)
+	}
+}
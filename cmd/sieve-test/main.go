@@ -0,0 +1,95 @@
+// Command sieve-test executes a Sieve script for a message file and prints
+// the resulting actions. It mimics the sieve-test tool of Pigeonhole, so
+// problems reported by Dovecot users can be reproduced with go-sieve:
+//
+//	sieve-test [options] <script-file> <mail-file>
+//
+// The message is not delivered anywhere unless -e is given, in that case
+// the actions are executed against the Maildir given using -l (default
+// $HOME/Maildir). Redirects are never sent.
+//
+// Options:
+//
+//	-f <address>  envelope sender
+//	-a <address>  envelope recipient
+//	-m <mailbox>  default mailbox used by keep (default INBOX)
+//	-e            store the message into the Maildir
+//	-l <path>     Maildir used by -e
+//	-t <file>     write the execution trace to file, "-" for stdout
+//	-D            print debug messages to stderr
+//
+// Exit codes follow sysexits.h: EX_USAGE for invalid arguments, EX_NOINPUT
+// if the script or the message cannot be read, EX_DATAERR if the script
+// fails to load, EX_SOFTWARE if it fails to execute and EX_TEMPFAIL if
+// the actions cannot be executed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Exit codes from sysexits.h.
+const (
+	exOK       = 0
+	exUsage    = 64
+	exDataErr  = 65
+	exNoInput  = 66
+	exSoftware = 70
+	exTempFail = 75
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.From, "f", "", "envelope sender")
+	flag.StringVar(&cfg.To, "a", "", "envelope recipient")
+	flag.StringVar(&cfg.DefaultMailbox, "m", "INBOX", "default mailbox used by keep")
+	flag.BoolVar(&cfg.Execute, "e", false, "execute the actions against the Maildir given using -l")
+	flag.StringVar(&cfg.Maildir, "l", "", "Maildir used by -e (default $HOME/Maildir)")
+	tracePath := flag.String("t", "", "write the execution trace to file, \"-\" for stdout")
+	flag.BoolVar(&cfg.Debug, "D", false, "print debug messages to stderr")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <script-file> <mail-file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		os.Exit(exUsage)
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(exUsage)
+	}
+	cfg.Script, cfg.Message = flag.Arg(0), flag.Arg(1)
+
+	if cfg.Execute && cfg.Maildir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			fmt.Fprintln(os.Stderr, "sieve-test: -l is not set and $HOME is empty")
+			os.Exit(exUsage)
+		}
+		cfg.Maildir = filepath.Join(home, "Maildir")
+	}
+
+	if *tracePath != "" {
+		if *tracePath == "-" {
+			cfg.Trace = os.Stdout
+		} else {
+			f, err := os.Create(*tracePath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "sieve-test:", err)
+				os.Exit(exUsage)
+			}
+			cfg.Trace = f
+		}
+	}
+
+	code := run(context.Background(), cfg, os.Stdout, os.Stderr)
+	if f, ok := cfg.Trace.(*os.File); ok && f != os.Stdout {
+		f.Close()
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// actionText describes the action the same way as Pigeonhole's
+// sieve_result_print.
+func actionText(act interp.AppliedAction, defaultMailbox string) string {
+	switch act := act.(type) {
+	case interp.ActionKeep:
+		return "store message in folder: " + defaultMailbox
+	case interp.ActionFileInto:
+		return "store message in folder: " + act.Mailbox
+	case interp.ActionRedirect:
+		return "redirect message to: " + act.Address
+	case interp.ActionDiscard:
+		return "discard"
+	case interp.ActionReject:
+		return "reject message with reason: " + act.Reason
+	case interp.ActionEReject:
+		return "ereject message with reason: " + act.Reason
+	}
+	return fmt.Sprintf("unknown action: %T", act)
+}
+
+// actionFlags returns the flags set by keep and fileinto.
+func actionFlags(act interp.AppliedAction) interp.Flags {
+	switch act := act.(type) {
+	case interp.ActionKeep:
+		return act.Flags
+	case interp.ActionFileInto:
+		return act.Flags
+	}
+	return nil
+}
+
+func printAction(w io.Writer, act interp.AppliedAction, defaultMailbox string) {
+	fmt.Fprintf(w, " * %s\n", actionText(act, defaultMailbox))
+	if flags := actionFlags(act); len(flags) != 0 {
+		fmt.Fprintf(w, "        + add IMAP flags: %s\n", strings.Join(flags, " "))
+	}
+}
+
+// printResult prints the actions in the format of Pigeonhole's sieve-test:
+// explicit actions, followed by the implicit keep if it is in effect.
+func printResult(w io.Writer, actions []interp.AppliedAction, defaultMailbox string) {
+	var implicitKeep interp.AppliedAction
+	fmt.Fprint(w, "\nPerformed actions:\n\n")
+	performed := 0
+	for _, act := range actions {
+		if keep, ok := act.(interp.ActionKeep); ok && keep.Implicit {
+			implicitKeep = act
+			continue
+		}
+		printAction(w, act, defaultMailbox)
+		performed++
+	}
+	if performed == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+
+	fmt.Fprint(w, "\nImplicit keep:\n\n")
+	if implicitKeep != nil {
+		printAction(w, implicitKeep, defaultMailbox)
+	} else {
+		fmt.Fprintln(w, "  (none)")
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"time"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/delivery"
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+type config struct {
+	Script, Message string
+	From, To        string
+	DefaultMailbox  string
+
+	// Execute the actions against Maildir.
+	Execute bool
+	Maildir string
+
+	// Trace receives the execution trace if not nil.
+	Trace io.Writer
+	Debug bool
+}
+
+// readMessage loads the message file. A leading mbox "From " line is
+// skipped, as done by Pigeonhole.
+func readMessage(path string) (interp.Message, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(raw, []byte("From ")) {
+		if i := bytes.IndexByte(raw, '\n'); i != -1 {
+			raw = raw[i+1:]
+		}
+	}
+
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	hdr, err := r.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing message header: %w", err)
+	}
+	if hdr == nil {
+		hdr = textproto.MIMEHeader{}
+	}
+	return interp.DecodedMessage{Message: interp.MessageStatic{
+		Size:       len(raw),
+		Header:     hdr,
+		RawMessage: raw,
+	}}, nil
+}
+
+// run executes the script and prints the result to stdout, errors and
+// debug messages are written to stderr. It returns the exit code.
+func run(ctx context.Context, cfg config, stdout, stderr io.Writer) int {
+	debugf := func(format string, args ...interface{}) {
+		if cfg.Debug {
+			fmt.Fprintf(stderr, "sieve-test: debug: "+format+"\n", args...)
+		}
+	}
+
+	src, err := os.ReadFile(cfg.Script)
+	if err != nil {
+		fmt.Fprintln(stderr, "sieve-test:", err)
+		return exNoInput
+	}
+	msg, err := readMessage(cfg.Message)
+	if err != nil {
+		fmt.Fprintln(stderr, "sieve-test:", err)
+		return exNoInput
+	}
+	debugf("message %s: %d bytes", cfg.Message, msg.MessageSize())
+
+	opts := sieve.DefaultOptions()
+	opts.Lexer.Filename = cfg.Script
+	start := time.Now()
+	script, err := sieve.Load(bytes.NewReader(src), opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "sieve-test: failed to compile script %s: %v\n", cfg.Script, err)
+		return exDataErr
+	}
+	debugf("script %s loaded in %v, extensions: %v", cfg.Script, time.Since(start), script.Extensions())
+
+	envelope := interp.EnvelopeStatic{From: cfg.From, To: cfg.To}
+	debugf("envelope: from <%s> to <%s>", envelope.From, envelope.To)
+	d := sieve.NewRuntimeData(script, interp.DummyPolicy{}, envelope, msg)
+	d.Env = interp.MapEnv{
+		"name":     "go-sieve",
+		"location": "MS",
+		"phase":    "post",
+	}
+	recorder := &interp.TraceRecorder{}
+	if cfg.Trace != nil {
+		d.Tracer = recorder
+	}
+
+	start = time.Now()
+	execErr := script.Execute(ctx, d)
+	debugf("script executed in %v", time.Since(start))
+	if cfg.Trace != nil {
+		printTrace(cfg.Trace, cfg.Script, recorder.Entries, d.AppliedActions, cfg.DefaultMailbox)
+	}
+	if execErr != nil {
+		fmt.Fprintf(stderr, "sieve-test: failed to execute script %s: %v\n", cfg.Script, execErr)
+		return exSoftware
+	}
+
+	printResult(stdout, d.AppliedActions, cfg.DefaultMailbox)
+
+	if !cfg.Execute {
+		return exOK
+	}
+
+	store, err := delivery.NewMaildir(cfg.Maildir)
+	if err != nil {
+		fmt.Fprintln(stderr, "sieve-test:", err)
+		return exTempFail
+	}
+	testEnv := delivery.NewTestEnvironment(store)
+	testEnv.Executor.DefaultMailbox = cfg.DefaultMailbox
+	var env interp.ExecuteTestEnvironment = testEnv
+	if err := env.ExecuteActions(d, d.AppliedActions); err != nil {
+		fmt.Fprintln(stderr, "sieve-test: final result: failed:", err)
+		return exTempFail
+	}
+	for i := 0; ; i++ {
+		ok, err := env.HasSMTPMessage(i)
+		if err != nil || !ok {
+			break
+		}
+		redirect, err := env.GetSMTPMessage(i)
+		if err != nil {
+			break
+		}
+		fmt.Fprintf(stderr, "sieve-test: info: redirect to <%s> is not sent\n", redirect.Envelope.EnvelopeTo())
+	}
+	fmt.Fprintln(stderr, "sieve-test: info: final result: success")
+	return exOK
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From alice@example.org Mon Jan  1 00:00:00 2024\n" +
+	"From: alice@example.org\r\n" +
+	"To: bob@example.org\r\n" +
+	"Subject: =?utf-8?q?Ihre_Rechnung_f=C3=BCr_Mai?=\r\n" +
+	"\r\n" +
+	"Hello!\r\n"
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runTest(t *testing.T, cfg config, script string) (int, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.Script = writeFile(t, dir, "test.sieve", script)
+	cfg.Message = writeFile(t, dir, "msg.eml", testMessage)
+	if cfg.DefaultMailbox == "" {
+		cfg.DefaultMailbox = "INBOX"
+	}
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), cfg, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunResult(t *testing.T) {
+	code, stdout, stderr := runTest(t, config{From: "alice@example.org"}, `require ["fileinto", "envelope", "imap4flags"];
+if allof(header :contains "subject" "Rechnung", envelope "from" "alice@example.org") {
+	fileinto :flags "\\Seen" "Invoices";
+	redirect "archive@example.org";
+}`)
+	if code != exOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	want := `
+Performed actions:
+
+ * store message in folder: Invoices
+        + add IMAP flags: \Seen
+ * redirect message to: archive@example.org
+
+Implicit keep:
+
+  (none)
+
+`
+	if stdout != want {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+}
+
+func TestRunImplicitKeep(t *testing.T) {
+	code, stdout, stderr := runTest(t, config{DefaultMailbox: "Other"}, `require "imap4flags";
+addflag "$Label";`)
+	if code != exOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	want := `
+Performed actions:
+
+  (none)
+
+Implicit keep:
+
+ * store message in folder: Other
+        + add IMAP flags: $Label
+
+`
+	if stdout != want {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+}
+
+func TestRunExecute(t *testing.T) {
+	maildir := filepath.Join(t.TempDir(), "Maildir")
+	code, _, stderr := runTest(t, config{Execute: true, Maildir: maildir}, `redirect "archive@example.org";
+keep;`)
+	if code != exOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "redirect to <archive@example.org> is not sent") {
+		t.Errorf("redirect is not reported: %s", stderr)
+	}
+	entries, err := os.ReadDir(filepath.Join(maildir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(entries))
+	}
+	stored, err := os.ReadFile(filepath.Join(maildir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, []byte("From: alice@example.org")) {
+		t.Errorf("mbox From line is not removed: %q", stored)
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	var trace bytes.Buffer
+	code, _, stderr := runTest(t, config{Trace: &trace}, `require "fileinto";
+if header :is "to" "bob@example.org" {
+	fileinto "Bob";
+}`)
+	if code != exOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+	for _, line := range []string{
+		"   2:   header test -> true",
+		`        compared: "bob@example.org"`,
+		"   2: if -> true",
+		"   3:   fileinto command",
+		"        => store message in folder: Bob",
+	} {
+		if !strings.Contains(trace.String(), line+"\n") {
+			t.Errorf("trace does not contain %q:\n%s", line, trace.String())
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if code, _, _ := runTest(t, config{}, `fileinto "x";`); code != exDataErr {
+		t.Errorf("unexpected exit code for invalid script: %d", code)
+	}
+	if code := run(context.Background(), config{Script: "/nonexistent", Message: "/nonexistent"}, &bytes.Buffer{}, &bytes.Buffer{}); code != exNoInput {
+		t.Errorf("unexpected exit code for missing script: %d", code)
+	}
+}
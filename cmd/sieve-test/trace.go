@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// printTrace writes the trace in a layout similar to Pigeonhole's
+// "-t" output: one line per command or test prefixed with the line number,
+// followed by compared values, variables and produced actions.
+func printTrace(w io.Writer, name string, entries []interp.TraceEntry, actions []interp.AppliedAction, defaultMailbox string) {
+	fmt.Fprintf(w, "## Started executing script '%s'\n", name)
+	for _, e := range entries {
+		indent := strings.Repeat("  ", e.Depth)
+		switch {
+		case e.Kind == interp.TraceImplicitKeep:
+			fmt.Fprintf(w, "## implicit keep\n")
+		case e.Kind == interp.TraceTest:
+			fmt.Fprintf(w, "%4d: %s%s test -> %v\n", e.Position.Line, indent, e.Name, e.Result)
+		case isControl(e.Cmd):
+			fmt.Fprintf(w, "%4d: %s%s -> %v\n", e.Position.Line, indent, e.Name, e.Result)
+		default:
+			fmt.Fprintf(w, "%4d: %s%s command\n", e.Position.Line, indent, e.Name)
+		}
+
+		detail := "      " + indent
+		if len(e.Compared) != 0 {
+			quoted := make([]string, len(e.Compared))
+			for i, v := range e.Compared {
+				quoted[i] = strconv.Quote(v)
+			}
+			fmt.Fprintf(w, "%scompared: %s\n", detail, strings.Join(quoted, ", "))
+		}
+		for _, v := range e.Variables {
+			fmt.Fprintf(w, "%s${%s} = %q\n", detail, v.Name, v.Value)
+		}
+		for _, i := range e.Actions {
+			fmt.Fprintf(w, "%s=> %s\n", detail, actionText(actions[i], defaultMailbox))
+		}
+	}
+	fmt.Fprintf(w, "## Finished executing script '%s'\n", name)
+}
+
+func isControl(cmd interp.Cmd) bool {
+	switch cmd.(type) {
+	case interp.CmdIf, interp.CmdElsif, interp.CmdElse:
+		return true
+	}
+	return false
+}
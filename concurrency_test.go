@@ -0,0 +1,102 @@
+package sieve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+const concurrentScript = `require ["fileinto", "imap4flags", "variables", "body", "envelope", "subaddress", "relational"];
+if header :matches "subject" "* a *" {
+	set :upperfirst "gift" "${2}";
+}
+if envelope :detail :matches "to" "*" {
+	set "detail" "${1}";
+}
+addflag "\\Seen";
+if body :contains "anvil" {
+	addflag "$Anvil";
+}
+if header :count "ge" "received" "1" {
+	discard;
+	stop;
+}
+fileinto "${gift}-${detail}";
+keep;
+`
+
+// TestExecuteConcurrent runs a single loaded script for many messages at
+// once. Run it with -race to check that executions do not share state.
+func TestExecuteConcurrent(t *testing.T) {
+	loaded, err := Load(strings.NewReader(concurrentScript), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := loaded.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreSaved(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgHdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(eml))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	execute := func(script *Script, i int) ([]interp.AppliedAction, error) {
+		env := interp.EnvelopeStatic{
+			From: "coyote@desert.example.org",
+			To:   fmt.Sprintf("roadrunner+%d@acme.example.com", i),
+		}
+		msg := interp.MessageStatic{Size: len(eml), Header: msgHdr, RawMessage: []byte(eml)}
+		d := NewRuntimeData(script, interp.DummyPolicy{}, env, msg)
+		if err := script.Execute(context.Background(), d); err != nil {
+			return nil, err
+		}
+		return d.AppliedActions, nil
+	}
+
+	const workers, executions = 16, 50
+	expected := make([][]interp.AppliedAction, executions)
+	for i := range expected {
+		expected[i], err = execute(loaded, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, script := range map[string]*Script{"loaded": loaded, "restored": restored} {
+		script := script
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < executions; i++ {
+						actions, err := execute(script, i)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if !reflect.DeepEqual(actions, expected[i]) {
+							t.Errorf("execution %d: got %#v, want %#v", i, actions, expected[i])
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
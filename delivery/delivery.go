@@ -0,0 +1,211 @@
+// Package delivery implements the execution of Sieve actions by storing
+// the message into local mailboxes.
+//
+// Executor takes the actions recorded in RuntimeData.AppliedActions by
+// Script.Execute and applies them to a Store. Maildir (Maildir++ layout)
+// and Mbox stores are provided.
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// ErrNoMailbox is returned by Store.Deliver if the mailbox does not exist.
+var ErrNoMailbox = errors.New("delivery: mailbox does not exist")
+
+// Store is a mail storage messages are delivered to.
+//
+// Mailbox names use "/" as the hierarchy separator, "INBOX" is the
+// default mailbox that always exists. Flags are IMAP flags and keywords
+// as used by the imap4flags extension.
+type Store interface {
+	CreateMailbox(name string) error
+	// Deliver stores the message into the mailbox. It returns ErrNoMailbox
+	// if the mailbox does not exist.
+	Deliver(mailbox string, flags []string, msg io.Reader) error
+	// Messages returns the messages stored in the mailbox in the order
+	// of delivery.
+	Messages(mailbox string) ([]StoredMessage, error)
+}
+
+type StoredMessage struct {
+	Flags []string
+	Open  func() (io.ReadCloser, error)
+}
+
+// Delivery describes a copy of the message stored by Executor.
+type Delivery struct {
+	Mailbox string
+	Flags   []string
+}
+
+// Result is the outcome of Executor.Execute.
+type Result struct {
+	Delivered []Delivery
+
+	// Redirects are the addresses the message should be forwarded to.
+	// Executor does not send messages, this is up to the caller.
+	Redirects []string
+
+	// Rejected is set if the message was refused by reject or ereject.
+	// RejectReason is the reason given by the script. If RejectSMTP is
+	// set (ereject), the reason should be returned in the SMTP response,
+	// otherwise the caller is expected to send a rejection message to the
+	// envelope sender.
+	Rejected     bool
+	RejectSMTP   bool
+	RejectReason string
+
+	// Discarded is set if the message was not stored anywhere because of
+	// an explicit discard.
+	Discarded bool
+}
+
+type Executor struct {
+	Store Store
+
+	// DefaultMailbox is used by keep and implicit keep. Defaults to "INBOX".
+	DefaultMailbox string
+
+	// CreateMailboxes enables the creation of mailboxes that do not exist
+	// for fileinto. If it is not set, such messages are stored into
+	// DefaultMailbox (RFC 5228, Section 4.1).
+	CreateMailboxes bool
+}
+
+func (e *Executor) defaultMailbox() string {
+	if e.DefaultMailbox == "" {
+		return "INBOX"
+	}
+	return e.DefaultMailbox
+}
+
+// Execute applies actions to the message. msg is the complete message,
+// size is its length in bytes.
+//
+// The message is stored at most once into each mailbox, flags of
+// all actions targeting the same mailbox are merged. If storing fails,
+// Execute returns the error together with the Result describing copies
+// stored so far.
+func (e *Executor) Execute(ctx context.Context, actions []interp.AppliedAction, msg io.ReaderAt, size int64) (*Result, error) {
+	res := &Result{}
+
+	var (
+		mailboxes []string
+		flags     = make(map[string][]string)
+	)
+	addMailbox := func(name string, fl []string) {
+		if _, ok := flags[name]; !ok {
+			mailboxes = append(mailboxes, name)
+			flags[name] = []string{}
+		}
+		flags[name] = mergeFlags(flags[name], fl)
+	}
+
+	for _, act := range actions {
+		switch act := act.(type) {
+		case interp.ActionKeep:
+			addMailbox(e.defaultMailbox(), act.Flags)
+		case interp.ActionFileInto:
+			addMailbox(act.Mailbox, act.Flags)
+		case interp.ActionRedirect:
+			res.Redirects = append(res.Redirects, act.Address)
+		case interp.ActionDiscard:
+			res.Discarded = true
+		case interp.ActionReject:
+			res.Rejected = true
+			res.RejectReason = act.Reason
+		case interp.ActionEReject:
+			res.Rejected = true
+			res.RejectSMTP = true
+			res.RejectReason = act.Reason
+		default:
+			return res, fmt.Errorf("delivery: unsupported action: %T", act)
+		}
+	}
+	if len(mailboxes) != 0 {
+		res.Discarded = false
+	}
+
+	for _, mbox := range mailboxes {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		target, err := e.deliver(mbox, flags[mbox], io.NewSectionReader(msg, 0, size))
+		if err != nil {
+			return res, fmt.Errorf("delivery: %s: %w", mbox, err)
+		}
+		res.Delivered = append(res.Delivered, Delivery{Mailbox: target, Flags: flags[mbox]})
+	}
+
+	return res, nil
+}
+
+func (e *Executor) deliver(mailbox string, flags []string, msg io.ReadSeeker) (string, error) {
+	err := e.Store.Deliver(mailbox, flags, msg)
+	if !errors.Is(err, ErrNoMailbox) {
+		return mailbox, err
+	}
+
+	if e.CreateMailboxes {
+		if err := e.Store.CreateMailbox(mailbox); err != nil {
+			return mailbox, err
+		}
+	} else {
+		mailbox = e.defaultMailbox()
+	}
+	if _, err := msg.Seek(0, io.SeekStart); err != nil {
+		return mailbox, err
+	}
+	return mailbox, e.Store.Deliver(mailbox, flags, msg)
+}
+
+func mergeFlags(dst, src []string) []string {
+	for _, f := range src {
+		found := false
+		for _, existing := range dst {
+			if strings.EqualFold(existing, f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, f)
+		}
+	}
+	return dst
+}
+
+// validateMailboxName checks that the mailbox name can be safely mapped
+// to a file name and returns its hierarchy components.
+func validateMailboxName(name string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty mailbox name")
+	}
+	parts := strings.Split(name, "/")
+	for _, p := range parts {
+		switch {
+		case p == "", p == ".", p == "..":
+			return nil, fmt.Errorf("invalid mailbox name: %q", name)
+		case strings.ContainsAny(p, "\x00\\"):
+			return nil, fmt.Errorf("invalid mailbox name: %q", name)
+		}
+	}
+	return parts, nil
+}
+
+// systemFlagName converts the lowercase system flag name used as a key
+// in flag tables to the usual spelling (e.g. `\seen` to `\Seen`).
+func systemFlagName(flag string) string {
+	return `\` + strings.ToUpper(flag[1:2]) + flag[2:]
+}
+
+func isInbox(name string) bool {
+	return strings.EqualFold(name, "INBOX")
+}
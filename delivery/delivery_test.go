@@ -0,0 +1,248 @@
+package delivery
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+const testMessage = "From: alice@example.org\r\n" +
+	"Subject: test\r\n" +
+	"\r\n" +
+	"Hello!\r\n"
+
+func runScript(t *testing.T, script string) []interp.AppliedAction {
+	t.Helper()
+	loaded, err := sieve.Load(strings.NewReader(script), sieve.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := interp.NewMessageReader(strings.NewReader(testMessage), int64(len(testMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := sieve.NewRuntimeData(loaded, interp.DummyPolicy{}, interp.EnvelopeStatic{}, msg)
+	if err := loaded.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	return d.AppliedActions
+}
+
+func execute(t *testing.T, e *Executor, actions []interp.AppliedAction) *Result {
+	t.Helper()
+	res, err := e.Execute(context.Background(), actions, strings.NewReader(testMessage), int64(len(testMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func readStored(t *testing.T, store Store, mailbox string) ([]string, [][]string) {
+	t.Helper()
+	msgs, err := store.Messages(mailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		contents []string
+		flags    [][]string
+	)
+	for _, m := range msgs {
+		rc, err := m.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+		flags = append(flags, m.Flags)
+	}
+	return contents, flags
+}
+
+func TestExecutorMaildir(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMaildir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateMailbox("Lists/Go"); err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{Store: store}
+
+	res := execute(t, e, runScript(t, `require ["fileinto", "imap4flags"];
+fileinto :flags ["\\Seen", "urgent"] "Lists/Go";
+fileinto "Missing";
+keep :flags "\\Flagged";
+keep;
+`))
+	wantDelivered := []Delivery{
+		{Mailbox: "Lists/Go", Flags: []string{"\\Seen", "urgent"}},
+		{Mailbox: "INBOX", Flags: []string{}},
+		{Mailbox: "INBOX", Flags: []string{"\\Flagged"}},
+	}
+	if !reflect.DeepEqual(res.Delivered, wantDelivered) {
+		t.Errorf("unexpected deliveries: %+v", res.Delivered)
+	}
+
+	contents, flags := readStored(t, store, "Lists/Go")
+	if !reflect.DeepEqual(contents, []string{testMessage}) || !reflect.DeepEqual(flags, [][]string{{"\\Seen", "urgent"}}) {
+		t.Errorf("unexpected Lists/Go content: %q %q", contents, flags)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, ".Lists.Go", "cur", "*:2,Sa"))
+	if err != nil || len(names) != 1 {
+		t.Errorf("message with Maildir flags not found: %v %v", names, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".Lists.Go", "maildirfolder")); err != nil {
+		t.Error(err)
+	}
+
+	// The missing mailbox falls back to INBOX.
+	_, flags = readStored(t, store, "INBOX")
+	if !reflect.DeepEqual(flags, [][]string{nil, {"\\Flagged"}}) {
+		t.Errorf("unexpected INBOX flags: %q", flags)
+	}
+}
+
+func TestExecutorCreateMailboxes(t *testing.T) {
+	store, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{Store: store, CreateMailboxes: true}
+
+	res := execute(t, e, runScript(t, `require "fileinto"; fileinto "Café";`))
+	if len(res.Delivered) != 1 || res.Delivered[0].Mailbox != "Café" {
+		t.Errorf("unexpected deliveries: %+v", res.Delivered)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path, ".Caf&AOk-")); err != nil {
+		t.Error("mailbox name is not encoded:", err)
+	}
+	if contents, _ := readStored(t, store, "Café"); len(contents) != 1 {
+		t.Errorf("expected 1 message, got %d", len(contents))
+	}
+}
+
+func TestExecutorNoDelivery(t *testing.T) {
+	store, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{Store: store}
+
+	res := execute(t, e, runScript(t, `discard;`))
+	if !res.Discarded || len(res.Delivered) != 0 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	res = execute(t, e, runScript(t, `require "ereject"; ereject "go away";`))
+	if !res.Rejected || !res.RejectSMTP || res.RejectReason != "go away" || len(res.Delivered) != 0 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	res = execute(t, e, runScript(t, `redirect "bob@example.org";`))
+	if !reflect.DeepEqual(res.Redirects, []string{"bob@example.org"}) || len(res.Delivered) != 0 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	if contents, _ := readStored(t, store, "INBOX"); len(contents) != 0 {
+		t.Errorf("unexpected messages in INBOX: %q", contents)
+	}
+}
+
+func TestMailboxNameValidation(t *testing.T) {
+	maildir, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox, err := NewMbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "../x", "a//b", "a/../b", "a\x00b", "a\\b"} {
+		if err := maildir.CreateMailbox(name); err == nil {
+			t.Errorf("Maildir: %q accepted", name)
+		}
+		if err := mbox.CreateMailbox(name); err == nil {
+			t.Errorf("Mbox: %q accepted", name)
+		}
+	}
+}
+
+func TestMaildirDottedName(t *testing.T) {
+	store, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{Store: store, CreateMailboxes: true}
+
+	res := execute(t, e, runScript(t, `require "fileinto"; fileinto "Lists.Go";`))
+	if len(res.Delivered) != 1 || res.Delivered[0].Mailbox != "Lists.Go" {
+		t.Errorf("unexpected deliveries: %+v", res.Delivered)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path, `.Lists\2eGo`)); err != nil {
+		t.Error("dot in mailbox name is not escaped:", err)
+	}
+	if contents, _ := readStored(t, store, "Lists.Go"); len(contents) != 1 {
+		t.Errorf("expected 1 message, got %d", len(contents))
+	}
+}
+
+func TestTestEnvironment(t *testing.T) {
+	store, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewTestEnvironment(store)
+
+	opts := sieve.DefaultOptions()
+	opts.Interp.T = t
+	script, err := sieve.Load(strings.NewReader(`require ["vnd.dovecot.testsuite", "envelope", "fileinto", "imap4flags"];
+test_set "message" text:
+From: alice@example.org
+Subject: Hello
+
+Body
+.
+;
+test_set "envelope.from" "alice@example.org";
+test_mailbox_create "Folder";
+
+test "fileinto" {
+	fileinto :flags "\\Seen" "Folder";
+	if not test_result_execute {
+		test_fail "execute failed";
+	}
+	test_message :folder "Folder" 0;
+	if not header :is "subject" "Hello" {
+		test_fail "wrong message stored";
+	}
+	if not hasflag "\\Seen" {
+		test_fail "flags not stored";
+	}
+	if not envelope :is "from" "alice@example.org" {
+		test_fail "envelope not recorded";
+	}
+}
+`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := sieve.NewRuntimeData(script, interp.DummyPolicy{}, interp.EnvelopeStatic{}, interp.MessageStatic{})
+	d.Test = &interp.TestRuntime{Name: t.Name(), Execute: env}
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+}
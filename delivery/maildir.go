@@ -0,0 +1,353 @@
+package delivery
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maildirFlags maps IMAP system flags to Maildir info flags.
+var maildirFlags = map[string]byte{
+	`\draft`:    'D',
+	`\flagged`:  'F',
+	`\answered`: 'R',
+	`\seen`:     'S',
+	`\deleted`:  'T',
+}
+
+const maildirKeywordsFile = "dovecot-keywords"
+
+var maildirSeq atomic.Uint64
+
+// Maildir is a Store that keeps mailboxes in the Maildir++ layout: Path is
+// the INBOX and other mailboxes are stored in the ".Name.Child" subdirectories
+// of it with names encoded using modified UTF-7. Dots in mailbox names are
+// escaped as "\2e", as done by the listescape plugin of Dovecot.
+//
+// Keywords are mapped to the letters a-z using the dovecot-keywords
+// file of the mailbox, keywords that do not fit are not stored.
+// Concurrent deliveries from multiple processes are safe, but concurrent
+// updates of dovecot-keywords are only synchronized within the process.
+type Maildir struct {
+	Path string
+
+	keywordsLock sync.Mutex
+}
+
+func NewMaildir(path string) (*Maildir, error) {
+	m := &Maildir{Path: path}
+	if err := createMaildir(path, false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func createMaildir(path string, folder bool) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(path, sub), 0o700); err != nil {
+			return err
+		}
+	}
+	if folder {
+		// Marks Maildir++ subfolders for Courier-compatible software.
+		f, err := os.OpenFile(filepath.Join(path, "maildirfolder"), os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+func (m *Maildir) mailboxPath(name string) (string, error) {
+	if isInbox(name) {
+		return m.Path, nil
+	}
+	parts, err := validateMailboxName(name)
+	if err != nil {
+		return "", err
+	}
+	for i, p := range parts {
+		// Backslashes are not allowed in names, so the escape is unambiguous.
+		parts[i] = strings.ReplaceAll(encodeMUTF7(p), ".", `\2e`)
+	}
+	return filepath.Join(m.Path, "."+strings.Join(parts, ".")), nil
+}
+
+func (m *Maildir) CreateMailbox(name string) error {
+	path, err := m.mailboxPath(name)
+	if err != nil {
+		return err
+	}
+	return createMaildir(path, !isInbox(name))
+}
+
+func (m *Maildir) Deliver(mailbox string, flags []string, msg io.Reader) error {
+	path, err := m.mailboxPath(mailbox)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(path, "tmp")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNoMailbox
+		}
+		return err
+	}
+
+	info, err := m.infoFlags(path, flags)
+	if err != nil {
+		return err
+	}
+
+	base := uniqueName()
+	tmpPath := filepath.Join(path, "tmp", base)
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(f, msg)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	name := base + ",S=" + strconv.FormatInt(size, 10)
+	dir := "new"
+	if info != "" {
+		name += ":2," + info
+		dir = "cur"
+	}
+	if err := os.Rename(tmpPath, filepath.Join(path, dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// uniqueName returns the unique part of the message file name
+// as recommended by https://cr.yp.to/proto/maildir.html.
+func uniqueName() string {
+	now := time.Now()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s",
+		now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirSeq.Add(1), host)
+}
+
+// infoFlags returns the Maildir info flags for IMAP flags, registering new
+// keywords in dovecot-keywords.
+func (m *Maildir) infoFlags(path string, flags []string) (string, error) {
+	var (
+		letters  []byte
+		keywords []string
+	)
+	for _, f := range flags {
+		if strings.HasPrefix(f, `\`) {
+			if l, ok := maildirFlags[strings.ToLower(f)]; ok {
+				letters = append(letters, l)
+			}
+			continue
+		}
+		keywords = append(keywords, f)
+	}
+
+	if len(keywords) != 0 {
+		m.keywordsLock.Lock()
+		defer m.keywordsLock.Unlock()
+
+		known, err := readMaildirKeywords(path)
+		if err != nil {
+			return "", err
+		}
+		changed := false
+		for _, kw := range keywords {
+			idx := -1
+			for i, k := range known {
+				if k == kw {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				if len(known) == 26 {
+					continue
+				}
+				known = append(known, kw)
+				idx = len(known) - 1
+				changed = true
+			}
+			letters = append(letters, byte('a'+idx))
+		}
+		if changed {
+			if err := writeMaildirKeywords(path, known); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return string(letters), nil
+}
+
+func readMaildirKeywords(path string) ([]string, error) {
+	f, err := os.Open(filepath.Join(path, maildirKeywordsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var keywords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		idxStr, kw, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil || idx < 0 || idx >= 26 {
+			continue
+		}
+		for len(keywords) <= idx {
+			keywords = append(keywords, "")
+		}
+		keywords[idx] = kw
+	}
+	return keywords, scanner.Err()
+}
+
+func writeMaildirKeywords(path string, keywords []string) error {
+	var b strings.Builder
+	for i, kw := range keywords {
+		if kw != "" {
+			fmt.Fprintf(&b, "%d %s\n", i, kw)
+		}
+	}
+	tmp := filepath.Join(path, "tmp", maildirKeywordsFile+"."+uniqueName())
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(path, maildirKeywordsFile))
+}
+
+type maildirEntry struct {
+	path           string
+	sec, usec, seq int64
+	name           string
+	info           string
+}
+
+func parseMaildirName(dir, name string) maildirEntry {
+	e := maildirEntry{path: filepath.Join(dir, name), name: name}
+	base, info, _ := strings.Cut(name, ":2,")
+	e.info = info
+	fields := strings.SplitN(base, ".", 3)
+	e.sec, _ = strconv.ParseInt(fields[0], 10, 64)
+	if len(fields) > 1 {
+		// M<usec>P<pid>Q<seq>
+		rest := fields[1]
+		if usec, rest, ok := strings.Cut(strings.TrimPrefix(rest, "M"), "P"); ok {
+			e.usec, _ = strconv.ParseInt(usec, 10, 64)
+			if _, seq, ok := strings.Cut(rest, "Q"); ok {
+				e.seq, _ = strconv.ParseInt(seq, 10, 64)
+			}
+		}
+	}
+	return e
+}
+
+func (m *Maildir) Messages(mailbox string) ([]StoredMessage, error) {
+	path, err := m.mailboxPath(mailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []maildirEntry
+	for _, sub := range []string{"new", "cur"} {
+		dir := filepath.Join(path, sub)
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, ErrNoMailbox
+			}
+			return nil, err
+		}
+		for _, de := range dirEntries {
+			if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+				continue
+			}
+			entries = append(entries, parseMaildirName(dir, de.Name()))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.sec != b.sec {
+			return a.sec < b.sec
+		}
+		if a.usec != b.usec {
+			return a.usec < b.usec
+		}
+		if a.seq != b.seq {
+			return a.seq < b.seq
+		}
+		return a.name < b.name
+	})
+
+	keywords, err := readMaildirKeywords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]StoredMessage, 0, len(entries))
+	for _, e := range entries {
+		e := e
+		msgs = append(msgs, StoredMessage{
+			Flags: imapFlags(e.info, keywords),
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(e.path)
+			},
+		})
+	}
+	return msgs, nil
+}
+
+// imapFlags converts Maildir info flags back to IMAP flags.
+func imapFlags(info string, keywords []string) []string {
+	var flags []string
+	for i := 0; i < len(info); i++ {
+		l := info[i]
+		if l >= 'a' && l <= 'z' {
+			if idx := int(l - 'a'); idx < len(keywords) && keywords[idx] != "" {
+				flags = append(flags, keywords[idx])
+			}
+			continue
+		}
+		for flag, fl := range maildirFlags {
+			if fl == l {
+				flags = append(flags, systemFlagName(flag))
+			}
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
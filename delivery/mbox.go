@@ -0,0 +1,358 @@
+package delivery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mboxStatusFlags and mboxXStatusFlags map IMAP system flags to letters
+// of the Status and X-Status header fields used by mbox readers.
+var (
+	mboxStatusFlags = map[string]byte{
+		`\seen`: 'R',
+	}
+	mboxXStatusFlags = map[string]byte{
+		`\answered`: 'A',
+		`\flagged`:  'F',
+		`\draft`:    'T',
+		`\deleted`:  'D',
+	}
+)
+
+const (
+	mboxLockRetry   = 100 * time.Millisecond
+	mboxLockTimeout = 10 * time.Second
+	mboxLockStale   = 5 * time.Minute
+)
+
+// Mbox is a Store that keeps each mailbox in a separate file in the mboxrd
+// format. Mailbox "A/B" is stored in the file Dir/A/B.
+//
+// Flags are stored in the Status, X-Status and X-Keywords header fields,
+// such fields present in the delivered message are removed. Files are
+// locked using dotlocks while they are written.
+type Mbox struct {
+	Dir string
+
+	// From is the address used in the "From " separator lines.
+	// Defaults to "MAILER-DAEMON".
+	From string
+
+	lock sync.Mutex
+}
+
+func NewMbox(dir string) (*Mbox, error) {
+	m := &Mbox{Dir: dir}
+	if err := m.CreateMailbox("INBOX"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Mbox) mailboxPath(name string) (string, error) {
+	if isInbox(name) {
+		return filepath.Join(m.Dir, "INBOX"), nil
+	}
+	parts, err := validateMailboxName(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(parts[len(parts)-1], ".lock") {
+		return "", fmt.Errorf("invalid mailbox name: %q", name)
+	}
+	return filepath.Join(append([]string{m.Dir}, parts...)...), nil
+}
+
+func (m *Mbox) CreateMailbox(name string) error {
+	path, err := m.mailboxPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (m *Mbox) Deliver(mailbox string, flags []string, msg io.Reader) error {
+	path, err := m.mailboxPath(mailbox)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNoMailbox
+		}
+		return err
+	}
+	defer f.Close()
+
+	unlock, err := dotlock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := m.writeMessage(f, info.Size() != 0, flags, msg); err != nil {
+		// Do not leave a partial message behind.
+		f.Truncate(info.Size())
+		return err
+	}
+	return f.Sync()
+}
+
+func (m *Mbox) writeMessage(f *os.File, separate bool, flags []string, msg io.Reader) error {
+	from := m.From
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+
+	w := bufio.NewWriter(f)
+	if separate {
+		w.WriteString("\n")
+	}
+	fmt.Fprintf(w, "From %s %s\n", from, time.Now().UTC().Format(time.ANSIC))
+	writeMboxFlags(w, flags)
+
+	r := bufio.NewReader(msg)
+	inHeader, skipping := true, false
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) != 0 {
+			line = bytes.TrimSuffix(line, []byte("\r\n"))
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			isContinuation := len(line) != 0 && (line[0] == ' ' || line[0] == '\t')
+			switch {
+			case inHeader && len(line) == 0:
+				inHeader = false
+			case inHeader && isContinuation && skipping:
+				continue
+			case inHeader && !isContinuation:
+				skipping = isMboxFlagField(line)
+				if skipping {
+					continue
+				}
+			}
+
+			if isFromLine(line) {
+				w.WriteByte('>')
+			}
+			w.Write(line)
+			w.WriteByte('\n')
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func writeMboxFlags(w *bufio.Writer, flags []string) {
+	var status, xstatus []byte
+	var keywords []string
+	for _, f := range flags {
+		if l, ok := mboxStatusFlags[strings.ToLower(f)]; ok {
+			status = append(status, l)
+		} else if l, ok := mboxXStatusFlags[strings.ToLower(f)]; ok {
+			xstatus = append(xstatus, l)
+		} else if !strings.HasPrefix(f, `\`) {
+			keywords = append(keywords, f)
+		}
+	}
+	if len(status) != 0 {
+		fmt.Fprintf(w, "Status: %sO\n", status)
+	}
+	if len(xstatus) != 0 {
+		fmt.Fprintf(w, "X-Status: %s\n", xstatus)
+	}
+	if len(keywords) != 0 {
+		fmt.Fprintf(w, "X-Keywords: %s\n", strings.Join(keywords, " "))
+	}
+}
+
+func isMboxFlagField(line []byte) bool {
+	name, _, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(string(bytes.TrimSpace(name))) {
+	case "status", "x-status", "x-keywords":
+		return true
+	}
+	return false
+}
+
+// isFromLine reports whether the line needs to be escaped in mboxrd
+// format (matches ">*From ").
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// dotlock creates the path.lock file, waiting while it exists.
+func dotlock(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(mboxLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > mboxLockStale {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", lockPath)
+		}
+		time.Sleep(mboxLockRetry)
+	}
+}
+
+func (m *Mbox) Messages(mailbox string) ([]StoredMessage, error) {
+	path, err := m.mailboxPath(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoMailbox
+		}
+		return nil, err
+	}
+
+	var msgs []StoredMessage
+	for _, raw := range splitMbox(data) {
+		content, flags := parseMboxMessage(raw)
+		msgs = append(msgs, StoredMessage{
+			Flags: flags,
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(content)), nil
+			},
+		})
+	}
+	return msgs, nil
+}
+
+// splitMbox splits the mbox file into messages without the "From " lines.
+func splitMbox(data []byte) [][]byte {
+	var (
+		msgs      [][]byte
+		current   []byte
+		started   bool
+		prevEmpty = true
+	)
+	for len(data) != 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		if prevEmpty && bytes.HasPrefix(line, []byte("From ")) {
+			if started {
+				// Remove the empty line separating messages.
+				msgs = append(msgs, bytes.TrimSuffix(current, []byte("\n")))
+			}
+			current, started = nil, true
+			prevEmpty = false
+			continue
+		}
+		prevEmpty = len(bytes.TrimRight(line, "\r\n")) == 0
+		if started {
+			current = append(current, line...)
+		}
+	}
+	if started {
+		msgs = append(msgs, current)
+	}
+	return msgs
+}
+
+// parseMboxMessage unescapes the message, converting it to CRLF line
+// endings, and extracts flags from its header.
+func parseMboxMessage(raw []byte) ([]byte, []string) {
+	var (
+		content  []byte
+		flags    []string
+		inHeader = true
+	)
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if inHeader {
+			if len(line) == 0 {
+				inHeader = false
+			} else if isMboxFlagField(line) {
+				flags = append(flags, parseMboxFlagField(line)...)
+				continue
+			}
+		}
+		if line2 := bytes.TrimLeft(line, ">"); len(line2) != len(line) && bytes.HasPrefix(line2, []byte("From ")) {
+			line = line[1:]
+		}
+		content = append(content, line...)
+		content = append(content, '\r', '\n')
+	}
+	sort.Strings(flags)
+	return content, flags
+}
+
+func parseMboxFlagField(line []byte) []string {
+	name, value, _ := bytes.Cut(line, []byte(":"))
+	value = bytes.TrimSpace(value)
+
+	var flags []string
+	lookup := func(table map[string]byte) {
+		for _, l := range value {
+			for flag, fl := range table {
+				if fl == l {
+					flags = append(flags, systemFlagName(flag))
+				}
+			}
+		}
+	}
+	switch strings.ToLower(string(bytes.TrimSpace(name))) {
+	case "status":
+		lookup(mboxStatusFlags)
+	case "x-status":
+		lookup(mboxXStatusFlags)
+	case "x-keywords":
+		flags = append(flags, strings.Fields(string(value))...)
+	}
+	return flags
+}
@@ -0,0 +1,68 @@
+package delivery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMbox(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateMailbox("Lists/Go"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := "Subject: first\r\n" +
+		"Status: RO\r\n" +
+		"X-Keywords: forged\r\n" +
+		" continued\r\n" +
+		"\r\n" +
+		"From the start\r\n" +
+		">From quoted\r\n"
+	second := "Subject: second\r\n" +
+		"\r\n" +
+		"No trailing newline"
+
+	if err := store.Deliver("Lists/Go", []string{"\\Seen", "\\Answered", "work"}, strings.NewReader(first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Deliver("Lists/Go", nil, strings.NewReader(second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Deliver("Missing", nil, strings.NewReader(second)); err != ErrNoMailbox {
+		t.Errorf("expected ErrNoMailbox, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "Lists", "Go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n>From the start\n>>From quoted\n\nFrom MAILER-DAEMON ") {
+		t.Errorf("message is not escaped:\n%s", data)
+	}
+	if strings.Contains(string(data), "forged") {
+		t.Errorf("flag fields of the message are not removed:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Lists", "Go.lock")); !os.IsNotExist(err) {
+		t.Error("lock file is not removed")
+	}
+
+	contents, flags := readStored(t, store, "Lists/Go")
+	wantContents := []string{
+		"Subject: first\r\n\r\nFrom the start\r\n>From quoted\r\n",
+		"Subject: second\r\n\r\nNo trailing newline\r\n",
+	}
+	if !reflect.DeepEqual(contents, wantContents) {
+		t.Errorf("unexpected contents: %q", contents)
+	}
+	wantFlags := [][]string{{"\\Answered", "\\Seen", "work"}, nil}
+	if !reflect.DeepEqual(flags, wantFlags) {
+		t.Errorf("unexpected flags: %q", flags)
+	}
+}
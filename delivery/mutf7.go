@@ -0,0 +1,47 @@
+package delivery
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf16"
+)
+
+var mutf7Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// encodeMUTF7 encodes the mailbox name using modified UTF-7
+// (RFC 3501, Section 5.1.3) as done by IMAP servers for on-disk names.
+func encodeMUTF7(s string) string {
+	var (
+		b       strings.Builder
+		pending []rune
+	)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		units := utf16.Encode(pending)
+		buf := make([]byte, 0, len(units)*2)
+		for _, u := range units {
+			buf = append(buf, byte(u>>8), byte(u))
+		}
+		b.WriteByte('&')
+		b.WriteString(mutf7Encoding.EncodeToString(buf))
+		b.WriteByte('-')
+		pending = pending[:0]
+	}
+
+	for _, r := range s {
+		if r >= 0x20 && r <= 0x7e {
+			flush()
+			if r == '&' {
+				b.WriteString("&-")
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		pending = append(pending, r)
+	}
+	flush()
+	return b.String()
+}
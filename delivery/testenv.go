@@ -0,0 +1,139 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// TestEnvironment implements interp.ExecuteTestEnvironment using Executor,
+// so vnd.dovecot.testsuite execute tests (see tests.RunExecuteTests) check
+// messages actually stored by it.
+//
+// Messages sent using redirect are kept in memory.
+type TestEnvironment struct {
+	Executor *Executor
+
+	smtp      []*interp.ExecuteTestMessage
+	envelopes map[string][]interp.Envelope
+}
+
+func NewTestEnvironment(store Store) *TestEnvironment {
+	return &TestEnvironment{
+		Executor:  &Executor{Store: store},
+		envelopes: make(map[string][]interp.Envelope),
+	}
+}
+
+// storedMessage is a message read back from the Store. raw is kept
+// so the message can be delivered again.
+type storedMessage struct {
+	*interp.MessageReader
+	raw []byte
+}
+
+func rawMessage(msg interp.Message) ([]byte, error) {
+	switch msg := msg.(type) {
+	case storedMessage:
+		return msg.raw, nil
+	case interp.MessageStatic:
+		// Empty if the test did not set the message.
+		return msg.RawMessage, nil
+	case interp.DecodedMessage:
+		return rawMessage(msg.Message)
+	}
+	return nil, fmt.Errorf("delivery: message content is not available for %T", msg)
+}
+
+func (e *TestEnvironment) CreateMailbox(name string) error {
+	return e.Executor.Store.CreateMailbox(name)
+}
+
+func (e *TestEnvironment) GetDefaultMailbox() string {
+	return e.Executor.defaultMailbox()
+}
+
+func (e *TestEnvironment) ExecuteActions(d *interp.RuntimeData, actions []interp.AppliedAction) error {
+	raw, err := rawMessage(d.Msg)
+	if err != nil {
+		return err
+	}
+
+	res, err := e.Executor.Execute(context.Background(), actions, bytes.NewReader(raw), int64(len(raw)))
+	if res != nil {
+		for _, delivered := range res.Delivered {
+			e.envelopes[delivered.Mailbox] = append(e.envelopes[delivered.Mailbox], d.Envelope)
+		}
+		for _, addr := range res.Redirects {
+			e.smtp = append(e.smtp, &interp.ExecuteTestMessage{
+				Envelope: interp.EnvelopeStatic{
+					From: d.Envelope.EnvelopeFrom(),
+					To:   addr,
+					Auth: d.Envelope.AuthUsername(),
+				},
+				Message: d.Msg,
+			})
+		}
+	}
+	return err
+}
+
+func (e *TestEnvironment) GetSMTPMessage(index int) (*interp.ExecuteTestMessage, error) {
+	if index >= len(e.smtp) {
+		return nil, fmt.Errorf("index out of range")
+	}
+	return e.smtp[index], nil
+}
+
+func (e *TestEnvironment) HasSMTPMessage(index int) (bool, error) {
+	return index < len(e.smtp), nil
+}
+
+func (e *TestEnvironment) GetMailboxMessage(mailboxName string, index int) (*interp.ExecuteTestMessage, error) {
+	msgs, err := e.Executor.Store.Messages(mailboxName)
+	if err != nil {
+		return nil, err
+	}
+	if index >= len(msgs) {
+		return nil, fmt.Errorf("index out of range")
+	}
+
+	rc, err := msgs[index].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := interp.NewMessageReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope interp.Envelope = interp.EnvelopeStatic{}
+	if envelopes := e.envelopes[mailboxName]; index < len(envelopes) {
+		envelope = envelopes[index]
+	}
+	return &interp.ExecuteTestMessage{
+		Envelope: envelope,
+		Message:  storedMessage{MessageReader: reader, raw: raw},
+		Flags:    msgs[index].Flags,
+	}, nil
+}
+
+func (e *TestEnvironment) HasMailboxMessage(mailboxName string, index int) (bool, error) {
+	msgs, err := e.Executor.Store.Messages(mailboxName)
+	if err != nil {
+		if errors.Is(err, ErrNoMailbox) {
+			return false, nil
+		}
+		return false, err
+	}
+	return index < len(msgs), nil
+}
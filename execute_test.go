@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"net/textproto"
 	"reflect"
 	"strings"
@@ -232,3 +233,32 @@ func TestFlags(t *testing.T) {
 		)
 	})
 }
+
+func TestExecuteRuntimeErrorPosition(t *testing.T) {
+	loadedScript, err := Load(strings.NewReader(`if true {
+	redirect "1@example.org";
+	redirect "2@example.org";
+	redirect "3@example.org";
+	redirect "4@example.org";
+	redirect "5@example.org";
+	redirect "6@example.org";
+}
+`), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := interp.NewRuntimeData(loadedScript, interp.DummyPolicy{},
+		interp.EnvelopeStatic{}, interp.MessageStatic{})
+
+	err = loadedScript.Execute(context.Background(), data)
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("expected RuntimeError, got %v", err)
+	}
+	if rtErr.Position.Line != 7 || rtErr.Position.Col != 2 {
+		t.Errorf("wrong error position: %v", rtErr.Position)
+	}
+	if err.Error() != "7:2: too many actions" {
+		t.Errorf("wrong error message: %v", err)
+	}
+}
@@ -2,10 +2,12 @@ module github.com/foxcpp/go-sieve
 
 go 1.20
 
-require github.com/davecgh/go-spew v1.1.1
-
 require (
-	github.com/emersion/go-message v0.18.0 // indirect
-	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
-	rsc.io/binaryregexp v0.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1
+	github.com/emersion/go-message v0.18.0
+	golang.org/x/net v0.19.0
+	golang.org/x/text v0.14.0
+	rsc.io/binaryregexp v0.2.0
 )
+
+require github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
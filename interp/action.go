@@ -2,18 +2,22 @@ package interp
 
 import (
 	"context"
-	"encoding/gob"
 	"fmt"
 	"strings"
+
+	"github.com/foxcpp/go-sieve/lexer"
 )
 
-type CmdStop struct{}
+type CmdStop struct {
+	lexer.Position
+}
 
 func (c CmdStop) Execute(_ context.Context, _ *RuntimeData) error {
 	return ErrStop
 }
 
 type CmdFileInto struct {
+	lexer.Position
 	Mailbox string
 	Flags   Flags
 	Copy    bool
@@ -53,12 +57,16 @@ func (c CmdFileInto) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdRedirect struct {
+	lexer.Position
 	Addr string
 	Copy bool
 }
 
 func (c CmdRedirect) Execute(ctx context.Context, d *RuntimeData) error {
-	addr := expandVars(d, c.Addr)
+	addr, err := parseRedirectAddr(expandVars(d, c.Addr), d.options())
+	if err != nil {
+		return fmt.Errorf("redirect: %w", err)
+	}
 
 	ok, err := d.Policy.RedirectAllowed(ctx, d, addr)
 	if err != nil {
@@ -80,13 +88,14 @@ func (c CmdRedirect) Execute(ctx context.Context, d *RuntimeData) error {
 		d.ImplicitKeep = false
 	}
 
-	if len(d.RedirectAddr) > d.Script.opts.MaxRedirects {
+	if len(d.RedirectAddr) > d.options().MaxRedirects {
 		return fmt.Errorf("too many actions")
 	}
 	return nil
 }
 
 type CmdKeep struct {
+	lexer.Position
 	Flags Flags
 }
 
@@ -108,7 +117,9 @@ func (c CmdKeep) Execute(ctx context.Context, d *RuntimeData) error {
 	return nil
 }
 
-type CmdDiscard struct{}
+type CmdDiscard struct {
+	lexer.Position
+}
 
 func (c CmdDiscard) Execute(ctx context.Context, d *RuntimeData) error {
 	if err := d.OnAction(ctx, ActionDiscard{}, d); err != nil {
@@ -121,6 +132,7 @@ func (c CmdDiscard) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdSetFlag struct {
+	lexer.Position
 	Variable string
 	Flags    Flags
 }
@@ -144,6 +156,7 @@ func (c CmdSetFlag) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdAddFlag struct {
+	lexer.Position
 	Variable string
 	Flags    Flags
 }
@@ -186,6 +199,7 @@ func (c CmdAddFlag) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdRemoveFlag struct {
+	lexer.Position
 	Variable string
 	Flags    Flags
 }
@@ -225,6 +239,7 @@ func (c CmdRemoveFlag) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdReject struct {
+	lexer.Position
 	Reason string
 }
 
@@ -237,6 +252,7 @@ func (c CmdReject) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdEReject struct {
+	lexer.Position
 	Reason string
 }
 
@@ -247,16 +263,3 @@ func (c CmdEReject) Execute(ctx context.Context, d *RuntimeData) error {
 	d.ImplicitKeep = false
 	return nil
 }
-
-func init() {
-	gob.Register(CmdStop{})
-	gob.Register(CmdFileInto{})
-	gob.Register(CmdRedirect{})
-	gob.Register(CmdKeep{})
-	gob.Register(CmdDiscard{})
-	gob.Register(CmdSetFlag{})
-	gob.Register(CmdAddFlag{})
-	gob.Register(CmdRemoveFlag{})
-	gob.Register(CmdReject{})
-	gob.Register(CmdEReject{})
-}
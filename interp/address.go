@@ -0,0 +1,595 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// mailAddress is an address parsed from a header field or an envelope.
+// If the address is not syntactically valid, only raw is set.
+type mailAddress struct {
+	localPart string // unquoted
+	domain    string
+	valid     bool
+	raw       string
+}
+
+// String returns the addr-spec form of the address, with the local-part
+// quoted if necessary, or the original text if the address is invalid.
+func (a mailAddress) String() string {
+	if !a.valid {
+		return a.raw
+	}
+	if a.localPart == "" && a.domain == "" {
+		return ""
+	}
+	local := a.localPart
+	if !isDotAtom(local) {
+		local = quoteString(local)
+	}
+	if a.domain == "" {
+		return local
+	}
+	return local + "@" + a.domain
+}
+
+var (
+	errAddrUnexpectedEnd = errors.New("address: unexpected end of input")
+	errAddrEmptyLocal    = errors.New("address: empty local-part")
+	errAddrMissingAt     = errors.New("address: missing at-sign")
+	errAddrEmptyDomain   = errors.New("address: empty domain")
+	errAddrInvalidUTF8   = errors.New("address: invalid UTF-8")
+	errAddrNonASCII      = errors.New("address: internationalized addresses are not allowed")
+)
+
+// addrParser implements RFC 5322 address syntax including the obsolete
+// forms: comments and folding white space between tokens, source routes,
+// groups, quoted local-parts and dots in display names.
+type addrParser struct {
+	s   string
+	pos int
+}
+
+// parseAddressList parses the value of an address header field. Items of
+// the list that are not valid are returned as invalid addresses and do
+// not prevent other addresses from being parsed. Groups are expanded
+// into their members.
+func parseAddressList(s string) []mailAddress {
+	p := addrParser{s: s}
+	return p.list(false)
+}
+
+// parseMailbox parses a single mailbox ("addr-spec" or "Name <addr-spec>").
+func parseMailbox(s string) (mailAddress, error) {
+	p := addrParser{s: s}
+	if err := p.skipCFWS(); err != nil {
+		return mailAddress{}, err
+	}
+	addr, err := p.mailbox()
+	if err != nil {
+		return mailAddress{}, err
+	}
+	if err := p.skipCFWS(); err != nil {
+		return mailAddress{}, err
+	}
+	if !p.end() {
+		return mailAddress{}, errors.New("address: unexpected " + quoteString(p.s[p.pos:]))
+	}
+	return addr, nil
+}
+
+// parseRedirectAddr validates the redirect destination (RFC 5228 Section
+// 4.2) and returns its addr-spec. Internationalized addresses are
+// accepted unless disabled in opts, their domain must be convertible to
+// A-labels so it can be used with SMTPUTF8 (RFC 6531).
+func parseRedirectAddr(s string, opts *Options) (string, error) {
+	addr, err := parseMailbox(s)
+	if err != nil {
+		return "", err
+	}
+	if addr.domain == "" {
+		return "", errAddrMissingAt
+	}
+	if opts.ASCIIAddresses && (!isASCII(addr.localPart) || !isASCII(addr.domain)) {
+		return "", errAddrNonASCII
+	}
+	if _, err := normalizeDomain(addr.domain, IDNASCII); err != nil {
+		return "", err
+	}
+	addr.domain, err = normalizeDomain(addr.domain, opts.IDNDomains)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// applyAddressOptions converts the address according to the EAI options.
+// The address is returned as invalid if it is not allowed or its domain
+// cannot be converted.
+func applyAddressOptions(opts *Options, addr mailAddress) mailAddress {
+	if !addr.valid {
+		return addr
+	}
+	if opts.ASCIIAddresses && (!isASCII(addr.localPart) || !isASCII(addr.domain)) {
+		return mailAddress{raw: addr.String()}
+	}
+	domain, err := normalizeDomain(addr.domain, opts.IDNDomains)
+	if err != nil {
+		return mailAddress{raw: addr.String()}
+	}
+	addr.domain = domain
+	return addr
+}
+
+// parseEnvelopePath parses the envelope address (RFC 5321 Path), with or
+// without angle brackets. The source route is dropped. The null path
+// ("<>" or empty string) is returned as the valid address with empty
+// local-part and domain.
+func parseEnvelopePath(s string) mailAddress {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || trimmed == "<>" {
+		return mailAddress{valid: true}
+	}
+	invalid := mailAddress{raw: s}
+
+	p := addrParser{s: trimmed}
+	angle := p.consume('<')
+	if p.peek() == '@' {
+		if err := p.route(); err != nil {
+			return invalid
+		}
+	}
+	addr, err := p.addrSpec()
+	if err != nil {
+		return invalid
+	}
+	if angle && !p.consume('>') {
+		return invalid
+	}
+	if !p.end() {
+		return invalid
+	}
+	return addr
+}
+
+func (p *addrParser) end() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *addrParser) peek() byte {
+	if p.end() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *addrParser) consume(c byte) bool {
+	if p.peek() == c && !p.end() {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// list parses address-list, or group-list if inGroup is true.
+//
+// Outside of a group, a stray ';' is treated as a list separator, as
+// commonly produced by broken clients.
+func (p *addrParser) list(inGroup bool) []mailAddress {
+	var addrs []mailAddress
+	for {
+		if err := p.skipCFWS(); err != nil {
+			addrs = append(addrs, p.invalid(p.pos))
+			return addrs
+		}
+		if p.end() || (inGroup && p.peek() == ';') {
+			return addrs
+		}
+		if p.consume(',') || (!inGroup && p.consume(';')) {
+			// Empty item (obs-addr-list).
+			continue
+		}
+
+		start := p.pos
+		items, err := p.address(inGroup)
+		if err == nil {
+			err = p.skipCFWS()
+		}
+		if err == nil && !p.end() && p.peek() != ',' && p.peek() != ';' {
+			err = errors.New("address: unexpected character")
+		}
+		if err != nil {
+			p.pos = start
+			invalid := p.invalid(start)
+			if p.pos == start {
+				// No progress, do not loop forever.
+				return addrs
+			}
+			addrs = append(addrs, invalid)
+			continue
+		}
+		addrs = append(addrs, items...)
+	}
+}
+
+// invalid skips the malformed list item starting at start and returns it
+// as an invalid address.
+func (p *addrParser) invalid(start int) mailAddress {
+	end, balanced := p.itemEnd(start, true)
+	if !balanced {
+		// Unbalanced angle brackets, do not let them hide the
+		// following items.
+		end, _ = p.itemEnd(start, false)
+	}
+	p.pos = end
+	return mailAddress{raw: strings.TrimSpace(p.s[start:end])}
+}
+
+// itemEnd returns the offset of the list separator that ends the item
+// starting at start and whether angle brackets in it are balanced.
+// Separators in quoted strings, comments and, if angles is true, angle
+// brackets are skipped.
+func (p *addrParser) itemEnd(start int, angles bool) (int, bool) {
+	p.pos = start
+	depth := 0
+	for !p.end() {
+		switch p.s[p.pos] {
+		case '"':
+			p.skipQuoted('"', '"')
+			continue
+		case '(':
+			p.skipQuoted('(', ')')
+			continue
+		case '<':
+			if angles {
+				depth++
+			}
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case ',', ';':
+			if depth == 0 {
+				return p.pos, true
+			}
+		}
+		p.pos++
+	}
+	return len(p.s), depth == 0
+}
+
+// skipQuoted skips a quoted string or a (possibly nested) comment,
+// tolerating a missing closing character.
+func (p *addrParser) skipQuoted(open, close byte) {
+	depth := 0
+	for !p.end() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			p.pos++
+		case c == open && (open != close || depth == 0):
+			depth++
+		case c == close:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+	if p.pos > len(p.s) {
+		p.pos = len(p.s)
+	}
+}
+
+// address parses a mailbox or a group. Members of the group are parsed
+// with error recovery.
+func (p *addrParser) address(inGroup bool) ([]mailAddress, error) {
+	start := p.pos
+	phrase, err := p.phrase()
+	if err != nil {
+		return nil, err
+	}
+	if phrase && !inGroup && p.consume(':') {
+		members := p.list(true)
+		// Missing ';' at the end is tolerated.
+		p.consume(';')
+		return members, nil
+	}
+
+	p.pos = start
+	addr, err := p.mailbox()
+	if err != nil {
+		return nil, err
+	}
+	return []mailAddress{addr}, nil
+}
+
+// mailbox parses name-addr or addr-spec.
+func (p *addrParser) mailbox() (mailAddress, error) {
+	start := p.pos
+	if _, err := p.phrase(); err != nil {
+		return mailAddress{}, err
+	}
+	if p.peek() != '<' {
+		p.pos = start
+		return p.addrSpec()
+	}
+
+	p.pos++
+	if err := p.skipCFWS(); err != nil {
+		return mailAddress{}, err
+	}
+	if p.peek() == '@' {
+		if err := p.route(); err != nil {
+			return mailAddress{}, err
+		}
+	}
+	addr, err := p.addrSpec()
+	if err != nil {
+		return mailAddress{}, err
+	}
+	if !p.consume('>') {
+		return mailAddress{}, errors.New("address: missing closing angle bracket")
+	}
+	return addr, p.skipCFWS()
+}
+
+// phrase skips display-name words (atoms, quoted strings and dots) and
+// reports whether any were present.
+func (p *addrParser) phrase() (bool, error) {
+	found := false
+	for {
+		if err := p.skipCFWS(); err != nil {
+			return false, err
+		}
+		switch c := p.peek(); {
+		case c == '"':
+			if _, err := p.quotedString(); err != nil {
+				return false, err
+			}
+		case c == '.' && found:
+			p.pos++
+		case isAtext(c):
+			p.atom()
+		default:
+			return found, nil
+		}
+		found = true
+	}
+}
+
+// route skips obs-route: "@domain" list followed by ':'.
+func (p *addrParser) route() error {
+	for {
+		if err := p.skipCFWS(); err != nil {
+			return err
+		}
+		if p.consume(',') {
+			continue
+		}
+		if !p.consume('@') {
+			break
+		}
+		if _, err := p.domain(); err != nil {
+			return err
+		}
+	}
+	if !p.consume(':') {
+		return errors.New("address: malformed source route")
+	}
+	return nil
+}
+
+// addrSpec parses local-part "@" domain. The local-part without domain
+// is accepted only for "postmaster" (RFC 5321).
+func (p *addrParser) addrSpec() (mailAddress, error) {
+	local, err := p.localPart()
+	if err != nil {
+		return mailAddress{}, err
+	}
+	if !p.consume('@') {
+		if !utf8.ValidString(local) {
+			return mailAddress{}, errAddrInvalidUTF8
+		}
+		if strings.EqualFold(local, "postmaster") {
+			return mailAddress{localPart: local, valid: true}, nil
+		}
+		return mailAddress{}, errAddrMissingAt
+	}
+	domain, err := p.domain()
+	if err != nil {
+		return mailAddress{}, err
+	}
+	if !utf8.ValidString(local) || !utf8.ValidString(domain) {
+		return mailAddress{}, errAddrInvalidUTF8
+	}
+	return mailAddress{localPart: local, domain: domain, valid: true}, nil
+}
+
+// localPart parses dot-atom, quoted-string or obs-local-part and returns
+// it unquoted.
+func (p *addrParser) localPart() (string, error) {
+	var b strings.Builder
+	for {
+		if err := p.skipCFWS(); err != nil {
+			return "", err
+		}
+		switch c := p.peek(); {
+		case c == '"':
+			word, err := p.quotedString()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(word)
+		case isAtext(c):
+			b.WriteString(p.atom())
+		case p.end():
+			return "", errAddrUnexpectedEnd
+		default:
+			return "", errAddrEmptyLocal
+		}
+		if err := p.skipCFWS(); err != nil {
+			return "", err
+		}
+		if !p.consume('.') {
+			return b.String(), nil
+		}
+		b.WriteByte('.')
+	}
+}
+
+// domain parses dot-atom, domain-literal or obs-domain.
+func (p *addrParser) domain() (string, error) {
+	if err := p.skipCFWS(); err != nil {
+		return "", err
+	}
+	if p.peek() == '[' {
+		start := p.pos
+		for !p.end() && p.s[p.pos] != ']' {
+			if p.s[p.pos] == '[' && p.pos != start || p.s[p.pos] == '\\' {
+				return "", errors.New("address: malformed domain literal")
+			}
+			p.pos++
+		}
+		if !p.consume(']') {
+			return "", errAddrUnexpectedEnd
+		}
+		literal := strings.Join(strings.Fields(p.s[start:p.pos]), "")
+		return literal, p.skipCFWS()
+	}
+
+	var b strings.Builder
+	for {
+		if !isAtext(p.peek()) || p.end() {
+			return "", errAddrEmptyDomain
+		}
+		b.WriteString(p.atom())
+		if err := p.skipCFWS(); err != nil {
+			return "", err
+		}
+		if !p.consume('.') {
+			return b.String(), nil
+		}
+		b.WriteByte('.')
+		if err := p.skipCFWS(); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *addrParser) atom() string {
+	start := p.pos
+	for !p.end() && isAtext(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// quotedString parses a quoted string and returns its unquoted value.
+func (p *addrParser) quotedString() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.end() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.end() {
+				return "", errAddrUnexpectedEnd
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case '\r', '\n':
+			// Folding white space is removed.
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("address: unterminated quoted string")
+}
+
+// skipCFWS skips white space and (nested) comments.
+func (p *addrParser) skipCFWS() error {
+	for !p.end() {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '(':
+			if err := p.comment(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *addrParser) comment() error {
+	depth := 0
+	for !p.end() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			p.pos++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	p.pos = len(p.s)
+	return errors.New("address: unterminated comment")
+}
+
+// isAtext reports whether c is allowed in an atom. Octets of UTF-8
+// sequences are allowed as in RFC 6532, the validity of sequences is
+// checked by addrSpec.
+func isAtext(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	case c >= 0x80:
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) != -1
+}
+
+func isDotAtom(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if !isAtext(atom[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func quoteString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
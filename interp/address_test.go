@@ -0,0 +1,293 @@
+package interp
+
+import (
+	"context"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// addrStrings formats addresses for comparison, invalid ones are prefixed
+// with '!'.
+func addrStrings(addrs []mailAddress) []string {
+	res := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if !a.valid {
+			res = append(res, "!"+a.raw)
+			continue
+		}
+		res = append(res, a.String())
+	}
+	return res
+}
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "addr-spec",
+			input: "john@example.org",
+			want:  []string{"john@example.org"},
+		},
+		{
+			name:  "name-addr",
+			input: `"Joe Q. Public" <john.q.public@example.com>`,
+			want:  []string{"john.q.public@example.com"},
+		},
+		{
+			name:  "list",
+			input: "Mary Smith <mary@x.test>, jdoe@example.org, Who? <one@y.test>",
+			want:  []string{"mary@x.test", "jdoe@example.org", "one@y.test"},
+		},
+		{
+			name:  "comments",
+			input: "Pete(A nice \\) chap) <pete(his account)@silly.test(his host)>",
+			want:  []string{"pete@silly.test"},
+		},
+		{
+			name:  "nested comment",
+			input: "Timo <tss(no (spam))@fi.iki>",
+			want:  []string{"tss@fi.iki"},
+		},
+		{
+			name:  "group",
+			input: "A Group:Ed Jones <c@a.test>,joe@where.test,John <jdoe@one.test>;, other@x.test",
+			want:  []string{"c@a.test", "joe@where.test", "jdoe@one.test", "other@x.test"},
+		},
+		{
+			name:  "empty group",
+			input: "Undisclosed recipients:;",
+			want:  []string{},
+		},
+		{
+			name:  "quoted local-part",
+			input: `"john doe"@example.org, "simple"@example.org`,
+			want:  []string{`"john doe"@example.org`, "simple@example.org"},
+		},
+		{
+			name:  "source route",
+			input: "<@a.test,@b.test:user@c.test>",
+			want:  []string{"user@c.test"},
+		},
+		{
+			name:  "obsolete syntax",
+			input: "Joe Q. Public <john . q . public @ example . com>, ,, (empty)",
+			want:  []string{"john.q.public@example.com"},
+		},
+		{
+			name:  "domain literal",
+			input: "user@[192.0.2.1]",
+			want:  []string{"user@[192.0.2.1]"},
+		},
+		{
+			name:  "UTF-8",
+			input: "Jörg <jörg@bücher.example>",
+			want:  []string{"jörg@bücher.example"},
+		},
+		{
+			name:  "malformed items",
+			input: "frop, user@example.org, <broken@, \"a,b\" <c@d.test>",
+			want:  []string{"!frop", "user@example.org", "!<broken@", "c@d.test"},
+		},
+		{
+			name:  "unterminated comment",
+			input: "user@example.org (comment",
+			want:  []string{"!user@example.org (comment"},
+		},
+
+		{
+			name:  "semicolon separator",
+			input: "x@y.z; w@v.u",
+			want:  []string{"x@y.z", "w@v.u"},
+		},
+		{
+			name:  "trailing semicolon",
+			input: "<a@b>;",
+			want:  []string{"a@b"},
+		},
+		{
+			name:  "semicolon only",
+			input: ";",
+			want:  []string{},
+		},
+		{
+			name:  "malformed items with semicolon",
+			input: "a;b",
+			want:  []string{"!a", "!b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := addrStrings(parseAddressList(tt.input))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAddressList(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvelopePath(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"<>", ""},
+		{"user@example.org", "user@example.org"},
+		{"<user@example.org>", "user@example.org"},
+		{"<@a.test,@b.test:user@example.org>", "user@example.org"},
+		{"postmaster", "postmaster"},
+		{"<@a.test:user@example.org", "!<@a.test:user@example.org"},
+		{"<@a.test user@example.org>", "!<@a.test user@example.org>"},
+		{"user", "!user"},
+	}
+	for _, tt := range tests {
+		got := addrStrings([]mailAddress{parseEnvelopePath(tt.input)})[0]
+		if got != tt.want {
+			t.Errorf("parseEnvelopePath(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseRedirectAddr(t *testing.T) {
+	for input, want := range map[string]string{
+		"user@example.org":            "user@example.org",
+		"User <user@example.org>":     "user@example.org",
+		" user@example.org (comment)": "user@example.org",
+		`"a b"@example.org`:           `"a b"@example.org`,
+	} {
+		got, err := parseRedirectAddr(input, &Options{})
+		if err != nil {
+			t.Errorf("parseRedirectAddr(%q): %v", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("parseRedirectAddr(%q) = %q, want %q", input, got, want)
+		}
+	}
+	for _, input := range []string{
+		"", "user", "postmaster", "user@", "@example.org", "a@b.test, c@d.test", "group: a@b.test;",
+	} {
+		if _, err := parseRedirectAddr(input, &Options{}); err == nil {
+			t.Errorf("parseRedirectAddr(%q): expected error", input)
+		}
+	}
+}
+
+func TestAddressTestInvalid(t *testing.T) {
+	script := loadTestScript(t, `require ["fileinto", "envelope"];
+if address :localpart "to" "frop" { fileinto "localpart"; }
+if address :all "to" "frop" { fileinto "all"; }
+if address :domain "to" "example.org" { fileinto "domain"; }
+if address :localpart "cc" "tss" { fileinto "comment"; }
+if envelope :domain "from" "c.test" { fileinto "route"; }
+`, chainTestOptions)
+
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{
+		From: "<@a.test,@b.test:user@c.test>",
+	}, MessageStatic{
+		Header: textproto.MIMEHeader{
+			"To": {"frop, user@example.org"},
+			"Cc": {"Timo <tss(no spam)@fi.iki>"},
+		},
+	})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"all", "domain", "comment", "route"}
+	if strings.Join(d.Mailboxes, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected matches: %q", d.Mailboxes)
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		form   IDNForm
+		want   string
+	}{
+		{"Bücher.Example", IDNAsIs, "Bücher.Example"},
+		{"XN--BCHER-KVA.example", IDNUnicode, "bücher.example"},
+		{"Bücher.Example", IDNUnicode, "bücher.example"},
+		{"Bücher.example", IDNASCII, "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", IDNASCII, "xn--bcher-kva.example"},
+		{"[192.0.2.1]", IDNASCII, "[192.0.2.1]"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeDomain(tt.domain, tt.form)
+		if err != nil {
+			t.Errorf("normalizeDomain(%q): %v", tt.domain, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeDomain(%q, %d) = %q, want %q", tt.domain, tt.form, got, tt.want)
+		}
+	}
+	if _, err := normalizeDomain("xn--a-.example", IDNUnicode); err == nil {
+		t.Error("invalid A-label is accepted")
+	}
+}
+
+func TestAddressTestEAI(t *testing.T) {
+	src := `require ["fileinto", "envelope"];
+if address :localpart "from" "jörg" { fileinto "localpart"; }
+if address :domain "from" "bücher.example" { fileinto "domain"; }
+if address :domain "to" "xn--bcher-kva.example" { fileinto "domain-ace"; }
+if address :all :matches "cc" "*" { fileinto "invalid-all"; }
+if address :localpart :matches "cc" "*" { fileinto "invalid-localpart"; }
+`
+	msg := MessageStatic{
+		Header: textproto.MIMEHeader{
+			"From": {"Jörg <jörg@xn--bcher-kva.example>"},
+			"To":   {"info@Bücher.example"},
+			"Cc":   {"j\xf6rg@example.org"},
+		},
+	}
+	for _, tt := range []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"as is", Options{}, []string{"localpart", "invalid-all"}},
+		{"unicode", Options{IDNDomains: IDNUnicode}, []string{"localpart", "domain", "invalid-all"}},
+		{"ascii", Options{IDNDomains: IDNASCII}, []string{"localpart", "domain-ace", "invalid-all"}},
+		{"disabled", Options{ASCIIAddresses: true}, []string{"invalid-all"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := *chainTestOptions
+			opts.IDNDomains = tt.opts.IDNDomains
+			opts.ASCIIAddresses = tt.opts.ASCIIAddresses
+			script := loadTestScript(t, src, &opts)
+			d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, msg)
+			if err := script.Execute(context.Background(), d); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(d.Mailboxes, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("unexpected matches: %q", d.Mailboxes)
+			}
+		})
+	}
+}
+
+func TestParseRedirectAddrEAI(t *testing.T) {
+	got, err := parseRedirectAddr("jörg@Bücher.example", &Options{IDNDomains: IDNASCII})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "jörg@xn--bcher-kva.example" {
+		t.Errorf("unexpected address: %q", got)
+	}
+	if _, err := parseRedirectAddr("jörg@bücher.example", &Options{ASCIIAddresses: true}); err == nil {
+		t.Error("internationalized address is accepted with ASCIIAddresses")
+	}
+	for _, input := range []string{"j\xf6rg@example.org", "user@-bücher.example", "user@xn--a-.example"} {
+		if _, err := parseRedirectAddr(input, &Options{}); err == nil {
+			t.Errorf("parseRedirectAddr(%q): expected error", input)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package interp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/parser"
+)
+
+// AttachmentExtension is the name of the vendor extension that adds the
+// attachment test:
+//
+//	attachment [COMPARATOR] [MATCH-TYPE] [":filename" / ":type" / ":size"]
+//	           <key-list: string-list>
+//
+// The test is true if any attachment of the message matches any key.
+// :filename (the default) matches the file name, :type matches the
+// lower-case content type without parameters and :size matches the size
+// of the decoded content in bytes as a decimal number, compared using
+// "i;ascii-numeric" unless another comparator is given. :count matches
+// the number of attachments.
+//
+// Attachments are decoded to find their :size. The decoded bytes are
+// charged to Options.MaxScanBytes, the limits of body tests
+// (Options.MaxBodyPartBytes and MaxBodyBytes) do not apply.
+//
+// Example:
+//
+//	require ["vnd.go-sieve.attachment", "relational", "fileinto"];
+//	if anyof (attachment :matches "*.exe",
+//	          attachment :size :value "gt" "10485760") {
+//	    fileinto "Quarantine";
+//	}
+const AttachmentExtension = "vnd.go-sieve.attachment"
+
+// Attachment is a MIME part that is an attachment: a part with
+// "attachment" Content-Disposition or with a file name.
+type Attachment interface {
+	BodyPart
+
+	// Filename returns the file name from the Content-Disposition
+	// filename parameter or the Content-Type name parameter, with
+	// RFC 2231 and RFC 2047 encodings decoded. It is empty if the part
+	// has no name.
+	Filename() string
+}
+
+// AttachmentMessage is an optional extension of the Message interface used
+// by the attachment test. If the Message does not implement it, the test
+// is always false.
+type AttachmentMessage interface {
+	// Attachments returns attachments of the message in the order they
+	// appear, including attachments of nested messages.
+	Attachments(ctx context.Context) ([]Attachment, error)
+}
+
+type AttachmentPart string
+
+const (
+	AttachmentFilename AttachmentPart = "filename"
+	AttachmentType     AttachmentPart = "type"
+	AttachmentSize     AttachmentPart = "size"
+)
+
+// attachmentFilename returns the file name of the MIME entity and whether
+// it is an attachment.
+func attachmentFilename(hdr textproto.Header) (string, bool) {
+	disposition, dispParams := parseParamHeader(hdr.Get("Content-Disposition"))
+	_, ctParams := parseParamHeader(hdr.Get("Content-Type"))
+
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = ctParams["name"]
+	}
+	return filename, disposition == "attachment" || filename != ""
+}
+
+// attachmentSize returns the size of the decoded attachment content.
+// Read bytes are charged to the scan budget.
+func attachmentSize(ctx context.Context, d *RuntimeData, a Attachment) (int64, error) {
+	r, err := a.Open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	budgetR := &budgetReader{r: r, d: d}
+	size, err := io.Copy(io.Discard, budgetR)
+	if budgetR.err != nil {
+		return 0, budgetR.err
+	}
+	return size, err
+}
+
+type AttachmentTest struct {
+	lexer.Position
+	matcherTest
+
+	Part AttachmentPart
+}
+
+func (a AttachmentTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
+	am, ok := d.Msg.(AttachmentMessage)
+	if !ok {
+		if a.isCount() {
+			return a.countMatches(d, 0), nil
+		}
+		return false, nil
+	}
+
+	attachments, err := am.Attachments(ctx)
+	if err != nil {
+		return false, err
+	}
+	if a.isCount() {
+		return a.countMatches(d, uint64(len(attachments))), nil
+	}
+
+	for _, att := range attachments {
+		var value string
+		switch a.Part {
+		case AttachmentFilename:
+			value = att.Filename()
+		case AttachmentType:
+			value = att.ContentType()
+		case AttachmentSize:
+			size, err := attachmentSize(ctx, d, att)
+			if err != nil {
+				return false, fmt.Errorf("attachment: %w", err)
+			}
+			value = strconv.FormatInt(size, 10)
+		}
+
+		ok, err := a.tryMatch(d, value)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func loadAttachmentTest(s *Script, test parser.Test) (Test, error) {
+	if !s.RequiresExtension(AttachmentExtension) {
+		return nil, fmt.Errorf("missing require '%s'", AttachmentExtension)
+	}
+
+	loaded := AttachmentTest{
+		Position:    test.Position,
+		matcherTest: newMatcherTest(),
+		Part:        AttachmentFilename,
+	}
+	partCnt := 0
+	setPart := func(part AttachmentPart) func() {
+		return func() {
+			loaded.Part = part
+			partCnt++
+		}
+	}
+	var key []string
+	err := LoadSpec(s, loaded.addSpecTags(&Spec{
+		Tags: map[string]SpecTag{
+			"filename": {MatchBool: setPart(AttachmentFilename)},
+			"type":     {MatchBool: setPart(AttachmentType)},
+			"size":     {MatchBool: setPart(AttachmentSize)},
+		},
+		Pos: []SpecPosArg{
+			{
+				MatchStr: func(val []string) {
+					key = val
+				},
+				MinStrCount: 1,
+			},
+		},
+	}), test.Position, test.Args, test.Tests, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if partCnt > 1 {
+		return nil, fmt.Errorf("attachment: only one of :filename, :type and :size is allowed")
+	}
+	if loaded.Part == AttachmentSize && loaded.Comparator == "" {
+		loaded.Comparator = ComparatorASCIINumeric
+	}
+
+	if err := loaded.setKey(s, key); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
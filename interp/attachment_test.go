@@ -0,0 +1,208 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/lexer"
+)
+
+const testAttachmentMessage = "From: user@example.org\r\n" +
+	"To: user@example.org\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See attached.\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment;\r\n" +
+	" filename*0=\"invoice\"; filename*1=\".exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAECAwQF\r\n" +
+	"--outer\r\n" +
+	"Content-Type: IMAGE/PNG; name=\"=?UTF-8?B?0YTQvtGC0L4ucG5n?=\"\r\n" +
+	"Content-Disposition: inline\r\n" +
+	"\r\n" +
+	"0123456789\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"Content-Disposition: attachment; filename=fwd.eml\r\n" +
+	"\r\n" +
+	"Subject: forwarded\r\n" +
+	"Content-Type: multipart/mixed; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename*=iso-8859-1''r%E9sum%E9.pdf\r\n" +
+	"\r\n" +
+	"%PDF\r\n" +
+	"--inner--\r\n" +
+	"--outer--\r\n"
+
+func TestParseParamHeader(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   string
+		params map[string]string
+	}{
+		{
+			value:  `Attachment; FileName="a \"b\".txt"; size=10`,
+			want:   "attachment",
+			params: map[string]string{"filename": `a "b".txt`, "size": "10"},
+		},
+		{
+			value:  `attachment; filename*0="long "; filename*1=name; filename*2=".txt"`,
+			want:   "attachment",
+			params: map[string]string{"filename": "long name.txt"},
+		},
+		{
+			value:  `attachment; filename*0*=utf-8''%D1%84%D0%B0; filename*1*=%D0%B9%D0%BB; filename*2=".txt"`,
+			want:   "attachment",
+			params: map[string]string{"filename": "файл.txt"},
+		},
+		{
+			value:  `text/plain; name*=iso-8859-1'fr'caf%E9.txt`,
+			want:   "text/plain",
+			params: map[string]string{"name": "café.txt"},
+		},
+		{
+			value:  `text/plain; name="=?ISO-8859-1?Q?caf=E9?=.txt"`,
+			want:   "text/plain",
+			params: map[string]string{"name": "café.txt"},
+		},
+		{
+			value:  `attachment; broken; filename*1=skipped; filename=ok.txt`,
+			want:   "attachment",
+			params: map[string]string{"filename": "ok.txt"},
+		},
+		{
+			value:  `inline`,
+			want:   "inline",
+			params: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		got, params := parseParamHeader(tt.value)
+		if got != tt.want || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseParamHeader(%q) = %q, %q, want %q, %q", tt.value, got, params, tt.want, tt.params)
+		}
+	}
+}
+
+func TestMessageReaderAttachments(t *testing.T) {
+	msg, err := NewMessageReader(strings.NewReader(testAttachmentMessage), int64(len(testAttachmentMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := msg.Attachments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names, types []string
+	for _, a := range attachments {
+		names = append(names, a.Filename())
+		types = append(types, a.ContentType())
+	}
+	if want := []string{"invoice.exe", "фото.png", "fwd.eml", "résumé.pdf"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected file names: %q", names)
+	}
+	if want := []string{"application/octet-stream", "image/png", "message/rfc822", "application/pdf"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("unexpected content types: %q", types)
+	}
+	size, err := attachmentSize(context.Background(), &RuntimeData{Script: &Script{opts: &Options{}}}, attachments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 6 {
+		t.Errorf("unexpected decoded size: %d", size)
+	}
+}
+
+func TestAttachmentTest(t *testing.T) {
+	script := loadTestScript(t, `require ["vnd.go-sieve.attachment", "relational", "fileinto"];
+if attachment :matches "*.exe" { fileinto "exe"; }
+if attachment :matches "*.zip" { fileinto "zip"; }
+if attachment :is "résumé.pdf" { fileinto "name"; }
+if attachment :type "image/png" { fileinto "type"; }
+if attachment :size :value "eq" "6" { fileinto "size"; }
+if attachment :size :value "gt" "1000" { fileinto "large"; }
+if attachment :count "eq" "4" { fileinto "count"; }
+`, chainTestOptions)
+
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+		Header:     textproto.MIMEHeader{},
+		RawMessage: []byte(testAttachmentMessage),
+	})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"exe", "name", "type", "size", "count"}
+	if strings.Join(d.Mailboxes, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected matches: %q", d.Mailboxes)
+	}
+}
+
+func TestAttachmentSizeLimits(t *testing.T) {
+	src := `require ["vnd.go-sieve.attachment", "relational", "fileinto"];
+if attachment :size :value "eq" "6" { fileinto "exact"; }
+if attachment :size :value "gt" "3" { fileinto "larger"; }
+`
+	run := func(opts Options) (*RuntimeData, error) {
+		script := loadTestScript(t, src, &opts)
+		d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+			Header:     textproto.MIMEHeader{},
+			RawMessage: []byte(testAttachmentMessage),
+		})
+		return d, script.Execute(context.Background(), d)
+	}
+
+	// Limits of body tests do not apply to :size.
+	opts := *chainTestOptions
+	opts.MaxBodyPartBytes = 3
+	opts.MaxBodyBytes = 3
+	d, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(d.Mailboxes, ",") != "exact,larger" {
+		t.Errorf("unexpected matches with body limits: %q", d.Mailboxes)
+	}
+
+	opts = *chainTestOptions
+	opts.MaxScanBytes = 4
+	_, err = run(opts)
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Errorf("expected BudgetError with MaxScanBytes, got %v", err)
+	}
+}
+
+func TestAttachmentTestLoad(t *testing.T) {
+	s := &Script{
+		extensions: supportedRequires,
+	}
+	testCmdLoader(t, s, `require "vnd.go-sieve.attachment";
+if attachment :filename :type "a" { }`, nil)
+	testCmdLoader(t, s, `require "vnd.go-sieve.attachment";
+if attachment :size "10" { }`, []Cmd{CmdIf{
+		Position: lexer.LineCol(2, 1),
+		Test: AttachmentTest{
+			Position: lexer.LineCol(2, 4),
+			matcherTest: matcherTest{
+				Comparator: ComparatorASCIINumeric,
+				Match:      MatchIs,
+				Key:        []string{"10"},
+			},
+			Part: AttachmentSize,
+		},
+		Block: []Cmd{},
+	}})
+}
@@ -2,43 +2,128 @@ package interp
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"runtime/debug"
+	"sort"
+	"sync"
 )
 
-type savedOptions struct {
-	MaxRedirects int
+/*
+Saved script layout (all integers are big-endian):
 
-	MaxVariableCount   int
-	MaxVariableNameLen int
-	MaxVariableLen     int
-	SubAddressSep      string
+	magic            8 bytes, "GOSIEVE\x00"
+	format version   uint16
+	library version  uint16 length + bytes
+	extensions       uint16 count, then uint16 length + bytes for each
+	payload          uint32 length + bytes, see binary_codec.go
+	checksum         uint32, CRC-32 (Castagnoli) of all preceding bytes
+*/
+
+const savedMagic = "GOSIEVE\x00"
+
+// SavedFormatVersion is the version of the binary format produced by
+// Script.SaveTo. It is incremented on each incompatible change of the
+// format or of the encoded structures.
+const SavedFormatVersion = 7
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	libVersion     string
+	libVersionOnce sync.Once
+)
+
+// LibraryVersion returns the go-sieve module version recorded in saved
+// scripts, "(devel)" if it is not known. RestoreFrom rejects scripts saved
+// by a different version.
+func LibraryVersion() string {
+	libVersionOnce.Do(func() {
+		libVersion = "(devel)"
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if info.Main.Path == "github.com/foxcpp/go-sieve" && info.Main.Version != "" {
+			libVersion = info.Main.Version
+			return
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/foxcpp/go-sieve" {
+				libVersion = dep.Version
+				return
+			}
+		}
+	})
+	return libVersion
+}
+
+// IncompatibleSavedError is returned by RestoreFrom if the blob was not
+// produced by a compatible version of the library or is corrupted. Callers
+// should recompile the script from source.
+type IncompatibleSavedError struct {
+	Reason string
+
+	// Set if the header was read successfully.
+	FormatVersion  int
+	LibraryVersion string
 }
 
-type savedScript struct {
-	Extensions []string
-	Options    savedOptions
-	Cmds       []Cmd
+func (e *IncompatibleSavedError) Error() string {
+	if e.LibraryVersion != "" {
+		return fmt.Sprintf("interp: incompatible saved script (format %d, go-sieve %s): %s",
+			e.FormatVersion, e.LibraryVersion, e.Reason)
+	}
+	return "interp: incompatible saved script: " + e.Reason
+}
+
+func writeString(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("interp: string too long to be saved: %d", len(s))
+	}
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(s)))
+	buf.Write(l[:])
+	buf.WriteString(s)
+	return nil
 }
 
 func (s Script) SaveTo(w io.Writer) error {
-	saved := savedScript{
-		Extensions: make([]string, 0, len(s.extensions)),
-		Options: savedOptions{
-			MaxRedirects:       s.opts.MaxRedirects,
-			MaxVariableCount:   s.opts.MaxVariableCount,
-			MaxVariableNameLen: s.opts.MaxVariableNameLen,
-			MaxVariableLen:     s.opts.MaxVariableLen,
-			SubAddressSep:      s.opts.SubAddressSep,
-		},
-		Cmds: s.cmd,
+	payload, err := encodeScript(s.opts, s.cmd)
+	if err != nil {
+		return err
 	}
 
-	for ext := range s.extensions {
-		saved.Extensions = append(saved.Extensions, ext)
+	exts := s.Extensions()
+	sort.Strings(exts)
+
+	var buf bytes.Buffer
+	buf.WriteString(savedMagic)
+	var u16 [2]byte
+	binary.BigEndian.PutUint16(u16[:], SavedFormatVersion)
+	buf.Write(u16[:])
+	if err := writeString(&buf, LibraryVersion()); err != nil {
+		return err
 	}
+	binary.BigEndian.PutUint16(u16[:], uint16(len(exts)))
+	buf.Write(u16[:])
+	for _, ext := range exts {
+		if err := writeString(&buf, ext); err != nil {
+			return err
+		}
+	}
+	var u32 [4]byte
+	binary.BigEndian.PutUint32(u32[:], uint32(len(payload)))
+	buf.Write(u32[:])
+	buf.Write(payload)
+	binary.BigEndian.PutUint32(u32[:], crc32.Checksum(buf.Bytes(), crcTable))
+	buf.Write(u32[:])
 
-	return gob.NewEncoder(w).Encode(saved)
+	_, err = w.Write(buf.Bytes())
+	return err
 }
 
 func (s Script) Save() ([]byte, error) {
@@ -50,27 +135,136 @@ func (s Script) Save() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+var errTruncated = errors.New("truncated data")
+
+type savedReader struct {
+	blob []byte
+	err  error
+}
+
+func (r *savedReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.blob) < n {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.blob[:n]
+	r.blob = r.blob[n:]
+	return b
+}
+
+func (r *savedReader) uint16() int {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *savedReader) uint32() int {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (r *savedReader) string() string {
+	return string(r.next(r.uint16()))
+}
+
+type savedHeader struct {
+	FormatVersion  int
+	LibraryVersion string
+	Extensions     []string
+	Payload        []byte
+}
+
+func readSavedHeader(blob []byte) (*savedHeader, error) {
+	if len(blob) < len(savedMagic) || string(blob[:len(savedMagic)]) != savedMagic {
+		return nil, &IncompatibleSavedError{Reason: "missing magic, not a saved script or saved by an old version"}
+	}
+	if len(blob) < len(savedMagic)+4 {
+		return nil, &IncompatibleSavedError{Reason: errTruncated.Error()}
+	}
+
+	body, sum := blob[:len(blob)-4], binary.BigEndian.Uint32(blob[len(blob)-4:])
+	r := savedReader{blob: body[len(savedMagic):]}
+	hdr := &savedHeader{}
+	hdr.FormatVersion = r.uint16()
+	if r.err == nil && hdr.FormatVersion != SavedFormatVersion {
+		return nil, &IncompatibleSavedError{
+			Reason:        fmt.Sprintf("unsupported format version, expected %d", SavedFormatVersion),
+			FormatVersion: hdr.FormatVersion,
+		}
+	}
+	if crc32.Checksum(body, crcTable) != sum {
+		return nil, &IncompatibleSavedError{Reason: "checksum mismatch", FormatVersion: hdr.FormatVersion}
+	}
+
+	hdr.LibraryVersion = r.string()
+	extCount := r.uint16()
+	for i := 0; i < extCount && r.err == nil; i++ {
+		hdr.Extensions = append(hdr.Extensions, r.string())
+	}
+	hdr.Payload = r.next(r.uint32())
+	if r.err == nil && len(r.blob) != 0 {
+		r.err = errors.New("trailing data")
+	}
+	if r.err != nil {
+		return nil, &IncompatibleSavedError{
+			Reason:         r.err.Error(),
+			FormatVersion:  hdr.FormatVersion,
+			LibraryVersion: hdr.LibraryVersion,
+		}
+	}
+	return hdr, nil
+}
+
+// RestoreFrom loads the script saved using Script.SaveTo.
+//
+// *IncompatibleSavedError is returned if the script was saved using
+// a different format or library version or the data is corrupted.
 func RestoreFrom(r io.Reader) (*Script, error) {
-	var saved savedScript
-	err := gob.NewDecoder(r).Decode(&saved)
+	blob, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+	hdr, err := readSavedHeader(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	incompatible := func(reason string) error {
+		return &IncompatibleSavedError{
+			Reason:         reason,
+			FormatVersion:  hdr.FormatVersion,
+			LibraryVersion: hdr.LibraryVersion,
+		}
+	}
+
+	if hdr.LibraryVersion != LibraryVersion() {
+		return nil, incompatible("saved by a different go-sieve version, expected " + LibraryVersion())
+	}
 
 	restored := Script{
-		extensions: make(map[string]struct{}, len(saved.Extensions)),
-		opts: &Options{
-			MaxRedirects:       saved.Options.MaxRedirects,
-			MaxVariableCount:   saved.Options.MaxVariableCount,
-			MaxVariableNameLen: saved.Options.MaxVariableNameLen,
-			MaxVariableLen:     saved.Options.MaxVariableLen,
-			SubAddressSep:      saved.Options.SubAddressSep,
-		},
-		cmd: saved.Cmds,
-	}
-	for _, ext := range saved.Extensions {
+		extensions: make(map[string]struct{}, len(hdr.Extensions)),
+	}
+	for _, ext := range hdr.Extensions {
+		if !extensionSupported(ext) && ext != DovecotTestExtension {
+			return nil, incompatible("unsupported extension: " + ext)
+		}
 		restored.extensions[ext] = struct{}{}
 	}
+
+	opts, cmds, err := decodeScript(hdr.Payload)
+	if err != nil {
+		return nil, incompatible(err.Error())
+	}
+	restored.opts = opts
+	restored.cmd = cmds
 	return &restored, nil
 }
 
@@ -0,0 +1,778 @@
+package interp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/foxcpp/go-sieve/lexer"
+)
+
+/*
+Saved script payload:
+
+	string table     uvarint count, uvarint length of each string,
+	                 then all strings concatenated
+	options          see scriptEncoder.options
+	commands         block
+
+All strings in options and commands are stored as uvarint indexes into
+the string table. A block or list is stored as uvarint (length + 1),
+0 meaning nil. Each command and test starts with its opcode followed by
+its position (file, line, column) and fields in declaration order.
+
+Opcodes are part of the format: never reuse or renumber them, add new
+ones at the end and bump SavedFormatVersion if the encoding of an
+existing node changes.
+*/
+
+type opcode uint64
+
+const (
+	opInvalid opcode = iota
+
+	// Commands
+	opStop
+	opFileInto
+	opRedirect
+	opKeep
+	opDiscard
+	opSetFlag
+	opAddFlag
+	opRemoveFlag
+	opReject
+	opEReject
+	opIf
+	opElsif
+	opElse
+	opSet
+	opNoop
+
+	// Tests
+	opAddress
+	opAllOf
+	opAnyOf
+	opEnvelope
+	opExists
+	opFalse
+	opTrue
+	opHeader
+	opNot
+	opSize
+	opEnvironment
+	opHasFlag
+	opBody
+	opString
+
+	// vnd.dovecot.testsuite
+	opDovecotTest
+	opDovecotTestFail
+	opDovecotConfigSet
+	opDovecotTestSet
+	opDovecotBinarySave
+	opDovecotBinaryLoad
+	opDovecotMessage
+	opDovecotMailboxCreate
+	opDovecotResultReset
+	opDovecotTestMessage
+	opDovecotCompile
+	opDovecotRun
+	opDovecotTestError
+	opDovecotResultAction
+	opDovecotResultExecute
+	opDovecotMultiscript
+
+	// vnd.go-sieve.attachment
+	opAttachment
+)
+
+type scriptEncoder struct {
+	buf    []byte
+	strs   []string
+	strIdx map[string]uint64
+}
+
+func (e *scriptEncoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *scriptEncoder) int(v int) {
+	e.buf = binary.AppendVarint(e.buf, int64(v))
+}
+
+func (e *scriptEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *scriptEncoder) string(s string) {
+	idx, ok := e.strIdx[s]
+	if !ok {
+		idx = uint64(len(e.strs))
+		e.strs = append(e.strs, s)
+		e.strIdx[s] = idx
+	}
+	e.uvarint(idx)
+}
+
+// length writes the length of a list that is nil if isNil is true.
+func (e *scriptEncoder) length(n int, isNil bool) {
+	if isNil {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(n) + 1)
+}
+
+func (e *scriptEncoder) strings(l []string) {
+	e.length(len(l), l == nil)
+	for _, s := range l {
+		e.string(s)
+	}
+}
+
+func (e *scriptEncoder) position(pos lexer.Position) {
+	e.string(pos.File)
+	e.int(pos.Line)
+	e.int(pos.Col)
+}
+
+func (e *scriptEncoder) header(op opcode, pos lexer.Position) {
+	e.uvarint(uint64(op))
+	e.position(pos)
+}
+
+func (e *scriptEncoder) options(opts *Options) {
+	e.int(opts.MaxRedirects)
+	e.int(opts.MaxVariableCount)
+	e.int(opts.MaxVariableNameLen)
+	e.int(opts.MaxVariableLen)
+	e.string(opts.SubAddressSep)
+	e.int(opts.MaxCommands)
+	e.int(opts.MaxTests)
+	e.int(opts.MaxScanBytes)
+	e.int(opts.MaxVariableBytes)
+	e.bool(opts.ASCIIAddresses)
+	e.int(int(opts.IDNDomains))
+	e.int(opts.MaxBodyPartBytes)
+	e.int(opts.MaxBodyBytes)
+}
+
+func (e *scriptEncoder) matcher(m *matcherTest) {
+	e.string(string(m.Comparator))
+	e.string(string(m.Match))
+	e.string(string(m.Relational))
+	e.strings(m.Key)
+	e.length(len(m.keyCompiled), m.keyCompiled == nil)
+	for _, cm := range m.keyCompiled {
+		e.string(cm.Regexp)
+		e.bool(cm.Octet)
+	}
+}
+
+func (e *scriptEncoder) block(cmds []Cmd) error {
+	e.length(len(cmds), cmds == nil)
+	for _, c := range cmds {
+		if err := e.cmd(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *scriptEncoder) tests(tests []Test) error {
+	e.length(len(tests), tests == nil)
+	for _, t := range tests {
+		if err := e.test(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *scriptEncoder) cmd(c Cmd) error {
+	switch c := c.(type) {
+	case CmdStop:
+		e.header(opStop, c.Position)
+	case CmdFileInto:
+		e.header(opFileInto, c.Position)
+		e.string(c.Mailbox)
+		e.strings(c.Flags)
+		e.bool(c.Copy)
+	case CmdRedirect:
+		e.header(opRedirect, c.Position)
+		e.string(c.Addr)
+		e.bool(c.Copy)
+	case CmdKeep:
+		e.header(opKeep, c.Position)
+		e.strings(c.Flags)
+	case CmdDiscard:
+		e.header(opDiscard, c.Position)
+	case CmdSetFlag:
+		e.header(opSetFlag, c.Position)
+		e.string(c.Variable)
+		e.strings(c.Flags)
+	case CmdAddFlag:
+		e.header(opAddFlag, c.Position)
+		e.string(c.Variable)
+		e.strings(c.Flags)
+	case CmdRemoveFlag:
+		e.header(opRemoveFlag, c.Position)
+		e.string(c.Variable)
+		e.strings(c.Flags)
+	case CmdReject:
+		e.header(opReject, c.Position)
+		e.string(c.Reason)
+	case CmdEReject:
+		e.header(opEReject, c.Position)
+		e.string(c.Reason)
+	case CmdIf:
+		e.header(opIf, c.Position)
+		if err := e.test(c.Test); err != nil {
+			return err
+		}
+		return e.block(c.Block)
+	case *CmdIf:
+		return e.cmd(*c)
+	case CmdElsif:
+		e.header(opElsif, c.Position)
+		if err := e.test(c.Test); err != nil {
+			return err
+		}
+		return e.block(c.Block)
+	case *CmdElsif:
+		return e.cmd(*c)
+	case CmdElse:
+		e.header(opElse, c.Position)
+		return e.block(c.Block)
+	case *CmdElse:
+		return e.cmd(*c)
+	case CmdSet:
+		e.header(opSet, c.Position)
+		e.string(c.Name)
+		e.string(c.Value)
+		e.strings(c.Modifiers)
+	case CmdNoop:
+		e.header(opNoop, c.Position)
+	case CmdDovecotTest:
+		e.header(opDovecotTest, c.Position)
+		e.string(c.TestName)
+		return e.block(c.Cmds)
+	case CmdDovecotTestFail:
+		e.header(opDovecotTestFail, c.Position)
+		e.string(c.Message)
+	case CmdDovecotConfigSet:
+		e.header(opDovecotConfigSet, c.Position)
+		e.bool(c.Unset)
+		e.string(c.Key)
+		e.string(c.Value)
+	case CmdDovecotTestSet:
+		e.header(opDovecotTestSet, c.Position)
+		e.string(c.VariableName)
+		e.string(c.VariableValue)
+	case CmdDovecotBinarySave:
+		e.header(opDovecotBinarySave, c.Position)
+		e.string(c.Name)
+	case CmdDovecotBinaryLoad:
+		e.header(opDovecotBinaryLoad, c.Position)
+		e.string(c.Name)
+	case CmdDovecotMessage:
+		e.header(opDovecotMessage, c.Position)
+		e.bool(c.SMTP)
+		e.string(c.Folder)
+		e.int(c.Index)
+	case CmdDovecotMailboxCreate:
+		e.header(opDovecotMailboxCreate, c.Position)
+		e.string(c.Name)
+	case CmdDovecotResultReset:
+		e.header(opDovecotResultReset, c.Position)
+	default:
+		return fmt.Errorf("interp: cannot save command of type %T", c)
+	}
+	return nil
+}
+
+func (e *scriptEncoder) test(t Test) error {
+	switch t := t.(type) {
+	case AddressTest:
+		e.header(opAddress, t.Position)
+		e.matcher(&t.matcherTest)
+		e.string(string(t.AddressPart))
+		e.strings(t.Header)
+	case AllOfTest:
+		e.header(opAllOf, t.Position)
+		return e.tests(t.Tests)
+	case AnyOfTest:
+		e.header(opAnyOf, t.Position)
+		return e.tests(t.Tests)
+	case EnvelopeTest:
+		e.header(opEnvelope, t.Position)
+		e.matcher(&t.matcherTest)
+		e.string(string(t.AddressPart))
+		e.strings(t.Field)
+	case ExistsTest:
+		e.header(opExists, t.Position)
+		e.strings(t.Fields)
+	case FalseTest:
+		e.header(opFalse, t.Position)
+	case TrueTest:
+		e.header(opTrue, t.Position)
+	case HeaderTest:
+		e.header(opHeader, t.Position)
+		e.matcher(&t.matcherTest)
+		e.strings(t.Header)
+	case NotTest:
+		e.header(opNot, t.Position)
+		return e.test(t.Test)
+	case SizeTest:
+		e.header(opSize, t.Position)
+		e.int(t.Size)
+		e.bool(t.Over)
+		e.bool(t.Under)
+	case EnvironmentTest:
+		e.header(opEnvironment, t.Position)
+		e.matcher(&t.matcherTest)
+		e.strings(t.Name)
+	case HasFlagTest:
+		e.header(opHasFlag, t.Position)
+		e.matcher(&t.matcherTest)
+		e.strings(t.Variables)
+	case BodyTest:
+		e.header(opBody, t.Position)
+		e.matcher(&t.matcherTest)
+		e.string(string(t.Transform))
+		e.strings(t.ContentTypes)
+	case TestString:
+		e.header(opString, t.Position)
+		e.matcher(&t.matcherTest)
+		e.strings(t.Source)
+	case AttachmentTest:
+		e.header(opAttachment, t.Position)
+		e.matcher(&t.matcherTest)
+		e.string(string(t.Part))
+	case TestDovecotMessage:
+		e.header(opDovecotTestMessage, t.Position)
+		e.bool(t.SMTP)
+		e.string(t.Folder)
+		e.int(t.Index)
+	case TestDovecotCompile:
+		e.header(opDovecotCompile, t.Position)
+		e.string(t.ScriptPath)
+	case TestDovecotRun:
+		e.header(opDovecotRun, t.Position)
+	case TestDovecotTestError:
+		e.header(opDovecotTestError, t.Position)
+		e.matcher(&t.matcherTest)
+	case TestDovecotResultAction:
+		e.header(opDovecotResultAction, t.Position)
+		e.matcher(&t.matcherTest)
+		e.bool(t.Index != nil)
+		if t.Index != nil {
+			e.int(*t.Index)
+		}
+	case TestDovecotResultExecute:
+		e.header(opDovecotResultExecute, t.Position)
+	case TestDovecotMultiscript:
+		e.header(opDovecotMultiscript, t.Position)
+		e.strings(t.Scripts)
+	default:
+		return fmt.Errorf("interp: cannot save test of type %T", t)
+	}
+	return nil
+}
+
+// encodeScript returns the payload for the script options and commands.
+func encodeScript(opts *Options, cmds []Cmd) ([]byte, error) {
+	e := scriptEncoder{strIdx: map[string]uint64{}}
+	e.options(opts)
+	if err := e.block(cmds); err != nil {
+		return nil, err
+	}
+
+	size := binary.MaxVarintLen64 * (len(e.strs) + 1)
+	for _, s := range e.strs {
+		size += len(s)
+	}
+	payload := make([]byte, 0, size+len(e.buf))
+	payload = binary.AppendUvarint(payload, uint64(len(e.strs)))
+	for _, s := range e.strs {
+		payload = binary.AppendUvarint(payload, uint64(len(s)))
+	}
+	for _, s := range e.strs {
+		payload = append(payload, s...)
+	}
+	return append(payload, e.buf...), nil
+}
+
+var (
+	errBadString = errors.New("string index out of range")
+	errBadLength = errors.New("list length out of range")
+	errBadInt    = errors.New("malformed integer")
+)
+
+type scriptDecoder struct {
+	buf  []byte
+	strs []string
+	err  error
+}
+
+func (d *scriptDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *scriptDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		if n == 0 {
+			d.fail(errTruncated)
+		} else {
+			d.fail(errBadInt)
+		}
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *scriptDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		if n == 0 {
+			d.fail(errTruncated)
+		} else {
+			d.fail(errBadInt)
+		}
+		return 0
+	}
+	if v > math.MaxInt || v < math.MinInt {
+		d.fail(errBadInt)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *scriptDecoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if len(d.buf) == 0 {
+		d.fail(errTruncated)
+		return false
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v != 0
+}
+
+func (d *scriptDecoder) string() string {
+	idx := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if idx >= uint64(len(d.strs)) {
+		d.fail(errBadString)
+		return ""
+	}
+	return d.strs[idx]
+}
+
+// length reads the length of a list, -1 if the list is nil.
+func (d *scriptDecoder) length() int {
+	l := d.uvarint()
+	if d.err != nil || l == 0 {
+		return -1
+	}
+	// Each element takes at least one byte, anything longer
+	// is corrupted and should not cause a huge allocation.
+	if l-1 > uint64(len(d.buf)) {
+		d.fail(errBadLength)
+		return -1
+	}
+	return int(l - 1)
+}
+
+func (d *scriptDecoder) strings() []string {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	l := make([]string, n)
+	for i := range l {
+		l[i] = d.string()
+	}
+	return l
+}
+
+func (d *scriptDecoder) position() lexer.Position {
+	return lexer.Position{
+		File: d.string(),
+		Line: d.int(),
+		Col:  d.int(),
+	}
+}
+
+func (d *scriptDecoder) stringTable() {
+	count := d.uvarint()
+	if count > uint64(len(d.buf)) {
+		d.fail(errBadLength)
+	}
+	if d.err != nil {
+		return
+	}
+	lengths := make([]uint64, count)
+	total := uint64(0)
+	for i := range lengths {
+		lengths[i] = d.uvarint()
+		total += lengths[i]
+		if total > uint64(len(d.buf)) {
+			d.fail(errTruncated)
+			return
+		}
+	}
+	if d.err != nil {
+		return
+	}
+
+	// Convert all strings at once so they share a single allocation.
+	all := string(d.buf[:total])
+	d.buf = d.buf[total:]
+	d.strs = make([]string, count)
+	offset := uint64(0)
+	for i, l := range lengths {
+		d.strs[i] = all[offset : offset+l]
+		offset += l
+	}
+}
+
+func (d *scriptDecoder) options() *Options {
+	return &Options{
+		MaxRedirects:       d.int(),
+		MaxVariableCount:   d.int(),
+		MaxVariableNameLen: d.int(),
+		MaxVariableLen:     d.int(),
+		SubAddressSep:      d.string(),
+		MaxCommands:        d.int(),
+		MaxTests:           d.int(),
+		MaxScanBytes:       d.int(),
+		MaxVariableBytes:   d.int(),
+		ASCIIAddresses:     d.bool(),
+		IDNDomains:         IDNForm(d.int()),
+		MaxBodyPartBytes:   d.int(),
+		MaxBodyBytes:       d.int(),
+	}
+}
+
+func (d *scriptDecoder) matcher() matcherTest {
+	m := matcherTest{
+		Comparator: Comparator(d.string()),
+		Match:      Match(d.string()),
+		Relational: Relational(d.string()),
+		Key:        d.strings(),
+	}
+	n := d.length()
+	if n < 0 {
+		return m
+	}
+	m.keyCompiled = make([]CompiledMatcher, n)
+	for i := range m.keyCompiled {
+		cm := &m.keyCompiled[i]
+		cm.Regexp = d.string()
+		cm.Octet = d.bool()
+		if d.err != nil {
+			break
+		}
+		if err := cm.restore(); err != nil {
+			d.fail(err)
+		}
+	}
+	return m
+}
+
+func (d *scriptDecoder) block() []Cmd {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	cmds := make([]Cmd, n)
+	for i := range cmds {
+		cmds[i] = d.cmd()
+		if d.err != nil {
+			return nil
+		}
+	}
+	return cmds
+}
+
+func (d *scriptDecoder) tests() []Test {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	tests := make([]Test, n)
+	for i := range tests {
+		tests[i] = d.test()
+		if d.err != nil {
+			return nil
+		}
+	}
+	return tests
+}
+
+func (d *scriptDecoder) cmd() Cmd {
+	op := opcode(d.uvarint())
+	pos := d.position()
+	if d.err != nil {
+		return nil
+	}
+
+	switch op {
+	case opStop:
+		return CmdStop{Position: pos}
+	case opFileInto:
+		return CmdFileInto{Position: pos, Mailbox: d.string(), Flags: d.strings(), Copy: d.bool()}
+	case opRedirect:
+		return CmdRedirect{Position: pos, Addr: d.string(), Copy: d.bool()}
+	case opKeep:
+		return CmdKeep{Position: pos, Flags: d.strings()}
+	case opDiscard:
+		return CmdDiscard{Position: pos}
+	case opSetFlag:
+		return CmdSetFlag{Position: pos, Variable: d.string(), Flags: d.strings()}
+	case opAddFlag:
+		return CmdAddFlag{Position: pos, Variable: d.string(), Flags: d.strings()}
+	case opRemoveFlag:
+		return CmdRemoveFlag{Position: pos, Variable: d.string(), Flags: d.strings()}
+	case opReject:
+		return CmdReject{Position: pos, Reason: d.string()}
+	case opEReject:
+		return CmdEReject{Position: pos, Reason: d.string()}
+	case opIf:
+		return CmdIf{Position: pos, Test: d.test(), Block: d.block()}
+	case opElsif:
+		return CmdElsif{Position: pos, Test: d.test(), Block: d.block()}
+	case opElse:
+		return CmdElse{Position: pos, Block: d.block()}
+	case opSet:
+		cmd := CmdSet{Position: pos, Name: d.string(), Value: d.string(), Modifiers: d.strings()}
+		for _, name := range cmd.Modifiers {
+			if valueModifiers[name] == nil {
+				d.fail(fmt.Errorf("unknown value modifier: %v", name))
+			}
+		}
+		return cmd
+	case opNoop:
+		return CmdNoop{Position: pos}
+	case opDovecotTest:
+		return CmdDovecotTest{Position: pos, TestName: d.string(), Cmds: d.block()}
+	case opDovecotTestFail:
+		return CmdDovecotTestFail{Position: pos, Message: d.string()}
+	case opDovecotConfigSet:
+		return CmdDovecotConfigSet{Position: pos, Unset: d.bool(), Key: d.string(), Value: d.string()}
+	case opDovecotTestSet:
+		return CmdDovecotTestSet{Position: pos, VariableName: d.string(), VariableValue: d.string()}
+	case opDovecotBinarySave:
+		return CmdDovecotBinarySave{Position: pos, Name: d.string()}
+	case opDovecotBinaryLoad:
+		return CmdDovecotBinaryLoad{Position: pos, Name: d.string()}
+	case opDovecotMessage:
+		return CmdDovecotMessage{Position: pos, SMTP: d.bool(), Folder: d.string(), Index: d.int()}
+	case opDovecotMailboxCreate:
+		return CmdDovecotMailboxCreate{Position: pos, Name: d.string()}
+	case opDovecotResultReset:
+		return CmdDovecotResultReset{Position: pos}
+	default:
+		d.fail(fmt.Errorf("unknown command opcode %d", op))
+		return nil
+	}
+}
+
+func (d *scriptDecoder) test() Test {
+	op := opcode(d.uvarint())
+	pos := d.position()
+	if d.err != nil {
+		return nil
+	}
+
+	switch op {
+	case opAddress:
+		return AddressTest{Position: pos, matcherTest: d.matcher(), AddressPart: AddressPart(d.string()), Header: d.strings()}
+	case opAllOf:
+		return AllOfTest{Position: pos, Tests: d.tests()}
+	case opAnyOf:
+		return AnyOfTest{Position: pos, Tests: d.tests()}
+	case opEnvelope:
+		return EnvelopeTest{Position: pos, matcherTest: d.matcher(), AddressPart: AddressPart(d.string()), Field: d.strings()}
+	case opExists:
+		return ExistsTest{Position: pos, Fields: d.strings()}
+	case opFalse:
+		return FalseTest{Position: pos}
+	case opTrue:
+		return TrueTest{Position: pos}
+	case opHeader:
+		return HeaderTest{Position: pos, matcherTest: d.matcher(), Header: d.strings()}
+	case opNot:
+		return NotTest{Position: pos, Test: d.test()}
+	case opSize:
+		return SizeTest{Position: pos, Size: d.int(), Over: d.bool(), Under: d.bool()}
+	case opEnvironment:
+		return EnvironmentTest{Position: pos, matcherTest: d.matcher(), Name: d.strings()}
+	case opHasFlag:
+		return HasFlagTest{Position: pos, matcherTest: d.matcher(), Variables: d.strings()}
+	case opBody:
+		return BodyTest{Position: pos, matcherTest: d.matcher(), Transform: BodyTransform(d.string()), ContentTypes: d.strings()}
+	case opString:
+		return TestString{Position: pos, matcherTest: d.matcher(), Source: d.strings()}
+	case opAttachment:
+		return AttachmentTest{Position: pos, matcherTest: d.matcher(), Part: AttachmentPart(d.string())}
+	case opDovecotTestMessage:
+		return TestDovecotMessage{Position: pos, SMTP: d.bool(), Folder: d.string(), Index: d.int()}
+	case opDovecotCompile:
+		return TestDovecotCompile{Position: pos, ScriptPath: d.string()}
+	case opDovecotRun:
+		return TestDovecotRun{Position: pos}
+	case opDovecotTestError:
+		return TestDovecotTestError{Position: pos, matcherTest: d.matcher()}
+	case opDovecotResultAction:
+		t := TestDovecotResultAction{Position: pos, matcherTest: d.matcher()}
+		if d.bool() {
+			idx := d.int()
+			t.Index = &idx
+		}
+		return t
+	case opDovecotResultExecute:
+		return TestDovecotResultExecute{Position: pos}
+	case opDovecotMultiscript:
+		return TestDovecotMultiscript{Position: pos, Scripts: d.strings()}
+	default:
+		d.fail(fmt.Errorf("unknown test opcode %d", op))
+		return nil
+	}
+}
+
+// decodeScript decodes the payload produced by encodeScript.
+func decodeScript(payload []byte) (*Options, []Cmd, error) {
+	d := scriptDecoder{buf: payload}
+	d.stringTable()
+	opts := d.options()
+	cmds := d.block()
+	if d.err == nil && len(d.buf) != 0 {
+		d.fail(errors.New("trailing data in payload"))
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return opts, cmds, nil
+}
@@ -1,6 +1,22 @@
 package interp
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/parser"
+)
 
 func TestSaveRestorePreservesSubAddressSep(t *testing.T) {
 	script := Script{
@@ -29,3 +45,508 @@ func TestSaveRestorePreservesSubAddressSep(t *testing.T) {
 		t.Fatalf("SubAddressSep not preserved, got %q", restored.opts.SubAddressSep)
 	}
 }
+
+func TestSaveRestorePreservesPositions(t *testing.T) {
+	toks, err := lexer.Lex(strings.NewReader(`require "fileinto";
+if allof (header :is "subject" "x", not exists "to") {
+	fileinto "a";
+} elsif true {
+	redirect "b@example.org";
+} else {
+	keep;
+}
+`), &lexer.Options{Filename: "test.sieve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := LoadScript(cmds, &Options{MaxRedirects: 5, MaxVariableNameLen: 32, MaxVariableLen: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := script.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var positions func(nodes []Cmd) []lexer.Position
+	var testPositions func(t Test) []lexer.Position
+	testPositions = func(t Test) []lexer.Position {
+		res := []lexer.Position{NodePosition(t)}
+		switch t := t.(type) {
+		case AllOfTest:
+			for _, sub := range t.Tests {
+				res = append(res, testPositions(sub)...)
+			}
+		case NotTest:
+			res = append(res, testPositions(t.Test)...)
+		}
+		return res
+	}
+	positions = func(nodes []Cmd) []lexer.Position {
+		var res []lexer.Position
+		for _, c := range nodes {
+			res = append(res, NodePosition(c))
+			switch c := c.(type) {
+			case CmdIf:
+				res = append(res, testPositions(c.Test)...)
+				res = append(res, positions(c.Block)...)
+			case CmdElsif:
+				res = append(res, testPositions(c.Test)...)
+				res = append(res, positions(c.Block)...)
+			case CmdElse:
+				res = append(res, positions(c.Block)...)
+			}
+		}
+		return res
+	}
+
+	expected := []lexer.Position{
+		{File: "test.sieve", Line: 2, Col: 1},
+		{File: "test.sieve", Line: 2, Col: 4},
+		{File: "test.sieve", Line: 2, Col: 11},
+		{File: "test.sieve", Line: 2, Col: 37},
+		{File: "test.sieve", Line: 2, Col: 41},
+		{File: "test.sieve", Line: 3, Col: 2},
+		{File: "test.sieve", Line: 4, Col: 3},
+		{File: "test.sieve", Line: 4, Col: 9},
+		{File: "test.sieve", Line: 5, Col: 2},
+		{File: "test.sieve", Line: 6, Col: 3},
+		{File: "test.sieve", Line: 7, Col: 2},
+	}
+	if actual := positions(script.cmd); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("wrong positions after load: %v", actual)
+	}
+	if actual := positions(restored.cmd); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("wrong positions after restore: %v", actual)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "regenerate golden files for the current saved format version")
+
+func loadGoldenSource(t *testing.T) *Script {
+	t.Helper()
+	src, err := os.ReadFile(filepath.Join("testdata", "saved", "script.sieve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks, err := lexer.Lex(bytes.NewReader(src), &lexer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := LoadScript(cmds, &Options{MaxRedirects: 5, MaxVariableNameLen: 32, MaxVariableLen: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func executeGolden(t *testing.T, s *Script) []AppliedAction {
+	t.Helper()
+	msg := MessageStatic{
+		Size: 100,
+		Header: textproto.MIMEHeader{
+			"Subject": {"I have a present for you"},
+			"From":    {"coyote@desert.example.org"},
+		},
+	}
+	env := EnvelopeStatic{From: "coyote@desert.example.org", To: "roadrunner@acme.example.com"}
+	d := NewRuntimeData(s, DummyPolicy{}, env, msg)
+	if err := s.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	return d.AppliedActions
+}
+
+func TestRestoreGolden(t *testing.T) {
+	current := fmt.Sprintf("v%d.bin", SavedFormatVersion)
+	source := loadGoldenSource(t)
+	if *updateGolden {
+		blob, err := source.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", "saved", current), blob, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := executeGolden(t, source)
+
+	files, err := filepath.Glob(filepath.Join("testdata", "saved", "*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundCurrent := false
+	for _, path := range files {
+		name := filepath.Base(path)
+		t.Run(name, func(t *testing.T) {
+			blob, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := Restore(blob)
+			if name != current {
+				// Blobs from older format versions must be rejected
+				// in a way that tells the caller to recompile.
+				var incompatible *IncompatibleSavedError
+				if !errors.As(err, &incompatible) {
+					t.Fatalf("expected IncompatibleSavedError, got %v", err)
+				}
+				return
+			}
+			foundCurrent = true
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := executeGolden(t, restored); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("restored script produced different actions\nactual:   %#v\nexpected: %#v", actual, expected)
+			}
+		})
+	}
+	if !foundCurrent {
+		t.Errorf("missing golden file for the current format version (%s), run tests with -update", current)
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	blob, err := loadGoldenSource(t).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		"empty":      {},
+		"truncated":  blob[:len(blob)/2],
+		"bad magic":  append([]byte("GOSIEVX\x00"), blob[8:]...),
+		"bit flip":   append(append([]byte{}, blob[:40]...), append([]byte{blob[40] ^ 1}, blob[41:]...)...),
+		"no trailer": blob[:len(blob)-4],
+	}
+	newVersion := append([]byte{}, blob...)
+	newVersion[9]++
+	cases["future version"] = newVersion
+
+	current := LibraryVersion()
+	libVersion = "v0.0.0-other"
+	otherLibrary, err := loadGoldenSource(t).Save()
+	libVersion = current
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases["other library"] = otherLibrary
+
+	for name, blob := range cases {
+		blob := blob
+		t.Run(name, func(t *testing.T) {
+			_, err := Restore(blob)
+			var incompatible *IncompatibleSavedError
+			if !errors.As(err, &incompatible) {
+				t.Fatalf("expected IncompatibleSavedError, got %v", err)
+			}
+		})
+	}
+}
+
+var roundTripScripts = map[string]string{
+	"actions": `require ["fileinto", "copy", "imap4flags", "reject", "ereject"];
+fileinto :copy :flags ["\\Seen", "$Label"] "INBOX.a";
+redirect :copy "a@example.org";
+keep :flags "\\Flagged";
+setflag "\\Seen"; addflag "flags" "\\Answered"; removeflag "\\Seen";
+reject "no";
+ereject "no";
+discard;
+stop;`,
+	"tests": `require ["envelope", "subaddress", "relational", "comparator-i;ascii-numeric",
+	"environment", "body", "imap4flags", "variables", "encoded-character"];
+if allof(address :user :comparator "i;octet" :matches "from" "a*?",
+         envelope :detail :value "ge" "to" "b",
+         anyof(exists ["x", "y"], not size :over 10K, size :under 1),
+         header :count "eq" :comparator "i;ascii-numeric" "received" "2",
+         environment :contains "domain" "example",
+         body :content ["text", "image/png"] :contains "x",
+         body :raw :is "",
+         hasflag :matches ["a", "b"] "\\*",
+         string :is "${1}" "${x}",
+         true, not false) {
+	set :length "len" "${x}";
+	set :lower :upperfirst :quotewildcard "x" "${1}";
+} elsif header :is "subject" "" {
+	keep;
+} else {
+}`,
+	"attachment": `require ["vnd.go-sieve.attachment", "relational"];
+if anyof(attachment :matches "*.exe",
+         attachment :type :contains "image/",
+         attachment :size :value "gt" "1048576",
+         attachment :count "ge" :comparator "i;ascii-numeric" "3") {
+	discard;
+}`,
+}
+
+// exportedEqual reports whether exported fields of a and b
+// (recursively) are equal, nil and empty slices are different.
+func exportedEqual(path string, a, b reflect.Value) error {
+	if a.Kind() != b.Kind() {
+		return fmt.Errorf("%s: kind %v != %v", path, a.Kind(), b.Kind())
+	}
+	switch a.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				return fmt.Errorf("%s: nil mismatch", path)
+			}
+			return nil
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return fmt.Errorf("%s: type %v != %v", path, a.Elem().Type(), b.Elem().Type())
+		}
+		return exportedEqual(path, a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if !field.IsExported() && !field.Anonymous {
+				continue
+			}
+			if err := exportedEqual(path+"."+field.Name, a.Field(i), b.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return fmt.Errorf("%s: len %d (nil %v) != %d (nil %v)", path, a.Len(), a.IsNil(), b.Len(), b.IsNil())
+		}
+		for i := 0; i < a.Len(); i++ {
+			if err := exportedEqual(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if a.String() != b.String() {
+			return fmt.Errorf("%s: %q != %q", path, a.String(), b.String())
+		}
+	case reflect.Int:
+		if a.Int() != b.Int() {
+			return fmt.Errorf("%s: %d != %d", path, a.Int(), b.Int())
+		}
+	case reflect.Bool:
+		if a.Bool() != b.Bool() {
+			return fmt.Errorf("%s: %v != %v", path, a.Bool(), b.Bool())
+		}
+	default:
+		return fmt.Errorf("%s: cannot compare %v", path, a.Kind())
+	}
+	return nil
+}
+
+func loadTestScript(t testing.TB, src string, opts *Options) *Script {
+	t.Helper()
+	toks, err := lexer.Lex(strings.NewReader(src), &lexer.Options{Filename: "test.sieve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := LoadScript(cmds, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestSaveRestoreRoundTrip(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("testdata", "saved", "script.sieve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{"golden": string(golden)}
+	for name, src := range roundTripScripts {
+		scripts[name] = src
+	}
+	scripts["dovecot"] = `require ["vnd.dovecot.testsuite", "variables"];
+test_set "message" "Subject: x\r\n\r\n";
+test "name" {
+	test_config_set "sieve_variables_max_variable_size" "10";
+	test_config_reload;
+	test_mailbox_create "INBOX.a";
+	test_result_reset;
+	test_message :folder "INBOX" 0;
+	if not test_script_compile "x.sieve" { test_fail "compile"; }
+	if not allof(test_script_run, test_result_execute) { test_fail "run"; }
+	if test_error :matches "*" { test_fail "error"; }
+	if not test_result_action :index 1 "keep" { test_fail "action"; }
+	if test_result_action "keep" { test_fail "action"; }
+	if test_message :smtp 0 { test_binary_save "a"; test_binary_load "a"; }
+	if not test_multiscript ["a.sieve", "b.sieve"] { test_fail "multiscript"; }
+}`
+
+	for name, src := range scripts {
+		src := src
+		t.Run(name, func(t *testing.T) {
+			script := loadTestScript(t, src, &Options{
+				MaxRedirects:       5,
+				MaxVariableCount:   128,
+				MaxVariableNameLen: 32,
+				MaxVariableLen:     4000,
+				SubAddressSep:      "+",
+				MaxCommands:        100,
+				T:                  t,
+			})
+			blob, err := script.Save()
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := Restore(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exportedEqual("cmds", reflect.ValueOf(script.cmd), reflect.ValueOf(restored.cmd)); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(script.extensions, restored.extensions) {
+				t.Errorf("extensions: %v != %v", restored.extensions, script.extensions)
+			}
+			expectedOpts := *script.opts
+			expectedOpts.T = nil
+			if !reflect.DeepEqual(*restored.opts, expectedOpts) {
+				t.Errorf("options: %+v != %+v", *restored.opts, expectedOpts)
+			}
+
+			resaved, err := restored.Save()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(blob, resaved) {
+				t.Error("saving restored script produced a different blob")
+			}
+
+			// Every truncation of the payload must be rejected
+			// cleanly, without panics.
+			payload, err := encodeScript(script.opts, script.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(payload); i++ {
+				if _, _, err := decodeScript(payload[:i]); err == nil {
+					t.Fatalf("truncated payload (%d of %d bytes) decoded without error", i, len(payload))
+				}
+			}
+		})
+	}
+}
+
+func benchmarkScript(b *testing.B) *Script {
+	golden, err := os.ReadFile(filepath.Join("testdata", "saved", "script.sieve"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return loadTestScript(b, string(golden)+roundTripScripts["tests"], &Options{
+		MaxRedirects:       5,
+		MaxVariableNameLen: 32,
+		MaxVariableLen:     4000,
+	})
+}
+
+// gobSavedScript is the payload of format version 1, kept to compare
+// the current encoding against gob. Gob skips the unexported matcherTest
+// fields, so it does less work than format version 1 did.
+type gobSavedScript struct {
+	Options struct {
+		MaxRedirects       int
+		MaxVariableCount   int
+		MaxVariableNameLen int
+		MaxVariableLen     int
+		SubAddressSep      string
+	}
+	Cmds []Cmd
+}
+
+func init() {
+	for _, v := range []interface{}{
+		CmdStop{}, CmdFileInto{}, CmdRedirect{}, CmdKeep{}, CmdDiscard{},
+		CmdSetFlag{}, CmdAddFlag{}, CmdRemoveFlag{}, CmdReject{}, CmdEReject{},
+		CmdIf{}, CmdElsif{}, CmdElse{}, CmdSet{}, CmdNoop{},
+		AddressTest{}, AllOfTest{}, AnyOfTest{}, AttachmentTest{}, BodyTest{},
+		EnvelopeTest{}, EnvironmentTest{}, ExistsTest{}, FalseTest{}, TrueTest{},
+		HasFlagTest{}, HeaderTest{}, NotTest{}, SizeTest{}, TestString{},
+	} {
+		gob.Register(v)
+	}
+}
+
+func gobSave(s *Script) ([]byte, error) {
+	saved := gobSavedScript{Cmds: s.cmd}
+	saved.Options.MaxRedirects = s.opts.MaxRedirects
+	saved.Options.MaxVariableCount = s.opts.MaxVariableCount
+	saved.Options.MaxVariableNameLen = s.opts.MaxVariableNameLen
+	saved.Options.MaxVariableLen = s.opts.MaxVariableLen
+	saved.Options.SubAddressSep = s.opts.SubAddressSep
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(saved)
+	return buf.Bytes(), err
+}
+
+func BenchmarkSave(b *testing.B) {
+	script := benchmarkScript(b)
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := script.Save(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := gobSave(script); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRestore(b *testing.B) {
+	script := benchmarkScript(b)
+	blob, err := script.Save()
+	if err != nil {
+		b.Fatal(err)
+	}
+	gobBlob, err := gobSave(script)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(blob)))
+		for i := 0; i < b.N; i++ {
+			if _, err := Restore(blob); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(gobBlob)))
+		for i := 0; i < b.N; i++ {
+			var saved gobSavedScript
+			if err := gob.NewDecoder(bytes.NewReader(gobBlob)).Decode(&saved); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
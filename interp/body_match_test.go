@@ -110,7 +110,8 @@ func TestTryMatchBodyPartClosesReadersOnNonMatch(t *testing.T) {
 		Key:        []string{"nomatch", "hello"},
 	}
 
-	ok, err := matcher.tryMatchBodyPart(context.Background(), &RuntimeData{Script: &Script{}}, part, false)
+	d := &RuntimeData{Script: &Script{}}
+	ok, err := matcher.tryMatchBodyPart(context.Background(), d, part, false, newBodyScan(d))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -134,7 +135,8 @@ func TestTryMatchBodyPartASCIINumericIs(t *testing.T) {
 		Blob:             []byte("002 trailing text"),
 	}
 
-	ok, err := matcher.tryMatchBodyPart(context.Background(), &RuntimeData{Script: &Script{}}, part, false)
+	d := &RuntimeData{Script: &Script{}}
+	ok, err := matcher.tryMatchBodyPart(context.Background(), d, part, false, newBodyScan(d))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,3 +144,70 @@ func TestTryMatchBodyPartASCIINumericIs(t *testing.T) {
 		t.Fatal("expected numeric :is to match equivalent numeric prefix")
 	}
 }
+
+func TestBodyScanLimits(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"first part, keyword at the end\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"second\r\n" +
+		"--b--\r\n"
+	src := `require ["body", "fileinto"];
+if body :content "text" :contains "keyword" { fileinto "keyword"; }
+if body :content "text" :contains ["nothing", "keyword"] { fileinto "later-key"; }
+if body :content "text" :contains "second" { fileinto "second"; }
+if body :content "text" :is "first part" { fileinto "is-truncated"; }
+if body :content "text" :is "second" { fileinto "is"; }
+`
+	for _, tt := range []struct {
+		name      string
+		part, all int
+		want      []string
+	}{
+		{"unlimited", 0, 0, []string{"keyword", "later-key", "second", "is"}},
+		{"part limit", 10, 0, []string{"second", "is"}},
+		{"total limit", 0, 30, []string{"keyword", "later-key"}},
+		{"both", 10, 16, []string{"second", "is"}},
+		{"both exhausted", 10, 10, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := *chainTestOptions
+			opts.MaxBodyPartBytes = tt.part
+			opts.MaxBodyBytes = tt.all
+			script := loadTestScript(t, src, &opts)
+			d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+				Size:       len(raw),
+				RawMessage: []byte(raw),
+			})
+			if err := script.Execute(context.Background(), d); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(d.Mailboxes, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("unexpected matches: %q", d.Mailboxes)
+			}
+		})
+	}
+
+	// Parts are read again for each key, but charged to the scan budget
+	// once.
+	opts := *chainTestOptions
+	opts.MaxScanBytes = len("first part, keyword at the end") + len("second")
+	script := loadTestScript(t, `require ["body", "fileinto"];
+if body :content "text" :contains ["a1", "a2", "a3", "second"] { fileinto "second"; }
+`, &opts)
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+		Size:       len(raw),
+		RawMessage: []byte(raw),
+	})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(d.Mailboxes, ",") != "second" {
+		t.Errorf("unexpected matches: %q", d.Mailboxes)
+	}
+}
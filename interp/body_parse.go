@@ -11,6 +11,7 @@ import (
 
 	message "github.com/emersion/go-message"
 	"github.com/emersion/go-message/textproto"
+	"golang.org/x/net/html"
 )
 
 // ContentTypeMatches reports whether the MIME content type ct matches any of
@@ -91,45 +92,137 @@ func ParseBodyRaw(ctx context.Context, rawMsg io.Reader) (*bufio.Reader, error)
 	return buf, nil
 }
 
-type htmlStripper struct {
-	BR io.ByteReader
+// maxHTMLTokenBytes limits the memory used by htmlTextReader for a single
+// token. Text after a longer token is not extracted.
+const maxHTMLTokenBytes = 1 << 20
+
+// htmlTextSkipped contains elements whose content is not text shown to
+// the reader.
+var htmlTextSkipped = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+	"noscript": true,
+	"object":   true,
+	"svg":      true,
+	"math":     true,
+}
 
-	inTag bool
+// htmlTextBlocks contains elements that start a new line of text.
+var htmlTextBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "details": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"li": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "summary": true, "table": true,
+	"td": true, "th": true, "title": true, "tr": true, "ul": true,
 }
 
-func (hs *htmlStripper) Read(p []byte) (n int, err error) {
-	for n < len(p) {
-		b, err := hs.ReadByte()
-		if err != nil {
-			return n, err
-		}
+// htmlTextReader extracts text from HTML for the :text body transform
+// (RFC 5173, Section 5.3).
+//
+// Character references are decoded, comments and the content of
+// script, style and head elements are dropped, block elements are
+// replaced with line breaks and runs of white space are collapsed into a
+// single space. The document is tokenized as it is read, so memory use
+// does not depend on its size.
+type htmlTextReader struct {
+	z   *html.Tokenizer
+	err error
+
+	out []byte
+	// Number of open elements from htmlTextSkipped.
+	skipDepth int
+	// Separator written before the next text, if any.
+	pendingBreak bool
+	pendingSpace bool
+	lineStart    bool
+}
+
+func newHTMLTextReader(r io.Reader) *htmlTextReader {
+	z := html.NewTokenizer(r)
+	z.SetMaxBuf(maxHTMLTokenBytes)
+	return &htmlTextReader{z: z, lineStart: true}
+}
 
-		p[n] = b
-		n++
+func (h *htmlTextReader) Read(p []byte) (int, error) {
+	for len(h.out) == 0 {
+		if h.err != nil {
+			return 0, h.err
+		}
+		h.next()
 	}
+	n := copy(p, h.out)
+	h.out = h.out[n:]
 	return n, nil
 }
 
-func (hs *htmlStripper) ReadByte() (byte, error) {
-	for {
-		b, err := hs.BR.ReadByte()
-		if err != nil {
-			return 0, err
+func (h *htmlTextReader) next() {
+	h.out = h.out[:0]
+	tt := h.z.Next()
+	switch tt {
+	case html.ErrorToken:
+		h.err = h.z.Err()
+		if errors.Is(h.err, html.ErrBufferExceeded) {
+			h.err = io.EOF
 		}
-
-		if b == '<' && !hs.inTag {
-			hs.inTag = true
-			continue
+	case html.TextToken:
+		if h.skipDepth == 0 {
+			h.text(h.z.Text())
 		}
-		if b == '>' && hs.inTag {
-			hs.inTag = false
-			continue
+	case html.StartTagToken, html.SelfClosingTagToken:
+		name, _ := h.z.TagName()
+		if htmlTextSkipped[string(name)] {
+			if tt == html.StartTagToken {
+				h.skipDepth++
+			}
+			return
 		}
-		if hs.inTag {
-			continue
+		h.block(string(name))
+	case html.EndTagToken:
+		name, _ := h.z.TagName()
+		if htmlTextSkipped[string(name)] {
+			if h.skipDepth > 0 {
+				h.skipDepth--
+			}
+			return
 		}
+		h.block(string(name))
+	}
+}
 
-		return b, nil
+func (h *htmlTextReader) block(name string) {
+	if !htmlTextBlocks[name] {
+		return
+	}
+	if name == "br" && !h.lineStart {
+		h.out = append(h.out, '\n')
+		h.lineStart = true
+		h.pendingSpace = false
+		return
+	}
+	h.pendingBreak = !h.lineStart
+	h.pendingSpace = false
+}
+
+func (h *htmlTextReader) text(text []byte) {
+	for _, c := range text {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f':
+			h.pendingSpace = !h.lineStart && !h.pendingBreak
+			continue
+		}
+		switch {
+		case h.pendingBreak:
+			h.out = append(h.out, '\n')
+		case h.pendingSpace:
+			h.out = append(h.out, ' ')
+		}
+		h.pendingBreak, h.pendingSpace, h.lineStart = false, false, false
+		h.out = append(h.out, c)
 	}
 }
 
@@ -137,11 +230,17 @@ func (hs *htmlStripper) ReadByte() (byte, error) {
 // body parts whose content-type matches any of contentTypes, following
 // RFC 5173 §5.2 rules.
 //
+// Content-Transfer-Encoding of leaf parts is removed and text is converted
+// to UTF-8 using the charset parameter, see RegisterCharset for the list
+// of supported charsets. maxBytes limits the decoded size of each part.
+//
 // This is the helper for BodyParts intended for BodyMessage implementors
 // that only have access to the raw message stream. This is intentionally
 // naive implementation that keeps the matching message parts in memory for
 // parsing  and returns byte readers. Care should be taken to avoid using
-// this on large matching parts.
+// this on large matching parts. If the message can be read using
+// io.ReaderAt, MessageReader should be used instead, it streams each part
+// to the matcher without buffering.
 //
 // The rawMsg reader should start at the beginning of the message (headers
 // included), not after the separator line.
@@ -253,16 +352,7 @@ func walkMIMEParts(
 		return nil
 	}
 
-	// Apply transfer-encoding and charset decoding via go-message
-	mh := message.Header{Header: hdr}
-	ent, err := message.New(mh, body)
-	if err != nil {
-		// Skip parts with unknown or invalid encoding/charset
-		return nil
-	}
-
-	limited := io.LimitReader(ent.Body, int64(maxBytes))
-	decoded, err := io.ReadAll(limited)
+	decoded, err := io.ReadAll(decodeEntity(hdr, body, int64(maxBytes)))
 	if err != nil {
 		// Skip parts that fail to decode
 		return nil
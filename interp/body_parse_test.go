@@ -1,18 +1,16 @@
 package interp
 
 import (
-	"bytes"
 	"io"
-	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestHtmlStripper_ReadByte(t *testing.T) {
+func TestHTMLTextReader(t *testing.T) {
 	tests := []struct {
-		name    string
-		input   string
-		want    string
-		wantErr bool
+		name  string
+		input string
+		want  string
 	}{
 		{
 			name:  "simple html",
@@ -35,22 +33,61 @@ func TestHtmlStripper_ReadByte(t *testing.T) {
 			want:  "Hello, worldb>!",
 		},
 		{
-			name:  "malformed html 2",
-			input: "<p>Hello, <b>world!<<<</p>",
-			want:  "Hello, world!",
+			name:  "character references",
+			input: "Tom &amp; Jerry &#x20AC;10 &lt;b&gt; &eacute;t&eacute;",
+			want:  "Tom & Jerry €10 <b> été",
+		},
+		{
+			name: "hidden content",
+			input: "<html><head><title>T</title><style>p { color: red }</style></head>" +
+				"<body><script>var x = '<p>no</p>';</script><!-- comment -->" +
+				"<p>Visible<![CDATA[ cdata ]]></p></body></html>",
+			want: "Visible",
+		},
+		{
+			name:  "block elements",
+			input: "<h1>Title</h1><p>First\n   paragraph</p><ul><li>one<li>two</ul>line<br>break",
+			want:  "Title\nFirst paragraph\none\ntwo\nline\nbreak",
+		},
+		{
+			name:  "plain text",
+			input: "  just   text  ",
+			want:  "just text",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			stripper := &htmlStripper{BR: bytes.NewReader([]byte(tt.input))}
-			actual, err := io.ReadAll(stripper)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
+			actual, err := io.ReadAll(newHTMLTextReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatal(err)
 			}
-			if !reflect.DeepEqual(actual, []byte(tt.want)) {
-				t.Errorf("Read() actual = %v, want %v", string(actual), tt.want)
+			if string(actual) != tt.want {
+				t.Errorf("Read() actual = %q, want %q", actual, tt.want)
 			}
 		})
 	}
 }
+
+func TestHTMLTextReaderStreaming(t *testing.T) {
+	// Long documents are not buffered as a whole.
+	doc := io.LimitReader(repeatReader("<p>x &amp; y</p>"), 8<<20)
+	n, err := io.Copy(io.Discard, newHTMLTextReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "x & y" and a line break for each paragraph except the last one.
+	if want := int64(8<<20)/16*6 - 1; n != want {
+		t.Errorf("unexpected text size: %d, want %d", n, want)
+	}
+}
+
+type repeatReader string
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r)
+	}
+	return n, nil
+}
@@ -0,0 +1,199 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+)
+
+type BudgetKind string
+
+const (
+	BudgetCommands      BudgetKind = "commands"
+	BudgetTests         BudgetKind = "tests"
+	BudgetScanBytes     BudgetKind = "scanned bytes"
+	BudgetVariableBytes BudgetKind = "variable bytes"
+)
+
+// BudgetError is returned by Script.Execute (wrapped into RuntimeError)
+// if the script exceeds one of execution budgets set in Options.
+type BudgetError struct {
+	Kind  BudgetKind
+	Limit int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("execution budget exceeded: more than %d %s", e.Limit, e.Kind)
+}
+
+// budget tracks resources used by a single script execution.
+type budget struct {
+	commands      int64
+	tests         int64
+	scanBytes     int64
+	variableBytes int64
+}
+
+func charge(used *int64, n int64, limit int64, kind BudgetKind) error {
+	*used += n
+	if limit > 0 && *used > limit {
+		return &BudgetError{Kind: kind, Limit: limit}
+	}
+	return nil
+}
+
+func (d *RuntimeData) chargeCommand() error {
+	return charge(&d.budget.commands, 1, int64(d.options().MaxCommands), BudgetCommands)
+}
+
+func (d *RuntimeData) chargeTest() error {
+	return charge(&d.budget.tests, 1, int64(d.options().MaxTests), BudgetTests)
+}
+
+func (d *RuntimeData) chargeScan(n int) error {
+	return charge(&d.budget.scanBytes, int64(n), int64(d.options().MaxScanBytes), BudgetScanBytes)
+}
+
+func (d *RuntimeData) chargeVariable(n int) error {
+	return charge(&d.budget.variableBytes, int64(n), int64(d.options().MaxVariableBytes), BudgetVariableBytes)
+}
+
+// budgetReader charges all bytes read from the underlying reader
+// to the scan budget.
+//
+// Matchers may ignore read errors (e.g. regexp.MatchReader), so the
+// budget error is also saved in err and should be checked by the caller.
+type budgetReader struct {
+	r   io.Reader
+	d   *RuntimeData
+	err error
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	if budgetErr := b.d.chargeScan(n); budgetErr != nil {
+		b.err = budgetErr
+		return n, budgetErr
+	}
+	return n, err
+}
+
+// bodyScan enforces MaxBodyPartBytes and MaxBodyBytes for a single
+// evaluation of a body test.
+//
+// A part may be read several times (once for each key), but its bytes are
+// charged to MaxBodyBytes and to the scan budget only once.
+type bodyScan struct {
+	d          *RuntimeData
+	partLimit  int64
+	totalLimit int64
+	total      int64
+}
+
+func newBodyScan(d *RuntimeData) *bodyScan {
+	opts := d.options()
+	return &bodyScan{
+		d:          d,
+		partLimit:  int64(opts.MaxBodyPartBytes),
+		totalLimit: int64(opts.MaxBodyBytes),
+	}
+}
+
+// exhausted reports whether MaxBodyBytes is reached and no more body
+// parts should be scanned.
+func (s *bodyScan) exhausted() bool {
+	return s.totalLimit > 0 && s.total >= s.totalLimit
+}
+
+// part starts scanning of the next body part. Its limit is fixed at this
+// point, so all readers of the part end at the same offset.
+func (s *bodyScan) part() *bodyScanPart {
+	limit := s.partLimit
+	if s.totalLimit > 0 {
+		if remaining := s.totalLimit - s.total; limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return &bodyScanPart{s: s, limited: limit > 0, limit: limit}
+}
+
+type bodyScanPart struct {
+	s *bodyScan
+
+	limited bool
+	limit   int64
+
+	// Bytes charged so far, including the probe byte.
+	charged int64
+}
+
+// advance charges bytes of the part up to the offset end that were not
+// charged by previous readers. The probe byte past the limit is charged
+// only to the scan budget.
+func (p *bodyScanPart) advance(end int64) error {
+	if end <= p.charged {
+		return nil
+	}
+	if p.limited {
+		p.s.total += min64(end, p.limit) - min64(p.charged, p.limit)
+	} else {
+		p.s.total += end - p.charged
+	}
+	n := end - p.charged
+	p.charged = end
+	return p.s.d.chargeScan(int(n))
+}
+
+// reader returns the reader of the part that ends at its limit.
+func (p *bodyScanPart) reader(r io.Reader) *bodyScanReader {
+	return &bodyScanReader{r: r, p: p}
+}
+
+// bodyScanReader reads the part up to its limit.
+//
+// Matchers may ignore read errors (e.g. regexp.MatchReader), so the
+// budget error is also saved in err and should be checked by the caller.
+type bodyScanReader struct {
+	r io.Reader
+	p *bodyScanPart
+
+	offset int64
+	err    error
+
+	// truncated is set if the reader ended at a limit before the end
+	// of the part.
+	truncated bool
+	probed    bool
+}
+
+func (b *bodyScanReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.p.limited && b.offset >= b.p.limit {
+		if !b.probed {
+			// Check whether the part continues after the limit.
+			b.probed = true
+			var probe [1]byte
+			n, _ := io.ReadFull(b.r, probe[:])
+			b.truncated = n != 0
+			if err := b.p.advance(b.offset + int64(n)); err != nil {
+				b.err = err
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	if b.p.limited && int64(len(p)) > b.p.limit-b.offset {
+		p = p[:b.p.limit-b.offset]
+	}
+	n, err := b.r.Read(p)
+	b.offset += int64(n)
+	if budgetErr := b.p.advance(b.offset); budgetErr != nil {
+		b.err = budgetErr
+		return n, budgetErr
+	}
+	return n, err
+}
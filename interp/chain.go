@@ -0,0 +1,60 @@
+package interp
+
+import (
+	"context"
+)
+
+// ScriptChain is a sequence of scripts executed for a single message,
+// e.g. scripts configured by the administrator to run before and after
+// the personal script of the user.
+//
+// The semantics follow multiscript execution in Pigeonhole:
+//
+//   - Each script starts with its own variables, match variables and
+//     flags, actions requested by all scripts are merged in
+//     RuntimeData.AppliedActions.
+//   - stop ends only the script that executed it.
+//   - The next script is executed only if the implicit keep is still in
+//     effect, i.e. the previous scripts executed no discard, keep,
+//     fileinto, redirect or reject that cancels it.
+//   - The implicit keep is requested once, after the last executed
+//     script, using the flags set by that script.
+type ScriptChain []*Script
+
+// Execute runs the scripts of the chain against d. d.Script is set to
+// each executed script in turn and is left at the last executed one.
+// An empty chain only requests the implicit keep.
+//
+// If a script fails, actions requested by it are removed from
+// d.AppliedActions, actions of previous scripts are kept and the error
+// is returned. The implicit keep is not requested in this case.
+func (c ScriptChain) Execute(ctx context.Context, d *RuntimeData) error {
+	flags := d.Flags
+	for _, s := range c {
+		d.Script = s
+		d.Flags = append([]string(nil), flags...)
+		d.FlagAliases = make(map[string]string)
+		d.Variables = map[string]string{}
+		d.MatchVariables = nil
+		d.budget = budget{}
+		d.opts = nil
+
+		start := len(d.AppliedActions)
+		mailboxes, redirects := len(d.Mailboxes), len(d.RedirectAddr)
+		keep := d.ImplicitKeep
+		if err := s.run(ctx, d); err != nil {
+			d.ImplicitKeep = keep
+			d.AppliedActions = d.AppliedActions[:start]
+			d.Mailboxes = d.Mailboxes[:mailboxes]
+			d.RedirectAddr = d.RedirectAddr[:redirects]
+			return err
+		}
+		if !implicitKeep(d, d.AppliedActions[start:]) {
+			return nil
+		}
+	}
+	if !d.ImplicitKeep {
+		return nil
+	}
+	return applyImplicitKeep(ctx, d)
+}
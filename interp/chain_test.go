@@ -0,0 +1,107 @@
+package interp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var chainTestOptions = &Options{
+	MaxRedirects:       5,
+	MaxVariableCount:   128,
+	MaxVariableNameLen: 32,
+	MaxVariableLen:     4000,
+}
+
+func executeChain(t *testing.T, sources ...string) (*RuntimeData, ScriptChain, error) {
+	t.Helper()
+	chain := make(ScriptChain, 0, len(sources))
+	for _, src := range sources {
+		chain = append(chain, loadTestScript(t, src, chainTestOptions))
+	}
+	d := NewRuntimeData(nil, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+	err := chain.Execute(context.Background(), d)
+	return d, chain, err
+}
+
+func TestScriptChain(t *testing.T) {
+	d, chain, err := executeChain(t,
+		`require ["fileinto", "copy", "variables", "imap4flags"];
+		set "v" "before"; addflag "\\Seen";
+		fileinto :copy "Archive"; stop; discard;`,
+		`require ["fileinto", "copy", "variables"];
+		if string "${v}" "" { fileinto :copy "Fresh"; }
+		fileinto :copy "Archive";`,
+		`require "imap4flags"; addflag "\\Flagged";`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AppliedAction{
+		ActionFileInto{Mailbox: "Archive", Copy: true, Flags: []string{"\\Seen"}},
+		ActionFileInto{Mailbox: "Fresh", Copy: true},
+		ActionKeep{Implicit: true, Flags: []string{"\\Flagged"}},
+	}
+	if !reflect.DeepEqual(d.AppliedActions, want) {
+		t.Errorf("unexpected actions: %#v", d.AppliedActions)
+	}
+	if d.Script != chain[2] {
+		t.Error("d.Script is not the last executed script")
+	}
+}
+
+func TestScriptChainCancelKeep(t *testing.T) {
+	d, chain, err := executeChain(t,
+		`require ["fileinto", "copy"]; fileinto :copy "Archive";`,
+		`discard;`,
+		`keep;`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AppliedAction{
+		ActionFileInto{Mailbox: "Archive", Copy: true},
+		ActionDiscard{},
+	}
+	if !reflect.DeepEqual(d.AppliedActions, want) {
+		t.Errorf("unexpected actions: %#v", d.AppliedActions)
+	}
+	if d.Script != chain[1] {
+		t.Error("script after discard is executed")
+	}
+}
+
+func TestScriptChainError(t *testing.T) {
+	failing := *chainTestOptions
+	failing.MaxCommands = 2
+	chain := ScriptChain{
+		loadTestScript(t, `require ["fileinto", "copy"]; fileinto :copy "Archive";`, chainTestOptions),
+		loadTestScript(t, `require "fileinto"; fileinto "Junk"; redirect "a@example.org"; keep;`, &failing),
+		loadTestScript(t, `keep;`, chainTestOptions),
+	}
+	d := NewRuntimeData(nil, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+	err := chain.Execute(context.Background(), d)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !reflect.DeepEqual(d.AppliedActions, []AppliedAction{ActionFileInto{Mailbox: "Archive", Copy: true}}) {
+		t.Errorf("unexpected actions: %#v", d.AppliedActions)
+	}
+	if !reflect.DeepEqual(d.Mailboxes, []string{"Archive"}) {
+		t.Errorf("unexpected mailboxes: %v", d.Mailboxes)
+	}
+	if d.Script != chain[1] {
+		t.Error("d.Script is not the failed script")
+	}
+}
+
+func TestExecuteStopImplicitKeep(t *testing.T) {
+	script := loadTestScript(t, `if true { stop; } discard;`, chainTestOptions)
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.AppliedActions, []AppliedAction{ActionKeep{Implicit: true}}) {
+		t.Errorf("unexpected actions: %#v", d.AppliedActions)
+	}
+}
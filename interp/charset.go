@@ -0,0 +1,355 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	message "github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxDecodedExpansion limits the size of a decoded body part relative to
+// its encoded size. Conversion to UTF-8 can make text up to 3 times
+// larger, the rest is a safety margin.
+const maxDecodedExpansion = 4
+
+var (
+	charsetsLock sync.RWMutex
+	charsets     = map[string]encoding.Encoding{}
+)
+
+// RegisterCharset makes the encoding available for conversion of header
+// fields and body parts in the charset. The name is matched
+// case-insensitively.
+//
+// Registered charsets take precedence over the built-in table, which
+// contains the charsets defined by the WHATWG Encoding Standard
+// (golang.org/x/text/encoding/htmlindex).
+func RegisterCharset(name string, enc encoding.Encoding) {
+	charsetsLock.Lock()
+	defer charsetsLock.Unlock()
+	if enc == nil {
+		panic("interp: RegisterCharset encoding is nil")
+	}
+	charsets[strings.ToLower(name)] = enc
+}
+
+func lookupCharset(charset string) (encoding.Encoding, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	charsetsLock.RLock()
+	enc, ok := charsets[charset]
+	charsetsLock.RUnlock()
+	if ok {
+		return enc, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %v", charset)
+	}
+	return enc, nil
+}
+
+// charsetReader returns a reader that converts text in the charset
+// to UTF-8.
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "us-ascii", "ascii":
+		return r, nil
+	}
+	enc, err := lookupCharset(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+// decodeEntity returns the body of the MIME entity with
+// Content-Transfer-Encoding removed and text converted to UTF-8 according
+// to the charset parameter. At most limit bytes of decoded text are
+// returned.
+//
+// The body is returned as is if the transfer encoding is unknown and
+// without conversion if the charset is unknown.
+func decodeEntity(hdr textproto.Header, body io.Reader, limit int64) io.Reader {
+	ent, err := message.New(message.Header{Header: hdr}, body)
+	switch {
+	case message.IsUnknownCharset(err):
+		_, params, _ := ent.Header.ContentType()
+		if converted, err := charsetReader(params["charset"], ent.Body); err == nil {
+			body = converted
+		} else {
+			body = ent.Body
+		}
+	case err != nil:
+		// Unknown transfer encoding, match the raw content.
+	default:
+		body = ent.Body
+	}
+	return io.LimitReader(body, limit)
+}
+
+var headerWordDecoder = mime.WordDecoder{CharsetReader: charsetReader}
+
+// encodedWord matches RFC 2047 encoded words.
+var encodedWord = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=`)
+
+// decodeHeaderValue decodes RFC 2047 encoded words in the header field
+// value. Encoded words that cannot be decoded (e.g. because the charset is
+// unknown) are kept as is, i.e. treated as plain US-ASCII text, as
+// permitted by RFC 5228, Section 2.7.2.
+func decodeHeaderValue(value string) string {
+	if !strings.Contains(value, "=?") {
+		return value
+	}
+	decoded, err := headerWordDecoder.DecodeHeader(value)
+	if err == nil {
+		return decoded
+	}
+
+	var b strings.Builder
+	last, afterWord := 0, false
+	for _, loc := range encodedWord.FindAllStringIndex(value, -1) {
+		between, word := value[last:loc[0]], value[loc[0]:loc[1]]
+		last = loc[1]
+		text, err := headerWordDecoder.Decode(word)
+		if err != nil {
+			b.WriteString(between)
+			b.WriteString(word)
+			afterWord = false
+			continue
+		}
+		// Whitespace between adjacent encoded words is not displayed,
+		// RFC 2047, Section 6.2.
+		if !afterWord || strings.TrimLeft(between, " \t\r\n") != "" {
+			b.WriteString(between)
+		}
+		b.WriteString(text)
+		afterWord = true
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
+
+// decodeHeaderField decodes the value of the header field for comparison.
+// RFC 2047 encoded words are decoded in all fields, Content-Type and
+// Content-Disposition parameters using RFC 2231 encoding are replaced with
+// their decoded quoted-string form.
+func decodeHeaderField(key, value string) string {
+	if (strings.EqualFold(key, "Content-Type") || strings.EqualFold(key, "Content-Disposition")) &&
+		strings.Contains(value, "*") {
+		return formatParamHeader(splitParamHeader(value))
+	}
+	return decodeHeaderValue(value)
+}
+
+type headerParam struct {
+	name, value string
+}
+
+// parseParamHeader parses the value of a header field with parameters,
+// such as Content-Type and Content-Disposition. It returns the lower-case
+// value and parameters with lower-case names.
+//
+// Parameter values are decoded: RFC 2231 continuations and charsets are
+// supported for all charsets known to charsetReader, as well as RFC 2047
+// encoded words that are often used in quoted file names. Malformed
+// parameters are skipped.
+func parseParamHeader(value string) (string, map[string]string) {
+	v, list := splitParamHeader(value)
+	params := make(map[string]string, len(list))
+	for _, p := range list {
+		params[p.name] = p.value
+	}
+	return strings.ToLower(v), params
+}
+
+// splitParamHeader is parseParamHeader that keeps the order of parameters
+// and the case of the value.
+func splitParamHeader(value string) (string, []headerParam) {
+	v, rest, _ := strings.Cut(value, ";")
+	v = strings.TrimSpace(v)
+
+	// Segments of RFC 2231 parameters by name and index.
+	type segment struct {
+		value    string
+		extended bool
+	}
+	var order []string
+	plain := map[string]string{}
+	segments := map[string]map[int]segment{}
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n;")
+		if rest == "" {
+			break
+		}
+		var name, val string
+		var ok bool
+		name, val, rest, ok = consumeParam(rest)
+		if !ok {
+			// Skip to the next parameter.
+			_, rest, _ = strings.Cut(rest, ";")
+			continue
+		}
+
+		base, idx, extended := name, -1, false
+		if strings.HasSuffix(base, "*") {
+			base, extended = base[:len(base)-1], true
+		}
+		if b, n, found := strings.Cut(base, "*"); found {
+			i, err := strconv.Atoi(n)
+			if err != nil || i < 0 {
+				continue
+			}
+			base, idx = b, i
+		}
+		_, seenPlain := plain[base]
+		if _, seenSegment := segments[base]; !seenPlain && !seenSegment {
+			order = append(order, base)
+		}
+		if idx < 0 && !extended {
+			if !seenPlain {
+				plain[base] = decodeHeaderValue(val)
+			}
+			continue
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		if segments[base] == nil {
+			segments[base] = map[int]segment{}
+		}
+		segments[base][idx] = segment{value: val, extended: extended}
+	}
+
+	params := make([]headerParam, 0, len(order))
+	for _, name := range order {
+		segs := segments[name]
+		first, ok := segs[0]
+		if !ok {
+			if val, ok := plain[name]; ok {
+				params = append(params, headerParam{name, val})
+			}
+			continue
+		}
+		charset := ""
+		var raw []byte
+		for i := 0; ; i++ {
+			seg, ok := segs[i]
+			if !ok {
+				break
+			}
+			val := seg.value
+			if seg.extended {
+				if i == 0 {
+					// charset'language'value
+					parts := strings.SplitN(val, "'", 3)
+					if len(parts) != 3 {
+						break
+					}
+					charset, val = parts[0], parts[2]
+				}
+				decoded, err := percentDecode(val)
+				if err != nil {
+					break
+				}
+				val = decoded
+			}
+			raw = append(raw, val...)
+		}
+		val := string(raw)
+		if first.extended && charset != "" {
+			if r, err := charsetReader(charset, strings.NewReader(val)); err == nil {
+				if decoded, err := io.ReadAll(r); err == nil {
+					val = string(decoded)
+				}
+			}
+		}
+		params = append(params, headerParam{name, val})
+	}
+	return v, params
+}
+
+// formatParamHeader formats the value and parameters back into the header
+// field value, parameter values are always quoted.
+func formatParamHeader(value string, params []headerParam) string {
+	var b strings.Builder
+	b.WriteString(value)
+	for _, p := range params {
+		b.WriteString("; ")
+		b.WriteString(p.name)
+		b.WriteString(`="`)
+		for i := 0; i < len(p.value); i++ {
+			if c := p.value[i]; c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(p.value[i])
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// consumeParam parses "name=value" at the start of s, value is a token or
+// a quoted string.
+func consumeParam(s string) (name, value, rest string, ok bool) {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return "", "", s, false
+	}
+	name = strings.ToLower(strings.TrimSpace(s[:eq]))
+	s = strings.TrimLeft(s[eq+1:], " \t\r\n")
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexByte(s, ';')
+		if end < 0 {
+			end = len(s)
+		}
+		return name, strings.TrimSpace(s[:end]), s[end:], name != ""
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return name, b.String(), s[i+1:], name != ""
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '\r', '\n':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", "", false
+}
+
+func percentDecode(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("malformed percent-encoding")
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("malformed percent-encoding")
+		}
+		b = append(b, byte(n))
+		i += 2
+	}
+	return string(b), nil
+}
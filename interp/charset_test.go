@@ -0,0 +1,93 @@
+package interp
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func encodeCharset(t *testing.T, enc encoding.Encoding, s string) string {
+	t.Helper()
+	res, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestBodyPartsCharset(t *testing.T) {
+	part := func(charset, cte, body string) string {
+		res := "--b\r\nContent-Type: text/plain; charset=" + charset + "\r\n"
+		if cte != "" {
+			res += "Content-Transfer-Encoding: " + cte + "\r\n"
+		}
+		return res + "\r\n" + body + "\r\n"
+	}
+	src := "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		part("iso-2022-jp", "7bit", encodeCharset(t, japanese.ISO2022JP, "こんにちは")) +
+		part("windows-1251", "8bit", encodeCharset(t, charmap.Windows1251, "Привет")) +
+		part("GB18030", "", encodeCharset(t, simplifiedchinese.GB18030, "你好")) +
+		part("x-unknown", "", "as is") +
+		"--b--\r\n"
+	want := []string{"こんにちは", "Привет", "你好", "as is"}
+
+	reader, err := NewMessageReader(strings.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, msg := range map[string]BodyMessage{
+		"MessageStatic": MessageStatic{RawMessage: []byte(src)},
+		"MessageReader": reader,
+	} {
+		parts, err := msg.BodyParts(context.Background(), []string{"text"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readParts(t, parts)["text/plain"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unexpected parts: %q", name, got)
+		}
+	}
+}
+
+func TestRegisterCharset(t *testing.T) {
+	RegisterCharset("X-Test-Latin", charmap.ISO8859_15)
+
+	src := "Content-Type: text/plain; charset=x-test-latin\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=A4 10"
+	parts, err := ParseBodyParts(context.Background(), strings.NewReader(src), []string{"text"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readParts(t, parts)["text/plain"]; !reflect.DeepEqual(got, []string{"€ 10"}) {
+		t.Errorf("unexpected parts: %q", got)
+	}
+	if got := decodeHeaderValue("=?x-test-latin?q?=A4?="); got != "€" {
+		t.Errorf("unexpected header value: %q", got)
+	}
+}
+
+func TestDecodeEntityLimit(t *testing.T) {
+	src := "Content-Type: text/plain; charset=windows-1251\r\n\r\n" +
+		encodeCharset(t, charmap.Windows1251, strings.Repeat("Ж", 100))
+	parts, err := ParseBodyParts(context.Background(), strings.NewReader(src), []string{"text"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := parts[0].Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(rc)
+	if string(b) != strings.Repeat("Ж", 5) {
+		t.Errorf("unexpected part: %q", b)
+	}
+}
@@ -0,0 +1,244 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collation implements operations of a comparator (RFC 4790, RFC 5228
+// Section 2.7.3). Operations not supported by the collation return
+// ErrComparatorMatchUnsupported.
+//
+// Implementations must be safe for concurrent use.
+type Collation interface {
+	// Equal implements :is.
+	Equal(value, key string) (bool, error)
+	// Contains implements :contains, it reports whether key is a
+	// substring of value.
+	Contains(value, key string) (bool, error)
+	// Compare implements :value, it returns a negative number, zero or
+	// a positive number if value is less than, equal to or greater than
+	// key.
+	Compare(value, key string) (int, error)
+	// Match implements :matches. pattern uses '*' and '?' wildcards with
+	// '\' as the escape character. Returned matches contain the whole
+	// value followed by the values matched by each wildcard.
+	Match(value, pattern string) (bool, []string, error)
+}
+
+// ReaderCollation is an optional interface for collations that can
+// match a stream without reading it into memory. It is used for body
+// tests, other collations get the whole body part as a string.
+type ReaderCollation interface {
+	Collation
+	// MatchReader implements :is, :contains and :matches.
+	MatchReader(match Match, value io.Reader, key string) (bool, error)
+}
+
+// patternCollation is implemented by built-in collations that can have
+// :matches patterns compiled at load time.
+type patternCollation interface {
+	// patternRegex converts the pattern into a regular expression for
+	// CompiledMatcher.
+	patternRegex(pattern string) (regex string, octet bool)
+	// matchCompiled and matchCompiledReader match the value using
+	// the CompiledMatcher created from patternRegex result.
+	matchCompiled(cm *CompiledMatcher, value string) (bool, []string, error)
+	matchCompiledReader(cm *CompiledMatcher, value io.Reader) (bool, error)
+}
+
+func matchCompiled(name Comparator, cm *CompiledMatcher, value string) (bool, []string, error) {
+	c, err := lookupCollation(name)
+	if err != nil {
+		return false, nil, err
+	}
+	pc, ok := c.(patternCollation)
+	if !ok {
+		return false, nil, fmt.Errorf("comparator %v does not support compiled patterns", name)
+	}
+	return pc.matchCompiled(cm, value)
+}
+
+func matchCompiledReader(name Comparator, cm *CompiledMatcher, value io.Reader) (bool, error) {
+	c, err := lookupCollation(name)
+	if err != nil {
+		return false, err
+	}
+	pc, ok := c.(patternCollation)
+	if !ok {
+		return false, fmt.Errorf("comparator %v does not support compiled patterns", name)
+	}
+	return pc.matchCompiledReader(cm, value)
+}
+
+var (
+	comparatorsLock sync.RWMutex
+	comparators     = map[Comparator]Collation{
+		ComparatorOctet:          octetCollation{},
+		ComparatorASCIICaseMap:   asciiCaseMapCollation{},
+		ComparatorASCIINumeric:   asciiNumericCollation{},
+		ComparatorUnicodeCaseMap: unicodeCaseMapCollation{},
+	}
+)
+
+// RegisterComparator makes the collation available under the name. It
+// can be then selected using :comparator and required as
+// "comparator-<name>".
+//
+// It panics if the comparator with the same name is already registered.
+func RegisterComparator(name Comparator, c Collation) {
+	comparatorsLock.Lock()
+	defer comparatorsLock.Unlock()
+	if c == nil {
+		panic("interp: RegisterComparator collation is nil")
+	}
+	if _, ok := comparators[name]; ok {
+		panic("interp: RegisterComparator called twice for " + string(name))
+	}
+	comparators[name] = c
+}
+
+// LookupComparator returns the collation registered under the name.
+func LookupComparator(name Comparator) (Collation, bool) {
+	comparatorsLock.RLock()
+	defer comparatorsLock.RUnlock()
+	c, ok := comparators[name]
+	return c, ok
+}
+
+func comparatorExtensions() []string {
+	comparatorsLock.RLock()
+	defer comparatorsLock.RUnlock()
+	exts := make([]string, 0, len(comparators))
+	for name := range comparators {
+		exts = append(exts, "comparator-"+string(name))
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func isComparatorExtension(ext string) bool {
+	name, ok := strings.CutPrefix(ext, "comparator-")
+	if !ok {
+		return false
+	}
+	_, ok = LookupComparator(Comparator(name))
+	return ok
+}
+
+func lookupCollation(name Comparator) (Collation, error) {
+	c, ok := LookupComparator(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported comparator: %v", name)
+	}
+	return c, nil
+}
+
+type octetCollation struct{}
+
+func (octetCollation) Equal(value, key string) (bool, error) {
+	return value == key, nil
+}
+
+func (octetCollation) Contains(value, key string) (bool, error) {
+	return strings.Contains(value, key), nil
+}
+
+func (octetCollation) Compare(value, key string) (int, error) {
+	return strings.Compare(value, key), nil
+}
+
+func (octetCollation) Match(value, pattern string) (bool, []string, error) {
+	return matchOctet(pattern, value, false)
+}
+
+func (octetCollation) MatchReader(match Match, value io.Reader, key string) (bool, error) {
+	return matchRegexReader(match, value, key, true, false)
+}
+
+func (octetCollation) patternRegex(pattern string) (string, bool) {
+	return patternToRegex(pattern, false), true
+}
+
+func (octetCollation) matchCompiled(cm *CompiledMatcher, value string) (bool, []string, error) {
+	return cm.Match(value)
+}
+
+func (octetCollation) matchCompiledReader(cm *CompiledMatcher, value io.Reader) (bool, error) {
+	return cm.MatchReader(value)
+}
+
+type asciiCaseMapCollation struct{}
+
+func (asciiCaseMapCollation) Equal(value, key string) (bool, error) {
+	return toLowerASCII(value) == toLowerASCII(key), nil
+}
+
+func (asciiCaseMapCollation) Contains(value, key string) (bool, error) {
+	return strings.Contains(toLowerASCII(value), toLowerASCII(key)), nil
+}
+
+func (asciiCaseMapCollation) Compare(value, key string) (int, error) {
+	return strings.Compare(toLowerASCII(value), toLowerASCII(key)), nil
+}
+
+func (asciiCaseMapCollation) Match(value, pattern string) (bool, []string, error) {
+	return matchOctet(pattern, value, true)
+}
+
+func (asciiCaseMapCollation) MatchReader(match Match, value io.Reader, key string) (bool, error) {
+	return matchRegexReader(match, value, key, true, true)
+}
+
+func (asciiCaseMapCollation) patternRegex(pattern string) (string, bool) {
+	return patternToRegex(pattern, true), true
+}
+
+func (asciiCaseMapCollation) matchCompiled(cm *CompiledMatcher, value string) (bool, []string, error) {
+	return cm.Match(value)
+}
+
+func (asciiCaseMapCollation) matchCompiledReader(cm *CompiledMatcher, value io.Reader) (bool, error) {
+	return cm.MatchReader(value)
+}
+
+type asciiNumericCollation struct{}
+
+func (asciiNumericCollation) Equal(value, key string) (bool, error) {
+	return RelEqual.CompareNumericValue(numericValue(value), numericValue(key)), nil
+}
+
+func (asciiNumericCollation) Contains(value, key string) (bool, error) {
+	return false, ErrComparatorMatchUnsupported
+}
+
+func (asciiNumericCollation) Compare(value, key string) (int, error) {
+	lhs, rhs := numericValue(value), numericValue(key)
+	switch {
+	case RelLessThan.CompareNumericValue(lhs, rhs):
+		return -1, nil
+	case RelGreaterThan.CompareNumericValue(lhs, rhs):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (asciiNumericCollation) Match(value, pattern string) (bool, []string, error) {
+	return false, nil, ErrComparatorMatchUnsupported
+}
+
+func (asciiNumericCollation) MatchReader(match Match, value io.Reader, key string) (bool, error) {
+	switch match {
+	case MatchIs:
+		lhsNum, err := numericValueReader(value)
+		if err != nil {
+			return false, err
+		}
+		return RelEqual.CompareNumericValue(lhsNum, numericValue(key)), nil
+	default:
+		return false, ErrComparatorMatchUnsupported
+	}
+}
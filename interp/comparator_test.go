@@ -0,0 +1,96 @@
+package interp
+
+import (
+	"context"
+	"net/textproto"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/parser"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// accentCollation ignores case and diacritics, it does not implement
+// ReaderCollation.
+type accentCollation struct{}
+
+func (accentCollation) fold(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, _ = transform.String(t, s)
+	return strings.ToLower(s)
+}
+
+func (c accentCollation) Equal(value, key string) (bool, error) {
+	return c.fold(value) == c.fold(key), nil
+}
+
+func (c accentCollation) Contains(value, key string) (bool, error) {
+	return strings.Contains(c.fold(value), c.fold(key)), nil
+}
+
+func (c accentCollation) Compare(value, key string) (int, error) {
+	return strings.Compare(c.fold(value), c.fold(key)), nil
+}
+
+func (c accentCollation) Match(value, pattern string) (bool, []string, error) {
+	return matchUnicode(c.fold(pattern), c.fold(value), false)
+}
+
+func init() {
+	RegisterComparator("x-test;accent-insensitive", accentCollation{})
+}
+
+func TestRegisteredComparator(t *testing.T) {
+	script := loadTestScript(t, `require ["comparator-x-test;accent-insensitive", "relational", "body", "fileinto"];
+if header :is :comparator "x-test;accent-insensitive" "subject" "resume" { fileinto "is"; }
+if header :contains :comparator "x-test;accent-insensitive" "subject" "SUM" { fileinto "contains"; }
+if header :matches :comparator "x-test;accent-insensitive" "subject" "r?s*" { fileinto "matches"; }
+if header :value "gt" :comparator "x-test;accent-insensitive" "subject" "resumd" { fileinto "value"; }
+if body :contains :comparator "x-test;accent-insensitive" "eleve" { fileinto "body"; }
+`, chainTestOptions)
+
+	raw := "Subject: Résumé\r\n\r\nUn Élève.\r\n"
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+		Size:       len(raw),
+		Header:     textproto.MIMEHeader{"Subject": {"Résumé"}},
+		RawMessage: []byte(raw),
+	})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"is", "contains", "matches", "value", "body"}
+	if strings.Join(d.Mailboxes, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected matches: %v", d.Mailboxes)
+	}
+
+	found := false
+	for _, ext := range SupportedExtensions() {
+		found = found || ext == "comparator-x-test;accent-insensitive"
+	}
+	if !found {
+		t.Error("registered comparator is not listed in SupportedExtensions")
+	}
+}
+
+func TestUnknownComparator(t *testing.T) {
+	for _, src := range []string{
+		`require "comparator-x-test;unknown";`,
+		`if header :is :comparator "x-test;unknown" "subject" "x" { stop; }`,
+	} {
+		toks, err := lexer.Lex(strings.NewReader(src), &lexer.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadScript(cmds, chainTestOptions); err == nil {
+			t.Errorf("expected load error for %q", src)
+		}
+	}
+}
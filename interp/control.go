@@ -2,24 +2,25 @@ package interp
 
 import (
 	"context"
-	"encoding/gob"
+
+	"github.com/foxcpp/go-sieve/lexer"
 )
 
 type CmdIf struct {
+	lexer.Position
 	Test  Test
 	Block []Cmd
 }
 
 func (c CmdIf) Execute(ctx context.Context, d *RuntimeData) error {
-	res, err := c.Test.Check(ctx, d)
+	res, err := checkTest(ctx, d, c.Test)
 	if err != nil {
 		return err
 	}
+	traceControl(d, c, res)
 	if res {
-		for _, c := range c.Block {
-			if err := c.Execute(ctx, d); err != nil {
-				return err
-			}
+		if err := executeBlock(ctx, d, c.Block); err != nil {
+			return err
 		}
 	}
 	d.ifResult = res
@@ -27,6 +28,7 @@ func (c CmdIf) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdElsif struct {
+	lexer.Position
 	Test  Test
 	Block []Cmd
 }
@@ -35,15 +37,14 @@ func (c CmdElsif) Execute(ctx context.Context, d *RuntimeData) error {
 	if d.ifResult {
 		return nil
 	}
-	res, err := c.Test.Check(ctx, d)
+	res, err := checkTest(ctx, d, c.Test)
 	if err != nil {
 		return err
 	}
+	traceControl(d, c, res)
 	if res {
-		for _, c := range c.Block {
-			if err := c.Execute(ctx, d); err != nil {
-				return err
-			}
+		if err := executeBlock(ctx, d, c.Block); err != nil {
+			return err
 		}
 	}
 	d.ifResult = res
@@ -51,6 +52,7 @@ func (c CmdElsif) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdElse struct {
+	lexer.Position
 	Block []Cmd
 }
 
@@ -58,16 +60,6 @@ func (c CmdElse) Execute(ctx context.Context, d *RuntimeData) error {
 	if d.ifResult {
 		return nil
 	}
-	for _, c := range c.Block {
-		if err := c.Execute(ctx, d); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func init() {
-	gob.Register(&CmdIf{})
-	gob.Register(&CmdElsif{})
-	gob.Register(&CmdElse{})
+	traceControl(d, c, true)
+	return executeBlock(ctx, d, c.Block)
 }
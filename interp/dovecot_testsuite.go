@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +20,7 @@ import (
 const DovecotTestExtension = "vnd.dovecot.testsuite"
 
 type CmdDovecotTest struct {
+	lexer.Position
 	TestName string
 	Cmds     []Cmd
 }
@@ -42,7 +42,7 @@ func (c CmdDovecotTest) Execute(ctx context.Context, d *RuntimeData) error {
 		}
 
 		for _, cmd := range c.Cmds {
-			if err := cmd.Execute(ctx, testData); err != nil {
+			if err := executeCmd(ctx, testData, cmd); err != nil {
 				if errors.Is(err, ErrStop) {
 					if testData.Test.FailMessage != "" {
 						t.Errorf("test_fail at %v called: %v", testData.Test.FailAt, testData.Test.FailMessage)
@@ -61,7 +61,7 @@ func (c CmdDovecotTest) Execute(ctx context.Context, d *RuntimeData) error {
 }
 
 type CmdDovecotTestFail struct {
-	At      lexer.Position
+	lexer.Position
 	Message string
 }
 
@@ -71,33 +71,37 @@ func (c CmdDovecotTestFail) Execute(_ context.Context, d *RuntimeData) error {
 	}
 
 	d.Test.FailMessage = expandVars(d, c.Message)
-	d.Test.FailAt = c.At
+	d.Test.FailAt = c.Position
 	return ErrStop
 }
 
 type CmdDovecotConfigSet struct {
+	lexer.Position
 	Unset bool
 	Key   string
 	Value string
 }
 
 func (c CmdDovecotConfigSet) Execute(_ context.Context, d *RuntimeData) error {
+	// Changes are visible for the rest of the execution,
+	// loaded Script is never modified.
+	opts := d.overrideOptions()
 	switch c.Key {
 	case "sieve_variables_max_variable_size":
 		if c.Unset {
-			d.Script.opts.MaxVariableLen = 4000
+			opts.MaxVariableLen = 4000
 		} else {
 			val, err := strconv.Atoi(c.Value)
 			if err != nil {
 				return err
 			}
-			d.Script.opts.MaxVariableLen = val
+			opts.MaxVariableLen = val
 		}
 	case "recipient_delimiter":
 		if c.Unset {
-			d.Script.opts.SubAddressSep = "+"
+			opts.SubAddressSep = "+"
 		} else {
-			d.Script.opts.SubAddressSep = c.Value
+			opts.SubAddressSep = c.Value
 		}
 	default:
 		return fmt.Errorf("unknown test_config_set key: %v", c.Key)
@@ -106,6 +110,7 @@ func (c CmdDovecotConfigSet) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdDovecotTestSet struct {
+	lexer.Position
 	VariableName  string
 	VariableValue string
 }
@@ -124,11 +129,11 @@ func (c CmdDovecotTestSet) Execute(_ context.Context, d *RuntimeData) error {
 			msgHdr = textproto.MIMEHeader{}
 		}
 
-		d.Msg = MessageStatic{
+		d.Msg = DecodedMessage{Message: MessageStatic{
 			Size:       len(c.VariableValue),
 			Header:     msgHdr,
 			RawMessage: []byte(c.VariableValue),
-		}
+		}}
 	case "envelope.from":
 		value = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
 
@@ -159,6 +164,7 @@ func (c CmdDovecotTestSet) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdDovecotBinarySave struct {
+	lexer.Position
 	Name string
 }
 
@@ -183,6 +189,7 @@ func (c CmdDovecotBinarySave) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdDovecotMessage struct {
+	lexer.Position
 	SMTP   bool
 	Folder string
 	Index  int
@@ -233,6 +240,7 @@ func (c CmdDovecotMessage) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type CmdDovecotMailboxCreate struct {
+	lexer.Position
 	Name string
 }
 
@@ -248,6 +256,7 @@ func (c CmdDovecotMailboxCreate) Execute(_ context.Context, d *RuntimeData) erro
 }
 
 type TestDovecotMessage struct {
+	lexer.Position
 	SMTP   bool
 	Folder string
 	Index  int
@@ -273,7 +282,9 @@ func (c TestDovecotMessage) Check(_ context.Context, d *RuntimeData) (bool, erro
 	return d.Test.Execute.HasMailboxMessage(folder, c.Index)
 }
 
-type CmdDovecotResultReset struct{}
+type CmdDovecotResultReset struct {
+	lexer.Position
+}
 
 func (c CmdDovecotResultReset) Execute(_ context.Context, d *RuntimeData) error {
 	if d.Test == nil {
@@ -295,6 +306,7 @@ func (c CmdDovecotResultReset) Execute(_ context.Context, d *RuntimeData) error
 }
 
 type CmdDovecotBinaryLoad struct {
+	lexer.Position
 	Name string
 }
 
@@ -318,6 +330,7 @@ func (c CmdDovecotBinaryLoad) Execute(_ context.Context, d *RuntimeData) error {
 }
 
 type TestDovecotCompile struct {
+	lexer.Position
 	ScriptPath string
 }
 
@@ -329,19 +342,30 @@ func (t TestDovecotCompile) Check(_ context.Context, d *RuntimeData) (bool, erro
 		return false, fmt.Errorf("RuntimeData.Namespace is not set, cannot load scripts")
 	}
 
-	svScript, err := fs.ReadFile(d.Namespace, t.ScriptPath)
+	script, err := loadNamespaceScript(d, t.ScriptPath)
 	if err != nil {
-		d.Script.opts.T.Log("ReadFile failed:", err)
+		d.Script.opts.T.Log(err)
 		return false, nil
 	}
 
+	d.Test.Script = script
+	return true, nil
+}
+
+// loadNamespaceScript loads the script from d.Namespace as a regular Sieve
+// script without the test environment.
+func loadNamespaceScript(d *RuntimeData, path string) (*Script, error) {
+	svScript, err := fs.ReadFile(d.Namespace, path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile failed: %w", err)
+	}
+
 	toks, err := lexer.Lex(bytes.NewReader(svScript), &lexer.Options{
-		Filename:  t.ScriptPath,
+		Filename:  path,
 		MaxTokens: 5000,
 	})
 	if err != nil {
-		d.Script.opts.T.Log("lexer.Lex failed:", err)
-		return false, nil
+		return nil, fmt.Errorf("lexer.Lex failed: %w", err)
 	}
 
 	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{
@@ -349,23 +373,20 @@ func (t TestDovecotCompile) Check(_ context.Context, d *RuntimeData) (bool, erro
 		MaxTestNesting:  d.Test.MaxNesting,
 	})
 	if err != nil {
-		d.Script.opts.T.Log("parser.Parse failed:", err)
-		return false, nil
+		return nil, fmt.Errorf("parser.Parse failed: %w", err)
 	}
 
 	script, err := LoadScript(cmds, &Options{
 		MaxRedirects: d.Script.opts.MaxRedirects,
 	})
 	if err != nil {
-		d.Script.opts.T.Log("LoadScript failed:", err)
-		return false, nil
+		return nil, fmt.Errorf("LoadScript failed: %w", err)
 	}
-
-	d.Test.Script = script
-	return true, nil
+	return script, nil
 }
 
 type TestDovecotRun struct {
+	lexer.Position
 }
 
 func (t TestDovecotRun) Check(ctx context.Context, d *RuntimeData) (bool, error) {
@@ -378,6 +399,7 @@ func (t TestDovecotRun) Check(ctx context.Context, d *RuntimeData) (bool, error)
 
 	testD := d.Copy()
 	testD.Script = d.Test.Script
+	testD.opts = nil
 	// Note: Loaded script has no test environment available -
 	// it is a regular Sieve script.
 
@@ -393,7 +415,53 @@ func (t TestDovecotRun) Check(ctx context.Context, d *RuntimeData) (bool, error)
 	return true, nil
 }
 
+// TestDovecotMultiscript implements test_multiscript: it loads the
+// scripts and executes them as ScriptChain, executing the results.
+type TestDovecotMultiscript struct {
+	lexer.Position
+	Scripts []string
+}
+
+func (t TestDovecotMultiscript) Check(ctx context.Context, d *RuntimeData) (bool, error) {
+	if d.Test == nil {
+		return false, fmt.Errorf("test runtime is not configured")
+	}
+	if d.Test.Execute == nil {
+		return false, fmt.Errorf("test execution environment is not configured")
+	}
+	if d.Namespace == nil {
+		return false, fmt.Errorf("RuntimeData.Namespace is not set, cannot load scripts")
+	}
+
+	chain := make(ScriptChain, 0, len(t.Scripts))
+	for _, path := range t.Scripts {
+		script, err := loadNamespaceScript(d, path)
+		if err != nil {
+			d.Script.opts.T.Log(err)
+			return false, nil
+		}
+		chain = append(chain, script)
+	}
+
+	testD := d.Copy()
+	testD.AppliedActions = nil
+	testD.Mailboxes = nil
+	testD.RedirectAddr = nil
+	testD.opts = nil
+	if err := chain.Execute(ctx, testD); err != nil {
+		d.Script.opts.T.Log("multiscript execution failed:", err)
+		return false, nil
+	}
+
+	if err := d.Test.Execute.ExecuteActions(testD, testD.AppliedActions); err != nil {
+		return false, err
+	}
+	d.AppliedActions = testD.AppliedActions
+	return true, nil
+}
+
 type TestDovecotTestError struct {
+	lexer.Position
 	matcherTest
 }
 
@@ -405,6 +473,7 @@ func (t TestDovecotTestError) Check(_ context.Context, _ *RuntimeData) (bool, er
 }
 
 type TestDovecotResultAction struct {
+	lexer.Position
 	matcherTest
 	Index *int
 }
@@ -454,7 +523,9 @@ func (t TestDovecotResultAction) Check(_ context.Context, d *RuntimeData) (bool,
 	return false, nil
 }
 
-type TestDovecotResultExecute struct{}
+type TestDovecotResultExecute struct {
+	lexer.Position
+}
 
 func (t TestDovecotResultExecute) Check(_ context.Context, d *RuntimeData) (bool, error) {
 	if d.Test == nil {
@@ -469,20 +540,3 @@ func (t TestDovecotResultExecute) Check(_ context.Context, d *RuntimeData) (bool
 	}
 	return true, nil
 }
-
-func init() {
-	gob.Register(CmdDovecotTest{})
-	gob.Register(CmdDovecotTestFail{})
-	gob.Register(CmdDovecotConfigSet{})
-	gob.Register(CmdDovecotBinarySave{})
-	gob.Register(CmdDovecotBinaryLoad{})
-	gob.Register(CmdDovecotMessage{})
-	gob.Register(CmdDovecotResultReset{})
-	gob.Register(CmdDovecotMailboxCreate{})
-	gob.Register(TestDovecotMessage{})
-	gob.Register(TestDovecotCompile{})
-	gob.Register(TestDovecotRun{})
-	gob.Register(TestDovecotTestError{})
-	gob.Register(TestDovecotResultAction{})
-	gob.Register(TestDovecotResultExecute{})
-}
@@ -0,0 +1,77 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// IDNForm selects the form domain names are converted to before they are
+// compared by address tests.
+type IDNForm int
+
+const (
+	// IDNAsIs compares domain names as they appear in the message.
+	IDNAsIs IDNForm = iota
+	// IDNUnicode converts A-labels ("xn--...") into U-labels, so keys
+	// should be written using Unicode characters.
+	IDNUnicode
+	// IDNASCII converts U-labels into A-labels, so keys should be
+	// written using punycode.
+	IDNASCII
+)
+
+var errIDNInvalid = errors.New("address: invalid internationalized domain name")
+
+// normalizeDomain converts the domain into the requested form using the
+// IDNA2008 lookup profile (UTS #46 mapping without transitional
+// processing): labels are lower-cased, normalized using NFC and validated.
+// Domain literals are not changed.
+func normalizeDomain(domain string, form IDNForm) (string, error) {
+	if form == IDNAsIs || strings.HasPrefix(domain, "[") {
+		return domain, nil
+	}
+
+	if err := checkALabels(domain); err != nil {
+		return "", err
+	}
+	converted, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errIDNInvalid, err)
+	}
+	if form == IDNUnicode {
+		converted, err = idna.Lookup.ToUnicode(converted)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errIDNInvalid, err)
+		}
+	}
+	return converted, nil
+}
+
+// checkALabels rejects labels with the ACE prefix that do not encode
+// any non-ASCII characters (RFC 5890 Section 2.3.2.1), idna.Lookup
+// silently converts them to plain ASCII labels.
+func checkALabels(domain string) error {
+	for _, label := range strings.Split(toLowerASCII(domain), ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		uLabel, err := idna.Punycode.ToUnicode(label)
+		if err != nil || isASCII(uLabel) {
+			return errIDNInvalid
+		}
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,9 @@ package interp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/foxcpp/go-sieve/lexer"
@@ -14,20 +16,36 @@ var supportedRequires = map[string]struct{}{
 	"envelope":          {},
 	"encoded-character": {},
 
-	"comparator-i;octet":           {},
-	"comparator-i;ascii-casemap":   {},
-	"comparator-i;ascii-numeric":   {},
-	"comparator-i;unicode-casemap": {},
-
-	"imap4flags":   {},
-	"variables":    {},
-	"relational":   {},
-	"copy":         {},
-	"reject":       {},
-	"ereject":      {},
-	"subaddress":   {},
-	"environment":  {},
-	"body":         {},
+	"imap4flags":  {},
+	"variables":   {},
+	"relational":  {},
+	"copy":        {},
+	"reject":      {},
+	"ereject":     {},
+	"subaddress":  {},
+	"environment": {},
+	"body":        {},
+
+	AttachmentExtension: {},
+}
+
+// SupportedExtensions returns the sorted list of extensions that can be
+// used in 'require', including "comparator-<name>" for all registered
+// comparators.
+func SupportedExtensions() []string {
+	exts := comparatorExtensions()
+	for ext := range supportedRequires {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func extensionSupported(ext string) bool {
+	if _, ok := supportedRequires[ext]; ok {
+		return true
+	}
+	return isComparatorExtension(ext)
 }
 
 var (
@@ -92,6 +110,8 @@ func init() {
 		"environment": loadEnvironmentTest,
 		// RFC 5173 (body extension)
 		"body": loadBodyTest,
+		// vnd.go-sieve.attachment
+		"attachment": loadAttachmentTest,
 		// vnd.dovecot.testsuite
 		"test_script_compile": loadDovecotCompile,       // compile script (to test for compile errors)
 		"test_script_run":     loadDovecotRun,           // run script (to test for run-time errors)
@@ -99,30 +119,64 @@ func init() {
 		"test_message":        loadDovecotTestMessage,   // check results of test_result_execute - where messages are
 		"test_result_action":  loadDovecotResultAction,  // check results of test_result_execute - what actions are executed
 		"test_result_execute": loadDovecotResultExecute, // apply script results (validated using test_message)
+		"test_multiscript":    loadDovecotMultiscript,   // run and apply results of a sequence of scripts
 	}
 }
 
+// LoadScript loads the parsed script. opts are copied and can be reused
+// by the caller.
 func LoadScript(cmdStream []parser.Cmd, opts *Options) (*Script, error) {
+	return LoadScriptRecover(cmdStream, opts, 0)
+}
+
+// LoadScriptRecover is like LoadScript, but if maxErrors is non-zero it
+// continues after a failing command and returns lexer.ErrorList with up to
+// maxErrors errors.
+func LoadScriptRecover(cmdStream []parser.Cmd, opts *Options, maxErrors int) (*Script, error) {
+	optsCopy := *opts
+	optsCopy.DisabledTests = append([]string(nil), opts.DisabledTests...)
 	s := &Script{
 		extensions: map[string]struct{}{},
-		opts:       opts,
+		opts:       &optsCopy,
+	}
+	if maxErrors != 0 {
+		s.loadErrs = &lexer.ErrorList{}
+		s.maxErrors = maxErrors
 	}
 
 	loadedCmds, err := LoadBlock(s, cmdStream)
-	if err != nil {
+	if err != nil && !errors.Is(err, errTooManyErrors) {
 		return nil, err
 	}
 	s.cmd = loadedCmds
 
+	if s.loadErrs != nil {
+		errs := *s.loadErrs
+		s.loadErrs = nil
+		if len(errs) != 0 {
+			return nil, errs
+		}
+	}
+
 	return s, nil
 }
 
+var errTooManyErrors = errors.New("too many errors")
+
 func LoadBlock(s *Script, cmds []parser.Cmd) ([]Cmd, error) {
 	loaded := make([]Cmd, 0, len(cmds))
 	for _, c := range cmds {
 		cmd, err := LoadCmd(s, c)
 		if err != nil {
-			return nil, fmt.Errorf("LoadCmd %s: %w", c.Id, err)
+			if s.loadErrs == nil || errors.Is(err, errTooManyErrors) {
+				return nil, fmt.Errorf("LoadCmd %s: %w", c.Id, err)
+			}
+			// Error-recovering mode: skip the command and continue.
+			s.loadErrs.Add(c.Position, err)
+			if len(*s.loadErrs) >= s.maxErrors {
+				return nil, errTooManyErrors
+			}
+			continue
 		}
 		if cmd == nil {
 			continue
@@ -151,12 +205,14 @@ func LoadTest(s *Script, t parser.Test) (Test, error) {
 	return factory(s, t)
 }
 
-type CmdNoop struct{}
+type CmdNoop struct {
+	lexer.Position
+}
 
 func (c CmdNoop) Execute(_ context.Context, _ *RuntimeData) error {
 	return nil
 }
 
-func loadNoop(_ *Script, _ parser.Cmd) (Cmd, error) {
-	return CmdNoop{}, nil
+func loadNoop(_ *Script, pcmd parser.Cmd) (Cmd, error) {
+	return CmdNoop{Position: pcmd.Position}, nil
 }
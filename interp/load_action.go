@@ -60,7 +60,7 @@ func loadFileInto(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension("fileinto") {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'fileinto")
 	}
-	cmd := CmdFileInto{}
+	cmd := CmdFileInto{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"flags": {
@@ -102,7 +102,7 @@ func loadFileInto(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadRedirect(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdRedirect{}
+	cmd := CmdRedirect{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"copy": {
@@ -129,12 +129,19 @@ func loadRedirect(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if cmd.Copy && !s.RequiresExtension("copy") {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'copy'")
 	}
+	if len(usedVars(s, cmd.Addr)) == 0 {
+		addr, err := parseRedirectAddr(cmd.Addr, s.opts)
+		if err != nil {
+			return nil, parser.ErrorAt(pcmd.Position, "redirect: %v", err)
+		}
+		cmd.Addr = addr
+	}
 
 	return cmd, nil
 }
 
 func loadKeep(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdKeep{}
+	cmd := CmdKeep{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"flags": {
@@ -158,7 +165,7 @@ func loadKeep(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadDiscard(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdDiscard{}
+	cmd := CmdDiscard{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{}, pcmd.Position, pcmd.Args, pcmd.Tests, pcmd.Block)
 	return cmd, err
 }
@@ -167,7 +174,7 @@ func loadReject(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension("reject") {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'reject'")
 	}
-	cmd := CmdReject{}
+	cmd := CmdReject{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -189,7 +196,7 @@ func loadEReject(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension("ereject") {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'ereject'")
 	}
-	cmd := CmdEReject{}
+	cmd := CmdEReject{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -248,7 +255,7 @@ func loadSetFlag(s *Script, pcmd parser.Cmd) (Cmd, error) {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'imap4flags")
 	}
 
-	cmd := CmdSetFlag{}
+	cmd := CmdSetFlag{Position: pcmd.Position}
 
 	variable, flag, err := loadFlagCmd(s, pcmd)
 	if err != nil {
@@ -265,7 +272,7 @@ func loadAddFlag(s *Script, pcmd parser.Cmd) (Cmd, error) {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'imap4flags")
 	}
 
-	cmd := CmdAddFlag{}
+	cmd := CmdAddFlag{Position: pcmd.Position}
 
 	variable, flag, err := loadFlagCmd(s, pcmd)
 	if err != nil {
@@ -281,7 +288,7 @@ func loadRemoveFlag(s *Script, pcmd parser.Cmd) (Cmd, error) {
 		return nil, parser.ErrorAt(pcmd.Position, "missing require 'imap4flags")
 	}
 
-	cmd := CmdRemoveFlag{}
+	cmd := CmdRemoveFlag{Position: pcmd.Position}
 
 	variable, flag, err := loadFlagCmd(s, pcmd)
 	if err != nil {
@@ -32,7 +32,7 @@ func loadRequire(s *Script, pcmd parser.Cmd) (Cmd, error) {
 			continue
 		}
 
-		if _, ok := supportedRequires[ext]; !ok {
+		if !extensionSupported(ext) {
 			return nil, fmt.Errorf("loadRequire: unsupported extension: %v", ext)
 		}
 		s.extensions[ext] = struct{}{}
@@ -41,7 +41,7 @@ func loadRequire(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadIf(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdIf{}
+	cmd := CmdIf{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		AddTest: func(t Test) {
 			cmd.Test = t
@@ -54,7 +54,7 @@ func loadIf(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadElsif(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdElsif{}
+	cmd := CmdElsif{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		AddTest: func(t Test) {
 			cmd.Test = t
@@ -67,7 +67,7 @@ func loadElsif(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadElse(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdElse{}
+	cmd := CmdElse{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		AddBlock: func(cmds []Cmd) {
 			cmd.Block = cmds
@@ -77,7 +77,7 @@ func loadElse(s *Script, pcmd parser.Cmd) (Cmd, error) {
 }
 
 func loadStop(s *Script, pcmd parser.Cmd) (Cmd, error) {
-	cmd := CmdStop{}
+	cmd := CmdStop{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{}, pcmd.Position, pcmd.Args, pcmd.Tests, pcmd.Block)
 	return cmd, err
 }
@@ -10,7 +10,7 @@ func loadDovecotTestSet(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension(DovecotTestExtension) || s.opts.T == nil {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
-	cmd := CmdDovecotTestSet{}
+	cmd := CmdDovecotTestSet{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -41,7 +41,7 @@ func loadDovecotTestFail(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension(DovecotTestExtension) || s.opts.T == nil {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
-	cmd := CmdDovecotTestFail{}
+	cmd := CmdDovecotTestFail{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -53,7 +53,6 @@ func loadDovecotTestFail(s *Script, pcmd parser.Cmd) (Cmd, error) {
 			},
 		},
 	}, pcmd.Position, pcmd.Args, pcmd.Tests, pcmd.Block)
-	cmd.At = pcmd.Position
 	if err != nil {
 		return nil, err
 	}
@@ -69,7 +68,7 @@ func loadDovecotTest(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension(DovecotTestExtension) || s.opts.T == nil {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
-	cmd := CmdDovecotTest{}
+	cmd := CmdDovecotTest{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -92,7 +91,7 @@ func loadDovecotCompile(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotCompile{}
+	loaded := TestDovecotCompile{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -107,12 +106,31 @@ func loadDovecotCompile(s *Script, test parser.Test) (Test, error) {
 	return loaded, err
 }
 
+func loadDovecotMultiscript(s *Script, test parser.Test) (Test, error) {
+	if !s.RequiresExtension(DovecotTestExtension) || s.opts.T == nil {
+		return nil, fmt.Errorf("testing environment is not enabled")
+	}
+
+	loaded := TestDovecotMultiscript{Position: test.Position}
+	err := LoadSpec(s, &Spec{
+		Pos: []SpecPosArg{
+			{
+				MatchStr: func(val []string) {
+					loaded.Scripts = val
+				},
+				MinStrCount: 1,
+			},
+		},
+	}, test.Position, test.Args, test.Tests, nil)
+	return loaded, err
+}
+
 func loadDovecotConfigSet(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	if !s.RequiresExtension(DovecotTestExtension) || s.opts.T == nil {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotConfigSet{}
+	loaded := CmdDovecotConfigSet{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -140,7 +158,8 @@ func loadDovecotConfigUnset(s *Script, pcmd parser.Cmd) (Cmd, error) {
 	}
 
 	loaded := CmdDovecotConfigSet{
-		Unset: true,
+		Position: pcmd.Position,
+		Unset:    true,
 	}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
@@ -168,7 +187,7 @@ func loadDovecotBinarySave(s *Script, pcmd parser.Cmd) (Cmd, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotBinarySave{}
+	loaded := CmdDovecotBinarySave{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -188,7 +207,7 @@ func loadDovecotBinaryLoad(s *Script, pcmd parser.Cmd) (Cmd, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotBinaryLoad{}
+	loaded := CmdDovecotBinaryLoad{Position: pcmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -208,7 +227,7 @@ func loadDovecotRun(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotRun{}
+	loaded := TestDovecotRun{Position: test.Position}
 	err := LoadSpec(s, &Spec{}, test.Position, test.Args, test.Tests, nil)
 	return loaded, err
 }
@@ -218,7 +237,7 @@ func loadDovecotError(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotTestError{matcherTest: newMatcherTest()}
+	loaded := TestDovecotTestError{Position: test.Position, matcherTest: newMatcherTest()}
 	err := LoadSpec(s, loaded.addSpecTags(&Spec{
 		Tags: map[string]SpecTag{
 			"index": {
@@ -244,7 +263,7 @@ func loadDovecotResultAction(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotResultAction{matcherTest: newMatcherTest()}
+	loaded := TestDovecotResultAction{Position: test.Position, matcherTest: newMatcherTest()}
 	err := LoadSpec(s, loaded.addSpecTags(&Spec{
 		Tags: map[string]SpecTag{
 			"index": {
@@ -282,7 +301,7 @@ func loadDovecotResultReset(s *Script, cmd parser.Cmd) (Cmd, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotResultReset{}
+	loaded := CmdDovecotResultReset{Position: cmd.Position}
 	err := LoadSpec(s, &Spec{}, cmd.Position, cmd.Args, cmd.Tests, nil)
 	return loaded, err
 }
@@ -292,7 +311,7 @@ func loadDovecotMailboxCreate(s *Script, cmd parser.Cmd) (Cmd, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotMailboxCreate{}
+	loaded := CmdDovecotMailboxCreate{Position: cmd.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -312,7 +331,7 @@ func loadDovecotCmdMessage(s *Script, cmd parser.Cmd) (Cmd, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := CmdDovecotMessage{}
+	loaded := CmdDovecotMessage{Position: cmd.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"index": {
@@ -355,7 +374,7 @@ func loadDovecotResultExecute(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotResultExecute{}
+	loaded := TestDovecotResultExecute{Position: test.Position}
 	err := LoadSpec(s, &Spec{}, test.Position, test.Args, test.Tests, nil)
 	return loaded, err
 }
@@ -365,7 +384,7 @@ func loadDovecotTestMessage(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("testing environment is not enabled")
 	}
 
-	loaded := TestDovecotMessage{}
+	loaded := TestDovecotMessage{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"index": {
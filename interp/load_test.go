@@ -52,8 +52,9 @@ func TestLoadBlock(t *testing.T) {
 	}
 	testCmdLoader(t, s, `require ["envelope"];`, []Cmd{})
 	testCmdLoader(t, s, `if true { }`, []Cmd{CmdIf{
-		Test:  TrueTest{},
-		Block: []Cmd{},
+		Position: lexer.LineCol(1, 1),
+		Test:     TrueTest{Position: lexer.LineCol(1, 4)},
+		Block:    []Cmd{},
 	}})
 	testCmdLoader(t, s, `require "envelope";
 require "fileinto";
@@ -62,7 +63,9 @@ if envelope :is "from" "test@example.org" {
 }
 `, []Cmd{
 		CmdIf{
+			Position: lexer.LineCol(3, 1),
 			Test: EnvelopeTest{
+				Position: lexer.LineCol(3, 4),
 				matcherTest: matcherTest{
 					Comparator: ComparatorASCIICaseMap,
 					Match:      MatchIs,
@@ -73,7 +76,7 @@ if envelope :is "from" "test@example.org" {
 				Field:       []string{"from"},
 			},
 			Block: []Cmd{
-				CmdFileInto{Mailbox: "hell"},
+				CmdFileInto{Position: lexer.LineCol(4, 2), Mailbox: "hell"},
 			},
 		},
 	})
@@ -86,20 +89,25 @@ addflag ["flag2", "flag1"];
 removeflag "flag2";
 `, []Cmd{
 		CmdFileInto{
-			Mailbox: "hell",
-			Flags:   Flags{"flag1", "flag2"},
+			Position: lexer.LineCol(3, 1),
+			Mailbox:  "hell",
+			Flags:    Flags{"flag1", "flag2"},
 		},
 		CmdKeep{
-			Flags: Flags{"flag1", "flag2"},
+			Position: lexer.LineCol(4, 1),
+			Flags:    Flags{"flag1", "flag2"},
 		},
 		CmdSetFlag{
-			Flags: Flags{"flag2", "flag1"},
+			Position: lexer.LineCol(5, 1),
+			Flags:    Flags{"flag2", "flag1"},
 		},
 		CmdAddFlag{
-			Flags: Flags{"flag2", "flag1"},
+			Position: lexer.LineCol(6, 1),
+			Flags:    Flags{"flag2", "flag1"},
 		},
 		CmdRemoveFlag{
-			Flags: Flags{"flag2"},
+			Position: lexer.LineCol(7, 1),
+			Flags:    Flags{"flag2"},
 		},
 	})
 }
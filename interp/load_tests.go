@@ -8,6 +8,7 @@ import (
 
 func loadAddressTest(s *Script, test parser.Test) (Test, error) {
 	loaded := AddressTest{
+		Position:    test.Position,
 		matcherTest: newMatcherTest(),
 		AddressPart: All,
 	}
@@ -70,7 +71,7 @@ func loadAddressTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadAllOfTest(s *Script, test parser.Test) (Test, error) {
-	loaded := AllOfTest{}
+	loaded := AllOfTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		AddTest: func(t Test) {
 			loaded.Tests = append(loaded.Tests, t)
@@ -81,7 +82,7 @@ func loadAllOfTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadAnyOfTest(s *Script, test parser.Test) (Test, error) {
-	loaded := AnyOfTest{}
+	loaded := AnyOfTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		AddTest: func(t Test) {
 			loaded.Tests = append(loaded.Tests, t)
@@ -97,6 +98,7 @@ func loadEnvelopeTest(s *Script, test parser.Test) (Test, error) {
 	}
 
 	loaded := EnvelopeTest{
+		Position:    test.Position,
 		matcherTest: newMatcherTest(),
 		AddressPart: All,
 	}
@@ -159,7 +161,7 @@ func loadEnvelopeTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadExistsTest(s *Script, test parser.Test) (Test, error) {
-	loaded := ExistsTest{}
+	loaded := ExistsTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		Pos: []SpecPosArg{
 			{
@@ -174,19 +176,19 @@ func loadExistsTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadFalseTest(s *Script, test parser.Test) (Test, error) {
-	loaded := FalseTest{}
+	loaded := FalseTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{}, test.Position, test.Args, test.Tests, nil)
 	return loaded, err
 }
 
 func loadTrueTest(s *Script, test parser.Test) (Test, error) {
-	loaded := TrueTest{}
+	loaded := TrueTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{}, test.Position, test.Args, test.Tests, nil)
 	return loaded, err
 }
 
 func loadHeaderTest(s *Script, test parser.Test) (Test, error) {
-	loaded := HeaderTest{matcherTest: newMatcherTest()}
+	loaded := HeaderTest{Position: test.Position, matcherTest: newMatcherTest()}
 	var key []string
 	err := LoadSpec(s, loaded.addSpecTags(&Spec{
 		Pos: []SpecPosArg{
@@ -216,7 +218,7 @@ func loadHeaderTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadNotTest(s *Script, test parser.Test) (Test, error) {
-	loaded := NotTest{}
+	loaded := NotTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		AddTest: func(t Test) {
 			loaded.Test = t
@@ -226,7 +228,7 @@ func loadNotTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadSizeTest(s *Script, test parser.Test) (Test, error) {
-	loaded := SizeTest{}
+	loaded := SizeTest{Position: test.Position}
 	err := LoadSpec(s, &Spec{
 		Tags: map[string]SpecTag{
 			"under": {
@@ -251,7 +253,7 @@ func loadSizeTest(s *Script, test parser.Test) (Test, error) {
 }
 
 func loadHasFlagTest(s *Script, test parser.Test) (Test, error) {
-	loaded := HasFlagTest{matcherTest: newMatcherTest()}
+	loaded := HasFlagTest{Position: test.Position, matcherTest: newMatcherTest()}
 	var key, arg1, arg2 []string
 	err := LoadSpec(s, loaded.addSpecTags(&Spec{
 		Pos: []SpecPosArg{
@@ -294,6 +296,7 @@ func loadBodyTest(s *Script, test parser.Test) (Test, error) {
 	}
 
 	loaded := BodyTest{
+		Position:    test.Position,
 		matcherTest: newMatcherTest(),
 		Transform:   BodyTransformText, // default transform
 	}
@@ -361,7 +364,7 @@ func loadEnvironmentTest(s *Script, test parser.Test) (Test, error) {
 		return nil, fmt.Errorf("missing require 'environment'")
 	}
 
-	loaded := EnvironmentTest{matcherTest: newMatcherTest()}
+	loaded := EnvironmentTest{Position: test.Position, matcherTest: newMatcherTest()}
 	var key []string
 	err := LoadSpec(s, loaded.addSpecTags(&Spec{
 		Pos: []SpecPosArg{
@@ -2,8 +2,6 @@ package interp
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/gob"
 	"io"
 	"regexp"
 	"strings"
@@ -19,6 +17,12 @@ func foldASCII(b byte) byte {
 }
 
 func patternToRegex(pattern string, caseFold bool) string {
+	return wildcardToRegex(pattern, caseFold, `(.)`)
+}
+
+// wildcardToRegex converts the :matches pattern into a regular expression,
+// anyChar is the expression used for '?'.
+func wildcardToRegex(pattern string, caseFold bool, anyChar string) string {
 	result := strings.Builder{}
 	if caseFold {
 		result.WriteString(`(?i)`)
@@ -31,7 +35,7 @@ func patternToRegex(pattern string, caseFold bool) string {
 			case '\\':
 				escaped = true
 			case '?':
-				result.WriteString(`(.)`)
+				result.WriteString(anyChar)
 			case '*':
 				result.WriteString(`((?s:.*?))`)
 			case '.', '+', '(', ')', '|', '[', ']', '{', '}', '^', '$':
@@ -79,15 +83,6 @@ func (cm *CompiledMatcher) IsLoaded() bool {
 	return cm.loaded
 }
 
-func (cm *CompiledMatcher) GobDecode(i []byte) error {
-	err := gob.NewDecoder(bytes.NewBuffer(i)).Decode(&cm.compiledMatcherData)
-	if err != nil {
-		return err
-	}
-
-	return cm.restore()
-}
-
 func (cm *CompiledMatcher) restore() error {
 	var err error
 	if cm.Octet {
@@ -104,15 +99,6 @@ func (cm *CompiledMatcher) restore() error {
 	return nil
 }
 
-func (cm *CompiledMatcher) GobEncode() ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(cm.compiledMatcherData)
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
-}
-
 func (cm *CompiledMatcher) Match(value string) (bool, []string, error) {
 	if cm.binary != nil {
 		matches := cm.binary.FindStringSubmatch(value)
@@ -141,23 +127,25 @@ func (cm *CompiledMatcher) MatchReader(r io.Reader) (bool, error) {
 	return cm.string.MatchReader(rr), nil
 }
 
-// compileMatcher returns a function that will check whether pre-defined pattern matches the passed
-// value. It is preferable to use compileMatcher over matchOctet, matchUnicode if
+// compileMatcher compiles the regular expression produced from the pattern
+// that will check whether pre-defined pattern matches the passed value.
+// It is preferable to use compileMatcher over matchOctet, matchUnicode if
 // pattern does not change often (e.g. does not depend on any variables).
-func compileMatcher(pattern string, octet bool, caseFold bool) (CompiledMatcher, error) {
-	res := CompiledMatcher{}
-	res.Regexp = patternToRegex(pattern, caseFold)
-	res.Octet = octet
-
-	if err := res.restore(); err != nil {
-		return CompiledMatcher{}, err
+//
+// Options.CompileMatcher is used to compile the pattern, if set.
+func (s *Script) compileMatcher(regex string, octet bool) (CompiledMatcher, error) {
+	if s.opts != nil && s.opts.CompileMatcher != nil {
+		return s.opts.CompileMatcher(regex, octet)
 	}
-	return res, nil
+	return CompileMatcherRegex(regex, octet)
 }
 
-// comppileMatcherRegex is compileMatcher that accepts regular expression as input
-// instead of Sieve patterns.
-func compileMatcherRegex(regex string, octet bool) (CompiledMatcher, error) {
+// CompileMatcherRegex compiles the regular expression produced from a
+// :matches pattern. If octet is true, the matcher operates on bytes
+// instead of UTF-8 runes.
+//
+// It is the default implementation of Options.CompileMatcher.
+func CompileMatcherRegex(regex string, octet bool) (CompiledMatcher, error) {
 	res := CompiledMatcher{}
 	res.Regexp = regex
 	res.Octet = octet
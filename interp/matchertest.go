@@ -1,7 +1,6 @@
 package interp
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -116,27 +115,23 @@ func (t *matcherTest) setKey(s *Script, k []string) error {
 		}
 	}
 
-	caseFold := false
-	octet := false
-	switch t.Comparator {
-	case ComparatorOctet, ComparatorUnicodeCaseMap, ComparatorASCIICaseMap:
-		octet = t.Comparator.IsOctet()
-		caseFold = t.Comparator.IsCaseMap()
-	case ComparatorASCIINumeric:
-	case "":
-	default:
-		return fmt.Errorf("unsupported comparator: %v", t.Comparator)
+	c, err := lookupCollation(t.Comparator)
+	if err != nil {
+		return err
 	}
 
-	if t.Match == MatchMatches {
+	// Patterns of built-in comparators are compiled upfront, other
+	// comparators match them at run time.
+	if pc, ok := c.(patternCollation); ok && t.Match == MatchMatches {
 		t.keyCompiled = make([]CompiledMatcher, len(t.Key))
 		for i := range t.Key {
 			if len(usedVars(s, t.Key[i])) > 0 {
 				continue
 			}
 
+			regex, octet := pc.patternRegex(t.Key[i])
 			var err error
-			t.keyCompiled[i], err = compileMatcher(t.Key[i], octet, caseFold)
+			t.keyCompiled[i], err = s.compileMatcher(regex, octet)
 			if err != nil {
 				return fmt.Errorf("malformed pattern (%v): %v", t.Key[i], err)
 			}
@@ -158,6 +153,7 @@ func (t *matcherTest) countMatches(d *RuntimeData, value uint64) bool {
 	if !t.isCount() {
 		panic("countMatches can be called only with MatchCount matcher")
 	}
+	d.traceCompared(strconv.FormatUint(value, 10))
 
 	for _, k := range t.Key {
 		kNum, err := strconv.ParseUint(expandVars(d, k), 10, 64)
@@ -173,7 +169,11 @@ func (t *matcherTest) countMatches(d *RuntimeData, value uint64) bool {
 	return false
 }
 
-func (t *matcherTest) tryMatchBodyPart(ctx context.Context, d *RuntimeData, part BodyPart, stripHTML bool) (bool, error) {
+func (t *matcherTest) tryMatchBodyPart(ctx context.Context, d *RuntimeData, part BodyPart, stripHTML bool, scan *bodyScan) (bool, error) {
+	if scan.exhausted() {
+		return false, nil
+	}
+	scanPart := scan.part()
 	for i, key := range t.Key {
 		partReader, err := part.Open(ctx)
 		if err != nil {
@@ -186,26 +186,30 @@ func (t *matcherTest) tryMatchBodyPart(ctx context.Context, d *RuntimeData, part
 			return false, fmt.Errorf("open part: %w", err)
 		}
 
-		r := io.Reader(partReader)
+		scanR := scanPart.reader(partReader)
+		var r io.Reader = scanR
 		if stripHTML {
-			br, ok := r.(io.ByteReader)
-			if !ok {
-				br = bufio.NewReader(partReader)
-			}
-			r = &htmlStripper{BR: br}
+			r = newHTMLTextReader(r)
 		}
 
 		var ok bool
 		if t.keyCompiled != nil && t.keyCompiled[i].IsLoaded() {
-			ok, err = t.keyCompiled[i].MatchReader(r)
+			ok, err = matchCompiledReader(t.Comparator, &t.keyCompiled[i], r)
 		} else {
 			key = expandVars(d, key)
 			ok, err = testReader(t.Comparator, t.Match, r, expandVars(d, key))
 		}
+		if scanR.err != nil {
+			err = scanR.err
+		}
 		if err != nil {
 			_ = partReader.Close()
 			return false, err
 		}
+		if ok && t.Match == MatchIs && scanR.truncated {
+			// Only the prefix of the part was compared.
+			ok = false
+		}
 		if ok {
 			_ = partReader.Close()
 			return true, nil
@@ -216,6 +220,10 @@ func (t *matcherTest) tryMatchBodyPart(ctx context.Context, d *RuntimeData, part
 }
 
 func (t *matcherTest) tryMatch(d *RuntimeData, source string) (bool, error) {
+	d.traceCompared(source)
+	if err := d.chargeScan(len(source)); err != nil {
+		return false, err
+	}
 	for i, key := range t.Key {
 		var (
 			ok      bool
@@ -223,7 +231,7 @@ func (t *matcherTest) tryMatch(d *RuntimeData, source string) (bool, error) {
 			err     error
 		)
 		if t.keyCompiled != nil && t.keyCompiled[i].IsLoaded() {
-			ok, matches, err = t.keyCompiled[i].Match(source)
+			ok, matches, err = matchCompiled(t.Comparator, &t.keyCompiled[i], source)
 		} else {
 			key = expandVars(d, key)
 			ok, matches, err = testString(t.Comparator, t.Match, t.Relational, source, expandVars(d, key))
@@ -233,6 +241,13 @@ func (t *matcherTest) tryMatch(d *RuntimeData, source string) (bool, error) {
 		}
 		if ok {
 			if t.Match == MatchMatches {
+				size := 0
+				for _, m := range matches {
+					size += len(m)
+				}
+				if err := d.chargeVariable(size); err != nil {
+					return false, err
+				}
 				d.MatchVariables = matches
 			}
 			return true, nil
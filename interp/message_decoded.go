@@ -0,0 +1,68 @@
+package interp
+
+import (
+	"context"
+	"io"
+)
+
+// DecodedMessage wraps a Message that returns raw header field values
+// (such as MessageStatic) and converts them as required by
+// Message.HeaderGet:
+//
+//   - RFC 2047 encoded words are decoded into UTF-8. Encoded words in
+//     unknown charsets are left as is, i.e. treated as US-ASCII text.
+//   - RFC 2231 encoded parameters of Content-Type and Content-Disposition
+//     fields are decoded and replaced with quoted strings.
+//
+// Charsets are converted using the same table as body parts, see
+// RegisterCharset.
+//
+// BodyMessage and AttachmentMessage methods are passed through to the
+// wrapped Message. If it does not implement them, body and attachment
+// tests see a message without a body.
+//
+// MessageReader already decodes header fields and does not need to be
+// wrapped.
+type DecodedMessage struct {
+	Message Message
+}
+
+func (m DecodedMessage) HeaderGet(key string) ([]string, error) {
+	values, err := m.Message.HeaderGet(key)
+	if err != nil || len(values) == 0 {
+		return values, err
+	}
+	decoded := make([]string, len(values))
+	for i, v := range values {
+		decoded[i] = decodeHeaderField(key, v)
+	}
+	return decoded, nil
+}
+
+func (m DecodedMessage) MessageSize() int {
+	return m.Message.MessageSize()
+}
+
+func (m DecodedMessage) BodyRaw(ctx context.Context) (io.Reader, error) {
+	bm, ok := m.Message.(BodyMessage)
+	if !ok {
+		return nil, nil
+	}
+	return bm.BodyRaw(ctx)
+}
+
+func (m DecodedMessage) BodyParts(ctx context.Context, contentTypes []string) ([]BodyPart, error) {
+	bm, ok := m.Message.(BodyMessage)
+	if !ok {
+		return nil, nil
+	}
+	return bm.BodyParts(ctx, contentTypes)
+}
+
+func (m DecodedMessage) Attachments(ctx context.Context) ([]Attachment, error) {
+	am, ok := m.Message.(AttachmentMessage)
+	if !ok {
+		return nil, nil
+	}
+	return am.Attachments(ctx)
+}
@@ -0,0 +1,99 @@
+package interp
+
+import (
+	"context"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHeaderValue(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain text":                                    "plain text",
+		"=?utf-8?q?Rechnung_f=C3=BCr?= Mai":             "Rechnung für Mai",
+		"=?UTF-8?B?0J/RgNC40LLQtdGC?= =?utf-8?q?!?=":    "Привет!",
+		"=?x-unknown?q?abc?= =?utf-8?q?d=C3=A9f?=":      "=?x-unknown?q?abc?= déf",
+		"=?utf-8?q?a?= =?x-unknown?q?b?= =?utf-8?q?c?=": "a =?x-unknown?q?b?= c",
+		"=?utf-8?q?nul=00byte?=":                        "nul\x00byte",
+		"=?utf-8?q?broken":                              "=?utf-8?q?broken",
+	} {
+		if got := decodeHeaderValue(value); got != want {
+			t.Errorf("decodeHeaderValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestDecodeHeaderField(t *testing.T) {
+	tests := []struct {
+		key, value, want string
+	}{
+		{
+			"Content-Disposition",
+			`attachment; filename*=utf-8''Rechnung%20f%C3%BCr%20Mai.pdf; size=10`,
+			`attachment; filename="Rechnung für Mai.pdf"; size="10"`,
+		},
+		{
+			"content-type",
+			`Text/Plain; charset=us-ascii; name*0="a\"b"; name*1=".txt"`,
+			`Text/Plain; charset="us-ascii"; name="a\"b.txt"`,
+		},
+		{
+			"Content-Type",
+			`text/plain; charset=us-ascii`,
+			`text/plain; charset=us-ascii`,
+		},
+		{
+			"Subject",
+			`=?utf-8?q?a*b?=`,
+			`a*b`,
+		},
+	}
+	for _, tt := range tests {
+		if got := decodeHeaderField(tt.key, tt.value); got != tt.want {
+			t.Errorf("decodeHeaderField(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecodedMessage(t *testing.T) {
+	script := loadTestScript(t, `require ["fileinto", "body"];
+if header :contains "subject" "Rechnung" { fileinto "subject"; }
+if address :domain "from" "example.org" { fileinto "from"; }
+if header :contains "content-type" "für" { fileinto "param"; }
+if body :raw :contains "text" { fileinto "body"; }
+`, chainTestOptions)
+
+	raw := "From: =?utf-8?q?J=C3=B6rg?= <joerg@example.org>\r\n" +
+		"Subject: =?utf-8?b?SWhyZSBSZWNobnVuZw==?=\r\n" +
+		"Content-Type: text/plain; name*=utf-8''f%C3%BCr.txt\r\n" +
+		"\r\n" +
+		"text\r\n"
+	msg := MessageStatic{
+		Size: len(raw),
+		Header: textproto.MIMEHeader{
+			"From":         {"=?utf-8?q?J=C3=B6rg?= <joerg@example.org>"},
+			"Subject":      {"=?utf-8?b?SWhyZSBSZWNobnVuZw==?="},
+			"Content-Type": {"text/plain; name*=utf-8''f%C3%BCr.txt"},
+		},
+		RawMessage: []byte(raw),
+	}
+
+	for _, tt := range []struct {
+		name string
+		msg  Message
+		want []string
+	}{
+		{"raw", msg, []string{"from", "body"}},
+		{"decoded", DecodedMessage{Message: msg}, []string{"subject", "from", "param", "body"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, tt.msg)
+			if err := script.Execute(context.Background(), d); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(d.Mailboxes, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("unexpected matches: %q", d.Mailboxes)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package interp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	message "github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+const (
+	// maxHeaderBytes limits the size of message and body part headers
+	// read by MessageReader.
+	maxHeaderBytes = 1 << 20
+
+	// maxPartNesting limits the depth of nested multipart and
+	// message/rfc822 entities processed by MessageReader.
+	maxPartNesting = 32
+
+	// maxPrologueBytes limits the amount of multipart prologue and
+	// epilogue returned as a body part.
+	maxPrologueBytes = 64 * 1024
+)
+
+var errHeaderTooBig = errors.New("header exceeds maximum size")
+
+// MessageReader is a Message and BodyMessage implementation that reads
+// the message from io.ReaderAt (e.g. *os.File).
+//
+// The header is parsed once by NewMessageReader, HeaderGet decodes RFC 2047
+// encoded words in field values. MIME parts are located by scanning for
+// boundaries when BodyParts is called and their contents are read and
+// decoded only when the part is opened, so attachments are never loaded
+// into memory as a whole.
+//
+// MessageReader is safe for concurrent use if the underlying
+// io.ReaderAt is.
+type MessageReader struct {
+	r      io.ReaderAt
+	size   int64
+	header textproto.Header
+
+	bodyOffset int64
+	hasBody    bool
+}
+
+// NewMessageReader parses the message header from r. size is the size
+// of the message in bytes.
+func NewMessageReader(r io.ReaderAt, size int64) (*MessageReader, error) {
+	hdr, bodyOffset, hasBody, err := readHeaderAt(r, 0, size)
+	if err != nil {
+		return nil, fmt.Errorf("NewMessageReader: %w", err)
+	}
+	return &MessageReader{
+		r:          r,
+		size:       size,
+		header:     hdr,
+		bodyOffset: bodyOffset,
+		hasBody:    hasBody,
+	}, nil
+}
+
+func (m *MessageReader) HeaderGet(key string) ([]string, error) {
+	values := m.header.Values(key)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	decoded := make([]string, len(values))
+	for i, v := range values {
+		decoded[i] = decodeHeaderField(key, v)
+	}
+	return decoded, nil
+}
+
+func (m *MessageReader) MessageSize() int {
+	return int(m.size)
+}
+
+func (m *MessageReader) BodyRaw(_ context.Context) (io.Reader, error) {
+	if !m.hasBody {
+		return nil, nil
+	}
+	return io.NewSectionReader(m.r, m.bodyOffset, m.size-m.bodyOffset), nil
+}
+
+func (m *MessageReader) BodyParts(ctx context.Context, contentTypes []string) ([]BodyPart, error) {
+	if !m.hasBody {
+		return nil, nil
+	}
+	var parts []BodyPart
+	err := m.walkParts(ctx, m.header, m.bodyOffset, m.size, contentTypes, 0, &parts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+func (m *MessageReader) Attachments(ctx context.Context) ([]Attachment, error) {
+	if !m.hasBody {
+		return nil, nil
+	}
+	var attachments []Attachment
+	err := m.walkParts(ctx, m.header, m.bodyOffset, m.size, nil, 0, nil, &attachments)
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// walkParts collects parts matching contentTypes into parts and, if
+// attachments is not nil, attachments of the message.
+func (m *MessageReader) walkParts(ctx context.Context, hdr textproto.Header, start, end int64,
+	contentTypes []string, depth int, parts *[]BodyPart, attachments *[]Attachment) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	msgHdr := message.Header{Header: hdr}
+	ct, params, _ := msgHdr.ContentType()
+	ct = strings.ToLower(ct)
+	if ct == "" {
+		// RFC 2045, Section 5.2
+		ct = "text/plain"
+	}
+
+	switch {
+	case strings.HasPrefix(ct, "multipart/") && params["boundary"] != "" && depth < maxPartNesting:
+		layout, err := scanMultipart(m.r, start, end, params["boundary"])
+		if err != nil {
+			return err
+		}
+		if ContentTypeMatches(ct, contentTypes) {
+			*parts = append(*parts, multipartTextPart{
+				contentType: ct,
+				prologue:    io.NewSectionReader(m.r, layout.prologue[0], layout.prologue[1]-layout.prologue[0]),
+				epilogue:    io.NewSectionReader(m.r, layout.epilogue[0], layout.epilogue[1]-layout.epilogue[0]),
+			})
+		}
+		for _, p := range layout.parts {
+			partHdr, bodyStart, _, err := readHeaderAt(m.r, p[0], p[1])
+			if err != nil {
+				continue // skip malformed parts
+			}
+			if err := m.walkParts(ctx, partHdr, bodyStart, p[1], contentTypes, depth+1, parts, attachments); err != nil {
+				return err
+			}
+		}
+	case (ct == "message/rfc822" || ct == "message/global") && depth < maxPartNesting:
+		nestedHdr, bodyStart, _, err := readHeaderAt(m.r, start, end)
+		if err != nil {
+			return nil
+		}
+		if filename, ok := attachmentFilename(hdr); ok && attachments != nil {
+			*attachments = append(*attachments, sectionAttachment{
+				sectionPart: sectionPart{
+					contentType: ct,
+					section:     io.NewSectionReader(m.r, start, end-start),
+				},
+				filename: filename,
+			})
+		}
+		if ContentTypeMatches(ct, contentTypes) {
+			*parts = append(*parts, sectionPart{
+				contentType: ct,
+				section:     io.NewSectionReader(m.r, start, headerFieldsEnd(m.r, start, bodyStart)-start),
+			})
+		}
+		return m.walkParts(ctx, nestedHdr, bodyStart, end, contentTypes, depth+1, parts, attachments)
+	default:
+		if filename, ok := attachmentFilename(hdr); ok && attachments != nil {
+			*attachments = append(*attachments, sectionAttachment{
+				sectionPart: sectionPart{
+					contentType: ct,
+					header:      hdr,
+					section:     io.NewSectionReader(m.r, start, end-start),
+					decode:      true,
+				},
+				filename: filename,
+			})
+		}
+		if end > start && ContentTypeMatches(ct, contentTypes) {
+			*parts = append(*parts, sectionPart{
+				contentType: ct,
+				header:      hdr,
+				section:     io.NewSectionReader(m.r, start, end-start),
+				decode:      true,
+			})
+		}
+	}
+	return nil
+}
+
+// sectionPart is a BodyPart stored in a section of the message.
+type sectionPart struct {
+	contentType string
+	header      textproto.Header
+	section     *io.SectionReader
+	// decode Content-Transfer-Encoding and charset of the part.
+	decode bool
+}
+
+func (p sectionPart) ContentType() string {
+	return p.contentType
+}
+
+func (p sectionPart) Open(_ context.Context) (io.ReadCloser, error) {
+	body := io.Reader(io.NewSectionReader(p.section, 0, p.section.Size()))
+	if !p.decode {
+		return io.NopCloser(body), nil
+	}
+	return io.NopCloser(decodeEntity(p.header, body, p.section.Size()*maxDecodedExpansion)), nil
+}
+
+// sectionAttachment is an Attachment stored in a section of the message.
+type sectionAttachment struct {
+	sectionPart
+	filename string
+}
+
+func (a sectionAttachment) Filename() string {
+	return a.filename
+}
+
+// multipartTextPart is the prologue and epilogue of a multipart
+// entity (RFC 5173, Section 5.2).
+type multipartTextPart struct {
+	contentType string
+	prologue    *io.SectionReader
+	epilogue    *io.SectionReader
+}
+
+func (p multipartTextPart) ContentType() string {
+	return p.contentType
+}
+
+func (p multipartTextPart) Open(_ context.Context) (io.ReadCloser, error) {
+	read := func(s *io.SectionReader) ([]byte, error) {
+		return io.ReadAll(io.NewSectionReader(s, 0, min64(s.Size(), maxPrologueBytes)))
+	}
+	prologue, err := read(p.prologue)
+	if err != nil {
+		return nil, err
+	}
+	epilogue, err := read(p.epilogue)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := make([]byte, 0, len(prologue)+len(epilogue)+2)
+	blob = append(blob, bytes.TrimRight(prologue, "\r\n")...)
+	blob = append(blob, '\r', '\n')
+	blob = append(blob, bytes.Trim(epilogue, "\r\n")...)
+	return io.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// readHeaderAt reads the header of the entity stored in [start, end).
+// It returns the offset of the body and whether the header is terminated
+// by an empty line.
+func readHeaderAt(r io.ReaderAt, start, end int64) (textproto.Header, int64, bool, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, start, end-start))
+	var raw []byte
+	offset := start
+	for {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return textproto.Header{}, 0, false, err
+		}
+		offset += int64(len(line))
+
+		if len(raw) == 0 || raw[len(raw)-1] == '\n' {
+			if bytes.Equal(line, []byte("\r\n")) || bytes.Equal(line, []byte("\n")) {
+				hdr, err := parseHeader(raw)
+				return hdr, offset, true, err
+			}
+		}
+		raw = append(raw, line...)
+		if len(raw) > maxHeaderBytes {
+			return textproto.Header{}, 0, false, errHeaderTooBig
+		}
+		if errors.Is(err, io.EOF) {
+			hdr, err := parseHeader(raw)
+			return hdr, offset, false, err
+		}
+	}
+}
+
+func parseHeader(raw []byte) (textproto.Header, error) {
+	// ReadHeader expects the terminating empty line.
+	raw = append(raw, '\r', '\n')
+	return textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+}
+
+// headerFieldsEnd returns the offset of the empty line that terminates
+// the header starting at start and ending at bodyStart.
+func headerFieldsEnd(r io.ReaderAt, start, bodyStart int64) int64 {
+	var buf [2]byte
+	if bodyStart-start >= 2 {
+		if _, err := r.ReadAt(buf[:], bodyStart-2); err == nil && buf == [2]byte{'\r', '\n'} {
+			return bodyStart - 2
+		}
+	}
+	if bodyStart-start >= 1 {
+		if _, err := r.ReadAt(buf[:1], bodyStart-1); err == nil && buf[0] == '\n' {
+			return bodyStart - 1
+		}
+	}
+	return bodyStart
+}
+
+// multipartLayout describes byte ranges of a multipart body.
+type multipartLayout struct {
+	prologue [2]int64
+	parts    [][2]int64
+	epilogue [2]int64
+}
+
+// scanMultipart finds the boundary delimiters in the multipart body stored
+// in [start, end) without buffering the body.
+func scanMultipart(r io.ReaderAt, start, end int64, boundary string) (*multipartLayout, error) {
+	dashBoundary := []byte("--" + boundary)
+	layout := &multipartLayout{
+		prologue: [2]int64{start, end},
+		epilogue: [2]int64{end, end},
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(r, start, end-start))
+	offset := start
+	atLineStart := true
+	// Length of the line break before the current line, it belongs
+	// to the following delimiter (RFC 2046, Section 5.1.1).
+	prevBreak := int64(0)
+	partStart := int64(-1)
+	for {
+		line, err := br.ReadSlice('\n')
+		full := !errors.Is(err, bufio.ErrBufferFull)
+		if !full {
+			err = nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		lineStart := offset
+		offset += int64(len(line))
+
+		if atLineStart && full && bytes.HasPrefix(line, dashBoundary) {
+			rest := bytes.TrimRight(line[len(dashBoundary):], " \t\r\n")
+			isClose := bytes.Equal(rest, []byte("--"))
+			if len(rest) == 0 || isClose {
+				delimStart := lineStart - prevBreak
+				if delimStart < start {
+					delimStart = start
+				}
+				if partStart < 0 {
+					layout.prologue[1] = delimStart
+				} else {
+					layout.parts = append(layout.parts, [2]int64{partStart, delimStart})
+				}
+				partStart = offset
+				if isClose {
+					layout.epilogue[0] = offset
+					return layout, nil
+				}
+			}
+		}
+
+		atLineStart = full
+		switch {
+		case bytes.HasSuffix(line, []byte("\r\n")):
+			prevBreak = 2
+		case bytes.HasSuffix(line, []byte("\n")):
+			prevBreak = 1
+		default:
+			prevBreak = 0
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	// No closing delimiter, the last part extends to the end.
+	if partStart >= 0 {
+		layout.parts = append(layout.parts, [2]int64{partStart, end})
+	}
+	return layout, nil
+}
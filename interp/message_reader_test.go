@@ -0,0 +1,219 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testMIMEMessage = "From: =?ISO-8859-1?Q?Andr=E9?= <andre@example.org>\r\n" +
+	"To: user@example.org\r\n" +
+	"Subject: =?UTF-8?B?0J/RgNC40LLQtdGC?= and\r\n" +
+	" =?ISO-8859-2?Q?=B3=F3d=BC?=\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"This is the prologue.\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=E9 ouvert\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Hello</p>\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: nested\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Nested body\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAECAwQF\r\n" +
+	"--outer--\r\n" +
+	"This is the epilogue.\r\n"
+
+func readParts(t *testing.T, parts []BodyPart) map[string][]string {
+	t.Helper()
+	res := make(map[string][]string)
+	for _, p := range parts {
+		rc, err := p.Open(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[p.ContentType()] = append(res[p.ContentType()], string(b))
+	}
+	return res
+}
+
+func TestMessageReaderHeader(t *testing.T) {
+	msg, err := NewMessageReader(strings.NewReader(testMIMEMessage), int64(len(testMIMEMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string][]string{
+		"from":    {"André <andre@example.org>"},
+		"Subject": {"Привет and łódź"},
+		"To":      {"user@example.org"},
+		"Cc":      nil,
+	} {
+		got, err := msg.HeaderGet(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("HeaderGet(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if msg.MessageSize() != len(testMIMEMessage) {
+		t.Errorf("wrong size: %d", msg.MessageSize())
+	}
+}
+
+func TestMessageReaderBodyParts(t *testing.T) {
+	msg, err := NewMessageReader(strings.NewReader(testMIMEMessage), int64(len(testMIMEMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := msg.BodyParts(context.Background(), []string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readParts(t, parts)
+	want := map[string][]string{
+		"multipart/mixed":          {"This is the prologue.\r\nThis is the epilogue."},
+		"text/plain":               {"Café ouvert", "Nested body"},
+		"text/html":                {"<p>Hello</p>"},
+		"message/rfc822":           {"Subject: nested\r\nContent-Type: text/plain\r\n"},
+		"application/octet-stream": {"\x00\x01\x02\x03\x04\x05"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected parts:\n got %q\nwant %q", got, want)
+	}
+
+	parts, err = msg.BodyParts(context.Background(), []string{"text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Errorf("expected 3 text parts, got %d", len(parts))
+	}
+
+	raw, err := msg.BodyRaw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawBody, err := io.ReadAll(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(rawBody), "This is the prologue.") {
+		t.Errorf("unexpected raw body: %q", rawBody)
+	}
+}
+
+func TestMessageReaderMatchesParseBodyParts(t *testing.T) {
+	src := testMIMEMessage
+	msg, err := NewMessageReader(strings.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	static := MessageStatic{RawMessage: []byte(src)}
+
+	for _, types := range [][]string{{""}, {"text"}, {"multipart"}, {"message/rfc822"}, {"image"}} {
+		parts, err := msg.BodyParts(context.Background(), types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		staticParts, err := static.BodyParts(context.Background(), types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := readParts(t, parts), readParts(t, staticParts); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: parts differ:\n got %q\nwant %q", types, got, want)
+		}
+	}
+}
+
+func TestMessageReaderNoBody(t *testing.T) {
+	src := "Subject: test\r\n"
+	msg, err := NewMessageReader(strings.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := msg.BodyRaw(context.Background())
+	if err != nil || raw != nil {
+		t.Errorf("expected no body, got %v, %v", raw, err)
+	}
+	parts, err := msg.BodyParts(context.Background(), []string{""})
+	if err != nil || len(parts) != 0 {
+		t.Errorf("expected no parts, got %v, %v", parts, err)
+	}
+	if subj, _ := msg.HeaderGet("Subject"); !reflect.DeepEqual(subj, []string{"test"}) {
+		t.Errorf("unexpected subject: %q", subj)
+	}
+}
+
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func TestMessageReaderLazyParts(t *testing.T) {
+	attachment := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xAA}, 1<<20))
+	src := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		attachment + "\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"short text\r\n" +
+		"--b--\r\n"
+	r := &countingReaderAt{r: strings.NewReader(src)}
+
+	msg, err := NewMessageReader(r, int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := msg.BodyParts(context.Background(), []string{"text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("expected 1 part, got %d", len(parts))
+	}
+
+	before := r.read.Load()
+	if got := readParts(t, parts); got["text/plain"][0] != "short text" {
+		t.Errorf("unexpected part: %q", got)
+	}
+	if n := r.read.Load() - before; n > 64 {
+		t.Errorf("opening the text part read %d bytes", n)
+	}
+}
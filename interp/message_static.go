@@ -70,11 +70,28 @@ func (m MessageStatic) BodyRaw(ctx context.Context) (io.Reader, error) {
 	return ParseBodyRaw(ctx, bytes.NewReader(m.RawMessage))
 }
 
+// BodyParts locates MIME parts in RawMessage using MessageReader, the parts
+// are decoded only while they are read by the matcher.
 func (m MessageStatic) BodyParts(ctx context.Context, contentTypes []string) ([]BodyPart, error) {
 	if m.RawMessage == nil {
 		return nil, nil
 	}
-	return ParseBodyParts(ctx, bytes.NewReader(m.RawMessage), contentTypes, len(m.RawMessage))
+	msg, err := NewMessageReader(bytes.NewReader(m.RawMessage), int64(len(m.RawMessage)))
+	if err != nil {
+		return nil, err
+	}
+	return msg.BodyParts(ctx, contentTypes)
+}
+
+func (m MessageStatic) Attachments(ctx context.Context) ([]Attachment, error) {
+	if m.RawMessage == nil {
+		return nil, nil
+	}
+	msg, err := NewMessageReader(bytes.NewReader(m.RawMessage), int64(len(m.RawMessage)))
+	if err != nil {
+		return nil, err
+	}
+	return msg.Attachments(ctx)
 }
 
 // MapEnv is a simple Env implementation backed by a map.
@@ -29,6 +29,26 @@ func (r Relational) CompareString(lhs, rhs string) bool {
 	return false
 }
 
+// compareResult checks the relation using the result of
+// Collation.Compare.
+func (r Relational) compareResult(cmp int) bool {
+	switch r {
+	case RelGreaterThan:
+		return cmp > 0
+	case RelGreaterOrEqual:
+		return cmp >= 0
+	case RelLessThan:
+		return cmp < 0
+	case RelLessOrEqual:
+		return cmp <= 0
+	case RelEqual:
+		return cmp == 0
+	case RelNotEqual:
+		return cmp != 0
+	}
+	return false
+}
+
 func (r Relational) CompareUint64(lhs, rhs uint64) bool {
 	switch r {
 	case RelGreaterThan:
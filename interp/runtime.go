@@ -45,6 +45,9 @@ type Message interface {
 		      syntax) or processed according to local conventions.  An encoded
 		      NUL octet (character zero) SHOULD NOT cause early termination of
 		      the header content being compared against.
+
+		MessageReader does this conversion, other implementations returning
+		raw values can be wrapped using DecodedMessage.
 	*/
 	HeaderGet(key string) ([]string, error)
 	MessageSize() int
@@ -126,7 +129,8 @@ type BodyMessage interface {
 	//
 	// Since Sieve operates on UTF-8 or 7-bit ASCII strings, the returned readers
 	// should provide decoded content as UTF-8, performing necessary decoding
-	// if a non-Unicode charset is specified in Content-Type.
+	// if a non-Unicode charset is specified in Content-Type. ParseBodyParts
+	// and MessageReader do this using charsets registered by RegisterCharset.
 	BodyParts(ctx context.Context, contentTypes []string) ([]BodyPart, error)
 }
 
@@ -169,6 +173,8 @@ type TestRuntime struct {
 	OriginalFlags    Flags
 }
 
+// RuntimeData is the state of a single script execution. It must not
+// be used by multiple executions or goroutines at once.
 type RuntimeData struct {
 	Policy   PolicyReader
 	Envelope Envelope
@@ -198,6 +204,20 @@ type RuntimeData struct {
 	MatchVariables []string
 	Variables      map[string]string
 
+	// Tracer, if set, receives information about executed commands and
+	// evaluated tests. See TraceRecorder.
+	Tracer     Tracer
+	traceStack []*traceFrame
+	traceDepth int
+
+	// Resources used so far, checked against execution budgets
+	// set in Options.
+	budget budget
+
+	// Options changed for this execution only (by test_config_set),
+	// nil if Script options are used.
+	opts *Options
+
 	// vnd.dovecot.testsuite state, not intended for production use
 	Test *TestRuntime
 }
@@ -219,6 +239,7 @@ func (d *RuntimeData) Copy() *RuntimeData {
 		FlagAliases:    make(map[string]string, len(d.FlagAliases)),
 		MatchVariables: make([]string, len(d.MatchVariables)),
 		Variables:      make(map[string]string, len(d.Variables)),
+		Tracer:         d.Tracer,
 		Test:           d.Test,
 	}
 
@@ -226,9 +247,13 @@ func (d *RuntimeData) Copy() *RuntimeData {
 	copy(newData.RedirectAddr, d.RedirectAddr)
 	copy(newData.Mailboxes, d.Mailboxes)
 	if d.Flags != nil {
-		d.Flags = make([]string, len(d.Flags))
+		newData.Flags = make([]string, len(d.Flags))
 		copy(newData.Flags, d.Flags)
 	}
+	if d.opts != nil {
+		opts := *d.opts
+		newData.opts = &opts
+	}
 	copy(newData.MatchVariables, d.MatchVariables)
 
 	for k, v := range d.FlagAliases {
@@ -241,6 +266,27 @@ func (d *RuntimeData) Copy() *RuntimeData {
 	return newData
 }
 
+// options returns the options in effect for this execution.
+func (d *RuntimeData) options() *Options {
+	if d.opts != nil {
+		return d.opts
+	}
+	if d.Script == nil || d.Script.opts == nil {
+		return &Options{}
+	}
+	return d.Script.opts
+}
+
+// overrideOptions returns the options that can be changed for the rest
+// of this execution without affecting the Script.
+func (d *RuntimeData) overrideOptions() *Options {
+	if d.opts == nil {
+		opts := *d.options()
+		d.opts = &opts
+	}
+	return d.opts
+}
+
 func (d *RuntimeData) MatchVariable(i int) string {
 	if i >= len(d.MatchVariables) {
 		return ""
@@ -281,11 +327,11 @@ func (d *RuntimeData) Var(name string) (string, error) {
 }
 
 func (d *RuntimeData) SetVar(name, value string) error {
-	if len(name) > d.Script.opts.MaxVariableNameLen {
+	if len(name) > d.options().MaxVariableNameLen {
 		return fmt.Errorf("attempting to use a too long variable name: %v", name)
 	}
-	if len(value) > d.Script.opts.MaxVariableLen {
-		until := d.Script.opts.MaxVariableLen
+	if len(value) > d.options().MaxVariableLen {
+		until := d.options().MaxVariableLen
 		// If this truncated an otherwise valid Unicode character,
 		// remove the character altogether.
 		for until > 0 && value[until] >= 128 && value[until] < 192 /* second or further octet of UTF-8 encoding */ {
@@ -307,6 +353,9 @@ func (d *RuntimeData) SetVar(name, value string) error {
 		return fmt.Errorf("cannot modify envelope. variables")
 	case "":
 		// User variables.
+		if err := d.chargeVariable(len(value)); err != nil {
+			return err
+		}
 		d.Variables[name] = value
 		return nil
 	default:
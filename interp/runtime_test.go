@@ -0,0 +1,65 @@
+package interp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRuntimeDataCopy(t *testing.T) {
+	d := NewRuntimeData(&Script{opts: &Options{}}, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+	d.Flags = []string{"\\Seen"}
+	d.Variables["a"] = "1"
+	d.MatchVariables = []string{"x"}
+
+	c := d.Copy()
+	if !reflect.DeepEqual(c.Flags, d.Flags) {
+		t.Fatalf("flags not copied: %v", c.Flags)
+	}
+
+	c.Flags[0] = "\\Deleted"
+	c.Variables["a"] = "2"
+	c.MatchVariables[0] = "y"
+	if d.Flags[0] != "\\Seen" || d.Variables["a"] != "1" || d.MatchVariables[0] != "x" {
+		t.Errorf("changing copy modified the source: %v %v %v", d.Flags, d.Variables, d.MatchVariables)
+	}
+}
+
+func TestConfigSetDoesNotModifyScript(t *testing.T) {
+	script := &Script{opts: &Options{MaxVariableLen: 4000, SubAddressSep: "+"}}
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+
+	cmds := []Cmd{
+		CmdDovecotConfigSet{Key: "recipient_delimiter", Value: "-"},
+		CmdDovecotConfigSet{Key: "sieve_variables_max_variable_size", Value: "10"},
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Execute(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d.options().SubAddressSep != "-" || d.options().MaxVariableLen != 10 {
+		t.Errorf("options are not changed for the execution: %+v", d.options())
+	}
+	if script.opts.SubAddressSep != "+" || script.opts.MaxVariableLen != 4000 {
+		t.Errorf("script options are modified: %+v", script.opts)
+	}
+
+	other := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{})
+	if other.options().SubAddressSep != "+" {
+		t.Error("options change is visible to other executions")
+	}
+}
+
+func TestLoadScriptCopiesOptions(t *testing.T) {
+	opts := &Options{MaxRedirects: 5, MaxVariableNameLen: 32, MaxVariableLen: 4000}
+	script, err := LoadScript(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.MaxRedirects = 0
+	if script.opts.MaxRedirects != 5 {
+		t.Error("changing options after load modified the script")
+	}
+}
@@ -20,26 +20,110 @@ type Options struct {
 	MaxVariableNameLen int
 	MaxVariableLen     int
 
+	// Execution budgets, zero value means no limit. If any of them is
+	// exceeded, Script.Execute returns BudgetError.
+	//
+	// MaxCommands limits the number of executed commands.
+	// MaxTests limits the number of evaluated tests.
+	// MaxScanBytes limits the total size of header values, addresses and
+	// body parts compared with keys.
+	// MaxVariableBytes limits the total size of values assigned to variables
+	// (including match variables).
+	MaxCommands      int
+	MaxTests         int
+	MaxScanBytes     int
+	MaxVariableBytes int
+
+	// Limits of body tests (RFC 5173), zero value means no limit.
+	//
+	// MaxBodyPartBytes limits the number of decoded bytes of each body
+	// part compared with keys. MaxBodyBytes limits the total number of
+	// bytes read from all body parts during a single evaluation of a body
+	// test. Each part is compared with all keys, but its bytes are counted
+	// once.
+	//
+	// Body parts are streamed to the matcher and the scan stops silently
+	// when a limit is reached: the part is matched as if it ended at the
+	// limit and the remaining parts are not matched at all. As a result,
+	// :contains and :matches can only find keys in the scanned prefix,
+	// while :is never matches a truncated part. Unlike MaxScanBytes, these
+	// limits do not make the script fail.
+	MaxBodyPartBytes int
+	MaxBodyBytes     int
+
 	// SubAddressSep is the set of separator characters for subaddress parsing
 	// (RFC 5233). Each character in the string is treated as a separator.
 	// Defaults to "+" if empty.
 	SubAddressSep string
 
+	// ASCIIAddresses disables support for internationalized addresses
+	// (RFC 6532). If set, addresses with non-ASCII characters are
+	// treated as invalid by address tests and rejected by redirect.
+	ASCIIAddresses bool
+	// IDNDomains selects the form domain names are converted to before
+	// they are compared using :domain or :all, see IDNForm.
+	IDNDomains IDNForm
+
+	// CompileMatcher, if set, is used instead of CompileMatcherRegex to
+	// compile :matches keys at load time. It allows sharing compiled
+	// matchers between scripts, see package cache. CompiledMatcher is
+	// safe for concurrent use.
+	CompileMatcher func(regex string, octet bool) (CompiledMatcher, error)
+
 	// If specified - enables vnd.dovecot.testsuite extension
 	// and will execute tests.
 	T             *testing.T
 	DisabledTests []string
 }
 
+// Script is a loaded Sieve script.
+//
+// Script is never modified after it is loaded or restored, so a single
+// Script can be executed concurrently from multiple goroutines. Each
+// execution needs its own RuntimeData.
 type Script struct {
 	extensions map[string]struct{}
 	cmd        []Cmd
 
 	opts *Options
+
+	// Set only while the script is being loaded in error-recovering mode.
+	loadErrs  *lexer.ErrorList
+	maxErrors int
 }
 
 var ErrStop = errors.New("interpreter: stop called")
 
+// RuntimeError is returned by Script.Execute if a command or a test fails.
+// Position is the location of the failed command or test in the script.
+type RuntimeError struct {
+	Position lexer.Position
+	Err      error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Position == (lexer.Position{}) {
+		return e.Err.Error()
+	}
+	return e.Position.String() + ": " + e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func runtimeError(node interface{}, err error) error {
+	if err == nil || errors.Is(err, ErrStop) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var rtErr *RuntimeError
+	if errors.As(err, &rtErr) {
+		return err
+	}
+	return &RuntimeError{Position: NodePosition(node), Err: err}
+}
+
 func (s Script) Extensions() []string {
 	exts := make([]string, 0, len(s.extensions))
 	for ext := range s.extensions {
@@ -53,6 +137,12 @@ func (s Script) RequiresExtension(name string) bool {
 	return ok
 }
 
+// Cmds returns the top-level commands of the loaded script.
+// Returned slice must not be modified.
+func (s Script) Cmds() []Cmd {
+	return s.cmd
+}
+
 func (s Script) IsVarUsable(variableName string) (settable, gettable bool) {
 	if len(variableName) > s.opts.MaxVariableNameLen {
 		return false, false
@@ -81,31 +171,59 @@ func (s Script) IsVarUsable(variableName string) (settable, gettable bool) {
 	}
 }
 
+// Execute runs the script against d and requests the implicit keep
+// if no executed action canceled it.
 func (s Script) Execute(ctx context.Context, d *RuntimeData) error {
+	if err := s.run(ctx, d); err != nil {
+		return err
+	}
+	if implicitKeep(d, d.AppliedActions) {
+		return applyImplicitKeep(ctx, d)
+	}
+	return nil
+}
+
+// run executes the commands of the script. stop ends the execution
+// successfully.
+func (s Script) run(ctx context.Context, d *RuntimeData) error {
 	for _, c := range s.cmd {
-		if err := c.Execute(ctx, d); err != nil {
+		if err := executeCmd(ctx, d, c); err != nil {
 			if errors.Is(err, ErrStop) {
-				return nil
+				break
 			}
 			return err
 		}
 	}
+	return ctx.Err()
+}
 
-	implicitKeep := d.ImplicitKeep
-	for _, act := range d.AppliedActions {
+// implicitKeep reports whether the implicit keep is still in effect
+// after actions.
+func implicitKeep(d *RuntimeData, actions []AppliedAction) bool {
+	if !d.ImplicitKeep {
+		return false
+	}
+	for _, act := range actions {
 		if act.cancelsImplicitKeep() {
-			implicitKeep = false
+			return false
 		}
 	}
+	return true
+}
 
-	if implicitKeep {
-		if err := d.OnAction(ctx, ActionKeep{
-			Implicit: true,
-			Flags:    d.Flags,
-		}, d); err != nil {
-			return err
-		}
+func applyImplicitKeep(ctx context.Context, d *RuntimeData) error {
+	if err := d.OnAction(ctx, ActionKeep{
+		Implicit: true,
+		Flags:    d.Flags,
+	}, d); err != nil {
+		return err
+	}
+	if d.Tracer != nil {
+		d.Tracer.Trace(TraceEntry{
+			Kind:    TraceImplicitKeep,
+			Result:  true,
+			Actions: []int{len(d.AppliedActions) - 1},
+		})
 	}
-
 	return nil
 }
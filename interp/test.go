@@ -2,11 +2,10 @@ package interp
 
 import (
 	"context"
-	"encoding/gob"
 	"fmt"
 	"strings"
 
-	"github.com/emersion/go-message/mail"
+	"github.com/foxcpp/go-sieve/lexer"
 )
 
 type Test interface {
@@ -14,6 +13,7 @@ type Test interface {
 }
 
 type AddressTest struct {
+	lexer.Position
 	matcherTest
 
 	AddressPart AddressPart
@@ -74,18 +74,13 @@ func (a AddressTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 		}
 
 		for _, value := range values {
-			addrList, err := mail.ParseAddressList(value)
-			if err != nil {
-				return false, nil
-			}
-
-			for _, addr := range addrList {
+			for _, addr := range parseAddressList(value) {
 				if a.isCount() {
 					entryCount++
 					continue
 				}
 
-				ok, err := testAddress(d, a.matcherTest, a.AddressPart, addr.Address)
+				ok, err := testAddress(d, a.matcherTest, a.AddressPart, addr)
 				if err != nil {
 					return false, err
 				}
@@ -104,12 +99,13 @@ func (a AddressTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 }
 
 type AllOfTest struct {
+	lexer.Position
 	Tests []Test
 }
 
 func (a AllOfTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 	for _, t := range a.Tests {
-		ok, err := t.Check(ctx, d)
+		ok, err := checkTest(ctx, d, t)
 		if err != nil {
 			return false, err
 		}
@@ -121,12 +117,13 @@ func (a AllOfTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 }
 
 type AnyOfTest struct {
+	lexer.Position
 	Tests []Test
 }
 
 func (a AnyOfTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 	for _, t := range a.Tests {
-		ok, err := t.Check(ctx, d)
+		ok, err := checkTest(ctx, d, t)
 		if err != nil {
 			return false, err
 		}
@@ -138,6 +135,7 @@ func (a AnyOfTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 }
 
 type EnvelopeTest struct {
+	lexer.Position
 	matcherTest
 
 	AddressPart AddressPart
@@ -165,7 +163,7 @@ func (e EnvelopeTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 			continue
 		}
 
-		ok, err := testAddress(d, e.matcherTest, e.AddressPart, value)
+		ok, err := testAddress(d, e.matcherTest, e.AddressPart, parseEnvelopePath(value))
 		if err != nil {
 			return false, err
 		}
@@ -180,6 +178,7 @@ func (e EnvelopeTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 }
 
 type ExistsTest struct {
+	lexer.Position
 	Fields []string
 }
 
@@ -196,19 +195,24 @@ func (e ExistsTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 	return true, nil
 }
 
-type FalseTest struct{}
+type FalseTest struct {
+	lexer.Position
+}
 
 func (f FalseTest) Check(context.Context, *RuntimeData) (bool, error) {
 	return false, nil
 }
 
-type TrueTest struct{}
+type TrueTest struct {
+	lexer.Position
+}
 
 func (t TrueTest) Check(context.Context, *RuntimeData) (bool, error) {
 	return true, nil
 }
 
 type HeaderTest struct {
+	lexer.Position
 	matcherTest
 
 	Header []string
@@ -246,11 +250,12 @@ func (h HeaderTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 }
 
 type NotTest struct {
+	lexer.Position
 	Test Test
 }
 
 func (n NotTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
-	ok, err := n.Test.Check(ctx, d)
+	ok, err := checkTest(ctx, d, n.Test)
 	if err != nil {
 		return false, err
 	}
@@ -258,6 +263,7 @@ func (n NotTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 }
 
 type SizeTest struct {
+	lexer.Position
 	Size  int
 	Over  bool
 	Under bool
@@ -276,6 +282,7 @@ func (s SizeTest) Check(_ context.Context, d *RuntimeData) (bool, error) {
 // EnvironmentTest implements the Sieve environment test (RFC 5183).
 // It checks the value of a named environment item against a key list.
 type EnvironmentTest struct {
+	lexer.Position
 	matcherTest
 	Name []string // The environment item name(s) to test
 }
@@ -329,6 +336,7 @@ func (e EnvironmentTest) Check(_ context.Context, d *RuntimeData) (bool, error)
 }
 
 type HasFlagTest struct {
+	lexer.Position
 	matcherTest
 	Variables []string
 }
@@ -394,6 +402,7 @@ const (
 
 // BodyTest implements the body test from RFC 5173.
 type BodyTest struct {
+	lexer.Position
 	matcherTest
 
 	// Transform is the body transform: raw, text, or content.
@@ -421,8 +430,8 @@ func (b BodyTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 		// RFC 5173 §5.3: :text is implementation's best effort at extracting
 		// UTF-8 text. Simple implementations MAY treat it as :content "text".
 		// Sophisticated ones MAY strip markup.
-		// We use :content "text" with HTML stripping applied to all parts.
-		// Applying stripHTMLTags to plain text is a no-op, so this is safe.
+		// We use :content "text" and extract the text of HTML parts,
+		// see htmlTextReader.
 		parts, err = bm.BodyParts(ctx, []string{"text", "application/xhtml+xml"})
 	}
 	if err != nil {
@@ -440,6 +449,7 @@ func (b BodyTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 		return b.countMatches(d, uint64(len(parts))), nil
 	}
 
+	scan := newBodyScan(d)
 	for _, part := range parts {
 		stripHTML := false
 		ct := strings.ToLower(part.ContentType())
@@ -448,7 +458,7 @@ func (b BodyTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 			stripHTML = true
 		}
 
-		ok, err := b.matcherTest.tryMatchBodyPart(ctx, d, part, stripHTML)
+		ok, err := b.matcherTest.tryMatchBodyPart(ctx, d, part, stripHTML, scan)
 		if err != nil {
 			return false, err
 		}
@@ -459,18 +469,3 @@ func (b BodyTest) Check(ctx context.Context, d *RuntimeData) (bool, error) {
 
 	return false, nil
 }
-
-func init() {
-	gob.Register(AddressTest{})
-	gob.Register(AllOfTest{})
-	gob.Register(AnyOfTest{})
-	gob.Register(BodyTest{})
-	gob.Register(EnvelopeTest{})
-	gob.Register(EnvironmentTest{})
-	gob.Register(ExistsTest{})
-	gob.Register(FalseTest{})
-	gob.Register(TrueTest{})
-	gob.Register(HeaderTest{})
-	gob.Register(NotTest{})
-	gob.Register(SizeTest{})
-}
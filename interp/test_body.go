@@ -39,29 +39,33 @@ func (r asciiLowerByteReader) Read(p []byte) (int, error) {
 }
 
 func testReader(comparator Comparator, match Match, valueReader io.Reader, key string) (bool, error) {
-	if comparator == ComparatorASCIINumeric {
-		switch match {
-		case MatchContains, MatchMatches:
-			return false, ErrComparatorMatchUnsupported
-		case MatchIs:
-			lhsNum, err := numericValueReader(valueReader)
-			if err != nil {
-				return false, err
-			}
-			rhsNum := numericValue(key)
-			return RelEqual.CompareNumericValue(lhsNum, rhsNum), nil
-		case MatchValue:
-			panic("testReader does not support relational matching")
-		case MatchCount:
-			panic("testReader should not be used with MatchCount")
-		}
+	switch match {
+	case MatchValue:
+		panic("testReader does not support relational matching")
+	case MatchCount:
+		panic("testReader should not be used with MatchCount")
 	}
 
-	var (
-		regex    string
-		octet    = comparator.IsOctet()
-		caseFold = comparator.IsCaseMap()
-	)
+	c, err := lookupCollation(comparator)
+	if err != nil {
+		return false, err
+	}
+	if rc, ok := c.(ReaderCollation); ok {
+		return rc.MatchReader(match, valueReader, key)
+	}
+
+	value, err := io.ReadAll(valueReader)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := testString(comparator, match, "", string(value), key)
+	return ok, err
+}
+
+// matchRegexReader implements ReaderCollation.MatchReader for built-in
+// collations by converting the key into a regular expression.
+func matchRegexReader(match Match, valueReader io.Reader, key string, octet, caseFold bool) (bool, error) {
+	var regex string
 	switch match {
 	case MatchContains:
 		regex = regexp.QuoteMeta(key)
@@ -69,10 +73,8 @@ func testReader(comparator Comparator, match Match, valueReader io.Reader, key s
 		regex = "^" + regexp.QuoteMeta(key) + "$"
 	case MatchMatches:
 		regex = patternToRegex(key, caseFold)
-	case MatchValue:
-		panic("testReader does not support relational matching")
-	case MatchCount:
-		panic("testReader should not be used with MatchCount")
+	default:
+		return false, ErrComparatorMatchUnsupported
 	}
 
 	if caseFold {
@@ -89,7 +91,7 @@ func testReader(comparator Comparator, match Match, valueReader io.Reader, key s
 		}
 	}
 
-	matcher, err := compileMatcherRegex(regex, octet)
+	matcher, err := CompileMatcherRegex(regex, octet)
 	if err != nil {
 		return false, err
 	}
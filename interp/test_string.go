@@ -1,7 +1,6 @@
 package interp
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -48,26 +47,6 @@ const (
 	Detail AddressPart = "detail"
 )
 
-func split(addr string) (mailbox, domain string, err error) {
-	if strings.EqualFold(addr, "postmaster") {
-		return addr, "", nil
-	}
-
-	indx := strings.LastIndexByte(addr, '@')
-	if indx == -1 {
-		return "", "", errors.New("address: missing at-sign")
-	}
-	mailbox = addr[:indx]
-	domain = addr[indx+1:]
-	if mailbox == "" {
-		return "", "", errors.New("address: empty local-part")
-	}
-	if domain == "" {
-		return "", "", errors.New("address: empty domain")
-	}
-	return
-}
-
 var ErrComparatorMatchUnsupported = fmt.Errorf("match-comparator combination not supported")
 
 func numericValue(s string) *uint64 {
@@ -98,73 +77,27 @@ func numericValue(s string) *uint64 {
 }
 
 func testString(comparator Comparator, match Match, rel Relational, value, key string) (bool, []string, error) {
-	switch comparator {
-	case ComparatorOctet:
-		switch match {
-		case MatchContains:
-			return strings.Contains(value, key), nil, nil
-		case MatchIs:
-			return value == key, nil, nil
-		case MatchMatches:
-			return matchOctet(key, value, false)
-		case MatchValue:
-			return rel.CompareString(value, key), nil, nil
-		case MatchCount:
-			panic("testString should not be used with MatchCount")
-		}
-	case ComparatorASCIINumeric:
-		switch match {
-		case MatchContains:
-			return false, nil, ErrComparatorMatchUnsupported
-		case MatchIs:
-			lhsNum := numericValue(value)
-			rhsNum := numericValue(key)
-			return RelEqual.CompareNumericValue(lhsNum, rhsNum), nil, nil
-		case MatchMatches:
-			return false, nil, ErrComparatorMatchUnsupported
-		case MatchValue:
-			lhsNum := numericValue(value)
-			rhsNum := numericValue(key)
-			return rel.CompareNumericValue(lhsNum, rhsNum), nil, nil
-		case MatchCount:
-			panic("testString should not be used with MatchCount")
-		}
-	case ComparatorASCIICaseMap:
-		switch match {
-		case MatchContains:
-			value = toLowerASCII(value)
-			key = toLowerASCII(key)
-			return strings.Contains(value, key), nil, nil
-		case MatchIs:
-			value = toLowerASCII(value)
-			key = toLowerASCII(key)
-			return value == key, nil, nil
-		case MatchMatches:
-			return matchOctet(key, value, true)
-		case MatchValue:
-			value = toLowerASCII(value)
-			key = toLowerASCII(key)
-			return rel.CompareString(value, key), nil, nil
-		case MatchCount:
-			panic("testString should not be used with MatchCount")
-		}
-	case ComparatorUnicodeCaseMap:
-		switch match {
-		case MatchContains:
-			value = strings.ToLower(value)
-			key = strings.ToLower(key)
-			return strings.Contains(value, key), nil, nil
-		case MatchIs:
-			return strings.EqualFold(value, key), nil, nil
-		case MatchMatches:
-			return matchUnicode(key, value, true)
-		case MatchValue:
-			value = toLowerASCII(value)
-			key = toLowerASCII(key)
-			return rel.CompareString(value, key), nil, nil
-		case MatchCount:
-			panic("testString should not be used with MatchCount")
+	c, err := lookupCollation(comparator)
+	if err != nil {
+		return false, nil, err
+	}
+	switch match {
+	case MatchContains:
+		ok, err := c.Contains(value, key)
+		return ok, nil, err
+	case MatchIs:
+		ok, err := c.Equal(value, key)
+		return ok, nil, err
+	case MatchMatches:
+		return c.Match(value, key)
+	case MatchValue:
+		cmp, err := c.Compare(value, key)
+		if err != nil {
+			return false, nil, err
 		}
+		return rel.compareResult(cmp), nil, nil
+	case MatchCount:
+		panic("testString should not be used with MatchCount")
 	}
 	return false, nil, nil
 }
@@ -184,59 +117,43 @@ func splitSubAddress(localPart, sep string) (user, detail string, hasDetail bool
 	return localPart[:idx], localPart[idx+1:], true
 }
 
-func testAddress(d *RuntimeData, matcher matcherTest, part AddressPart, address string) (bool, error) {
-	if address == "<>" {
-		address = ""
-	}
+// testAddress matches the part of the address against the keys. Invalid
+// addresses can be matched only using :all, as the raw string (RFC 5228
+// Section 5.1).
+func testAddress(d *RuntimeData, matcher matcherTest, part AddressPart, addr mailAddress) (bool, error) {
+	addr = applyAddressOptions(d.options(), addr)
 
 	var valueToCompare string
-	hasValue := true
-	if address != "" {
+	switch {
+	case !addr.valid:
+		if part != All {
+			return false, nil
+		}
+		valueToCompare = addr.raw
+	case addr.localPart == "" && addr.domain == "":
+		// Null envelope address - there is no detail.
+		if part == Detail {
+			return false, nil
+		}
+	default:
 		switch part {
 		case LocalPart:
-			localPart, _, err := split(address)
-			if err != nil {
-				return false, nil
-			}
-			valueToCompare = localPart
+			valueToCompare = addr.localPart
 		case Domain:
-			_, domain, err := split(address)
-			if err != nil {
-				return false, nil
-			}
-			valueToCompare = domain
+			valueToCompare = addr.domain
 		case All:
-			valueToCompare = address
+			valueToCompare = addr.String()
 		case User:
-			localPart, _, err := split(address)
-			if err != nil {
-				return false, nil
-			}
-			sep := d.Script.opts.SubAddressSep
-			user, _, _ := splitSubAddress(localPart, sep)
+			user, _, _ := splitSubAddress(addr.localPart, d.options().SubAddressSep)
 			valueToCompare = user
 		case Detail:
-			localPart, _, err := split(address)
-			if err != nil {
-				return false, nil
-			}
-			sep := d.Script.opts.SubAddressSep
-			_, detail, hasDetail := splitSubAddress(localPart, sep)
+			_, detail, hasDetail := splitSubAddress(addr.localPart, d.options().SubAddressSep)
 			if !hasDetail {
 				// RFC 5233: if no detail, ":detail" fails to match any key
 				return false, nil
 			}
 			valueToCompare = detail
 		}
-	} else {
-		// Empty address - for :detail we should still return false (no detail)
-		if part == Detail {
-			hasValue = false
-		}
-	}
-
-	if !hasValue {
-		return false, nil
 	}
 
 	ok, err := matcher.tryMatch(d, valueToCompare)
@@ -0,0 +1,338 @@
+package interp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/foxcpp/go-sieve/lexer"
+)
+
+type TraceKind int
+
+const (
+	// TraceCommand is recorded after a command is executed. For 'if', 'elsif'
+	// and 'else' it is recorded before the block is executed and Result
+	// indicates whether the block is executed.
+	TraceCommand TraceKind = iota
+	// TraceTest is recorded after a test is evaluated.
+	TraceTest
+	// TraceImplicitKeep is recorded when the implicit keep is applied
+	// at the end of the script.
+	TraceImplicitKeep
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceCommand:
+		return "command"
+	case TraceTest:
+		return "test"
+	case TraceImplicitKeep:
+		return "implicit keep"
+	}
+	return "unknown"
+}
+
+type TraceVariable struct {
+	Name  string
+	Value string
+}
+
+type TraceEntry struct {
+	Kind     TraceKind
+	Position lexer.Position
+	// Name of the command or test as used in scripts (e.g. "fileinto"),
+	// empty if it is not known.
+	Name string
+	// Nesting level of the command or test.
+	Depth int
+
+	// Executed command or evaluated test.
+	Cmd  Cmd
+	Test Test
+
+	Result bool
+	// Values from the message or envelope (header values, addresses, ...)
+	// the test compared with its keys.
+	Compared []string
+	// Variables expanded while executing the command or evaluating the test.
+	Variables []TraceVariable
+	// Indexes of actions in RuntimeData.AppliedActions produced by the command.
+	Actions []int
+}
+
+// Tracer receives information about each executed command and evaluated test.
+//
+// Set RuntimeData.Tracer to enable tracing, the overhead is negligible
+// if Tracer is nil.
+type Tracer interface {
+	Trace(e TraceEntry)
+}
+
+// TraceRecorder is the Tracer implementation that simply stores all entries.
+type TraceRecorder struct {
+	Entries []TraceEntry
+}
+
+func (r *TraceRecorder) Trace(e TraceEntry) {
+	r.Entries = append(r.Entries, e)
+}
+
+// ActionSource returns the position of the command that produced
+// RuntimeData.AppliedActions[i]. Zero position is returned for the implicit
+// keep.
+func (r *TraceRecorder) ActionSource(i int) (lexer.Position, bool) {
+	for _, e := range r.Entries {
+		for _, act := range e.Actions {
+			if act == i {
+				return e.Position, true
+			}
+		}
+	}
+	return lexer.Position{}, false
+}
+
+// traceFrame collects information about the command or test
+// that is being executed.
+type traceFrame struct {
+	compared  []string
+	variables []TraceVariable
+}
+
+func (d *RuntimeData) tracePush() {
+	d.traceStack = append(d.traceStack, &traceFrame{})
+}
+
+func (d *RuntimeData) tracePop() *traceFrame {
+	f := d.traceStack[len(d.traceStack)-1]
+	d.traceStack = d.traceStack[:len(d.traceStack)-1]
+	return f
+}
+
+func (d *RuntimeData) traceCompared(value string) {
+	if len(d.traceStack) == 0 {
+		return
+	}
+	f := d.traceStack[len(d.traceStack)-1]
+	f.compared = append(f.compared, value)
+}
+
+func (d *RuntimeData) traceVariable(name, value string) {
+	if len(d.traceStack) == 0 {
+		return
+	}
+	f := d.traceStack[len(d.traceStack)-1]
+	for _, v := range f.variables {
+		if v.Name == name {
+			return
+		}
+	}
+	f.variables = append(f.variables, TraceVariable{Name: name, Value: value})
+}
+
+// NodePosition returns the script position of a loaded command or test,
+// zero Position if it is not known.
+func NodePosition(n interface{}) lexer.Position {
+	if p, ok := n.(interface{ Pos() lexer.Position }); ok {
+		return p.Pos()
+	}
+	return lexer.Position{}
+}
+
+// NodeName returns the name of a loaded command or test as used in scripts,
+// empty string if it is not known.
+func NodeName(n interface{}) string {
+	switch n := n.(type) {
+	case CmdIf:
+		return "if"
+	case CmdElsif:
+		return "elsif"
+	case CmdElse:
+		return "else"
+	case CmdStop:
+		return "stop"
+	case CmdFileInto:
+		return "fileinto"
+	case CmdRedirect:
+		return "redirect"
+	case CmdKeep:
+		return "keep"
+	case CmdDiscard:
+		return "discard"
+	case CmdSetFlag:
+		return "setflag"
+	case CmdAddFlag:
+		return "addflag"
+	case CmdRemoveFlag:
+		return "removeflag"
+	case CmdSet:
+		return "set"
+	case CmdReject:
+		return "reject"
+	case CmdEReject:
+		return "ereject"
+	case CmdDovecotTest:
+		return "test"
+	case CmdDovecotTestSet:
+		return "test_set"
+	case CmdDovecotTestFail:
+		return "test_fail"
+	case CmdDovecotBinaryLoad:
+		return "test_binary_load"
+	case CmdDovecotBinarySave:
+		return "test_binary_save"
+	case CmdDovecotMailboxCreate:
+		return "test_mailbox_create"
+	case CmdDovecotConfigSet:
+		if n.Unset {
+			return "test_config_unset"
+		}
+		return "test_config_set"
+	case CmdDovecotResultReset:
+		return "test_result_reset"
+	case CmdDovecotMessage, TestDovecotMessage:
+		return "test_message"
+	case AddressTest:
+		return "address"
+	case AllOfTest:
+		return "allof"
+	case AnyOfTest:
+		return "anyof"
+	case EnvelopeTest:
+		return "envelope"
+	case ExistsTest:
+		return "exists"
+	case FalseTest:
+		return "false"
+	case TrueTest:
+		return "true"
+	case HeaderTest:
+		return "header"
+	case NotTest:
+		return "not"
+	case SizeTest:
+		return "size"
+	case TestString:
+		return "string"
+	case HasFlagTest:
+		return "hasflag"
+	case EnvironmentTest:
+		return "environment"
+	case BodyTest:
+		return "body"
+	case AttachmentTest:
+		return "attachment"
+	case TestDovecotCompile:
+		return "test_script_compile"
+	case TestDovecotRun:
+		return "test_script_run"
+	case TestDovecotTestError:
+		return "test_error"
+	case TestDovecotResultAction:
+		return "test_result_action"
+	case TestDovecotResultExecute:
+		return "test_result_execute"
+	case TestDovecotMultiscript:
+		return "test_multiscript"
+	}
+	return ""
+}
+
+// controlCmd is implemented by commands that record their own trace entries.
+type controlCmd interface {
+	traceSelf()
+}
+
+func (CmdIf) traceSelf()    {}
+func (CmdElsif) traceSelf() {}
+func (CmdElse) traceSelf()  {}
+
+func executeCmd(ctx context.Context, d *RuntimeData, c Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.chargeCommand(); err != nil {
+		return runtimeError(c, err)
+	}
+
+	if d.Tracer == nil {
+		return runtimeError(c, c.Execute(ctx, d))
+	}
+	if _, ok := c.(controlCmd); ok {
+		return runtimeError(c, c.Execute(ctx, d))
+	}
+
+	actionsBefore := len(d.AppliedActions)
+	d.tracePush()
+	err := c.Execute(ctx, d)
+	f := d.tracePop()
+
+	e := TraceEntry{
+		Kind:      TraceCommand,
+		Position:  NodePosition(c),
+		Name:      NodeName(c),
+		Depth:     d.traceDepth,
+		Cmd:       c,
+		Result:    err == nil || errors.Is(err, ErrStop),
+		Variables: f.variables,
+	}
+	for i := actionsBefore; i < len(d.AppliedActions); i++ {
+		e.Actions = append(e.Actions, i)
+	}
+	d.Tracer.Trace(e)
+	return runtimeError(c, err)
+}
+
+func executeBlock(ctx context.Context, d *RuntimeData, cmds []Cmd) error {
+	d.traceDepth++
+	defer func() { d.traceDepth-- }()
+	for _, c := range cmds {
+		if err := executeCmd(ctx, d, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func traceControl(d *RuntimeData, c Cmd, res bool) {
+	if d.Tracer == nil {
+		return
+	}
+	d.Tracer.Trace(TraceEntry{
+		Kind:     TraceCommand,
+		Position: NodePosition(c),
+		Name:     NodeName(c),
+		Depth:    d.traceDepth,
+		Cmd:      c,
+		Result:   res,
+	})
+}
+
+func checkTest(ctx context.Context, d *RuntimeData, t Test) (bool, error) {
+	if err := d.chargeTest(); err != nil {
+		return false, runtimeError(t, err)
+	}
+
+	if d.Tracer == nil {
+		res, err := t.Check(ctx, d)
+		return res, runtimeError(t, err)
+	}
+
+	d.traceDepth++
+	d.tracePush()
+	res, err := t.Check(ctx, d)
+	f := d.tracePop()
+	d.traceDepth--
+
+	d.Tracer.Trace(TraceEntry{
+		Kind:      TraceTest,
+		Position:  NodePosition(t),
+		Name:      NodeName(t),
+		Depth:     d.traceDepth + 1,
+		Test:      t,
+		Result:    res,
+		Compared:  f.compared,
+		Variables: f.variables,
+	})
+	return res, runtimeError(t, err)
+}
@@ -0,0 +1,171 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// unicodeCaseMapCollation implements i;unicode-casemap (RFC 5051).
+//
+// Strings are compared after canonicalization: each character is mapped
+// to its titlecase and the result is decomposed using NFKD. As a result
+// composed and decomposed forms of the same text are equal.
+type unicodeCaseMapCollation struct{}
+
+// casemapAnyChar is used for '?' in :matches patterns. Since the text is
+// decomposed, a character is a base character followed by combining
+// marks.
+const casemapAnyChar = `([^\n\p{M}]\p{M}*)`
+
+func newCaseMapTransformer() transform.Transformer {
+	return transform.Chain(norm.NFKD, runes.Map(unicode.ToTitle), norm.NFKD)
+}
+
+// unicodeCaseMap returns the canonical form of s as defined by RFC 5051.
+func unicodeCaseMap(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return strings.ToUpper(s)
+	}
+	res, _, err := transform.String(newCaseMapTransformer(), s)
+	if err != nil {
+		// Not reachable, the transformers do not fail on any input.
+		return s
+	}
+	return res
+}
+
+// casemapText is the canonical form of a string that keeps track of
+// offsets in the original string, so values matched by wildcards can be
+// returned in the original form.
+type casemapText struct {
+	orig  string
+	canon string
+	// Boundaries of normalization segments (a starter character
+	// followed by combining marks) in canon and orig.
+	canonOffs []int
+	origOffs  []int
+}
+
+func newCasemapText(s string) casemapText {
+	t := casemapText{orig: s}
+	var (
+		b  strings.Builder
+		it norm.Iter
+	)
+	it.InitString(norm.NFKD, s)
+	for !it.Done() {
+		t.canonOffs = append(t.canonOffs, b.Len())
+		t.origOffs = append(t.origOffs, it.Pos())
+		b.WriteString(norm.NFKD.String(strings.Map(unicode.ToTitle, string(it.Next()))))
+	}
+	t.canonOffs = append(t.canonOffs, b.Len())
+	t.origOffs = append(t.origOffs, len(s))
+	t.canon = b.String()
+	return t
+}
+
+// origOffset converts the offset in canonical string into the offset in
+// the original string. Offsets inside a segment are rounded down for
+// starts and up for ends.
+func (t casemapText) origOffset(off int, end bool) int {
+	i := sort.SearchInts(t.canonOffs, off)
+	if i < len(t.canonOffs) && t.canonOffs[i] == off {
+		return t.origOffs[i]
+	}
+	if end {
+		return t.origOffs[i]
+	}
+	return t.origOffs[i-1]
+}
+
+func (t casemapText) match(re *regexp.Regexp) (bool, []string) {
+	idx := re.FindStringSubmatchIndex(t.canon)
+	if idx == nil {
+		return false, nil
+	}
+	matches := make([]string, len(idx)/2)
+	for i := range matches {
+		if idx[2*i] < 0 {
+			continue
+		}
+		start, end := t.origOffset(idx[2*i], false), t.origOffset(idx[2*i+1], true)
+		matches[i] = t.orig[start:end]
+	}
+	return true, matches
+}
+
+func (unicodeCaseMapCollation) Equal(value, key string) (bool, error) {
+	return unicodeCaseMap(value) == unicodeCaseMap(key), nil
+}
+
+func (unicodeCaseMapCollation) Contains(value, key string) (bool, error) {
+	return strings.Contains(unicodeCaseMap(value), unicodeCaseMap(key)), nil
+}
+
+func (unicodeCaseMapCollation) Compare(value, key string) (int, error) {
+	return strings.Compare(unicodeCaseMap(value), unicodeCaseMap(key)), nil
+}
+
+func (c unicodeCaseMapCollation) Match(value, pattern string) (bool, []string, error) {
+	regex, _ := c.patternRegex(pattern)
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, matches := newCasemapText(value).match(re)
+	return ok, matches, nil
+}
+
+func (c unicodeCaseMapCollation) MatchReader(match Match, value io.Reader, key string) (bool, error) {
+	var regex string
+	switch match {
+	case MatchContains:
+		regex = regexp.QuoteMeta(unicodeCaseMap(key))
+	case MatchIs:
+		regex = "^" + regexp.QuoteMeta(unicodeCaseMap(key)) + "$"
+	case MatchMatches:
+		regex, _ = c.patternRegex(key)
+	default:
+		return false, ErrComparatorMatchUnsupported
+	}
+
+	matcher, err := CompileMatcherRegex(regex, false)
+	if err != nil {
+		return false, err
+	}
+	return c.matchCompiledReader(&matcher, value)
+}
+
+func (unicodeCaseMapCollation) patternRegex(pattern string) (string, bool) {
+	// Wildcards and the escape character are not changed by
+	// canonicalization.
+	return wildcardToRegex(unicodeCaseMap(pattern), false, casemapAnyChar), false
+}
+
+func (unicodeCaseMapCollation) matchCompiled(cm *CompiledMatcher, value string) (bool, []string, error) {
+	if cm.string == nil {
+		return false, nil, fmt.Errorf("i;unicode-casemap pattern is compiled as octet matcher")
+	}
+	ok, matches := newCasemapText(value).match(cm.string)
+	return ok, matches, nil
+}
+
+func (unicodeCaseMapCollation) matchCompiledReader(cm *CompiledMatcher, value io.Reader) (bool, error) {
+	return cm.MatchReader(transform.NewReader(value, newCaseMapTransformer()))
+}
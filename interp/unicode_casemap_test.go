@@ -0,0 +1,79 @@
+package interp
+
+import (
+	"context"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnicodeCaseMap(t *testing.T) {
+	c := unicodeCaseMapCollation{}
+	equal := [][2]string{
+		{"Résumé", "Résumé"}, // composed and decomposed
+		{"RÉSUMÉ", "résumé"},   // case
+		{"ǆ", "Ǆ"},             // dž: titlecase differs from uppercase
+		{"ǅ", "DŽ"},           // compatibility decomposition
+		{"ﬁle", "FILE"},        // fi ligature
+		{"Å", "å"},             // angstrom sign
+		{"ΟΔΟΣ", "οδος"},       // final sigma
+		{"Straße", "STRAßE"},   // ß has no simple titlecase mapping
+		{"", ""},
+	}
+	for _, pair := range equal {
+		if ok, _ := c.Equal(pair[0], pair[1]); !ok {
+			t.Errorf("%q and %q are not equal", pair[0], pair[1])
+		}
+	}
+	if ok, _ := c.Equal("resume", "résumé"); ok {
+		t.Error("diacritics are ignored")
+	}
+
+	if ok, _ := c.Contains("Mon Résumé", "RÉSUMÉ"); !ok {
+		t.Error(":contains does not match decomposed value")
+	}
+	if cmp, _ := c.Compare("é", "É"); cmp != 0 {
+		t.Errorf("unexpected ordering of equal values: %d", cmp)
+	}
+	if cmp, _ := c.Compare("a", "B"); cmp >= 0 {
+		t.Errorf("unexpected ordering: %d", cmp)
+	}
+
+	ok, matches, err := c.Match("Résumé à jour", "r?sum* ?*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal(":matches does not match decomposed value")
+	}
+	want := []string{"Résumé à jour", "é", "é", "à", " jour"}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("unexpected match values: %q", matches)
+	}
+}
+
+func TestUnicodeCaseMapScript(t *testing.T) {
+	script := loadTestScript(t, `require ["comparator-i;unicode-casemap", "fileinto", "body", "variables"];
+if header :is :comparator "i;unicode-casemap" "subject" "RÉSUMÉ" { fileinto "is"; }
+if header :matches :comparator "i;unicode-casemap" "subject" "r?sum?" { fileinto "matches-${1}"; }
+if body :contains :comparator "i;unicode-casemap" "ÉLÈVE" { fileinto "body"; }
+if body :matches :comparator "i;unicode-casemap" "*l?ve*" { fileinto "body-matches"; }
+`, chainTestOptions)
+
+	// Decomposed forms.
+	subject := "Re\u0301sume\u0301"
+	raw := "Subject: " + subject + "\r\n\r\nUn e\u0301le\u0300ve.\r\n"
+	d := NewRuntimeData(script, DummyPolicy{}, EnvelopeStatic{}, MessageStatic{
+		Size:       len(raw),
+		Header:     textproto.MIMEHeader{"Subject": {subject}},
+		RawMessage: []byte(raw),
+	})
+	if err := script.Execute(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"is", "matches-é", "body", "body-matches"}
+	if strings.Join(d.Mailboxes, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected matches: %q", d.Mailboxes)
+	}
+}
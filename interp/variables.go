@@ -2,10 +2,11 @@ package interp
 
 import (
 	"context"
-	"encoding/gob"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/foxcpp/go-sieve/lexer"
 )
 
 /*
@@ -67,30 +68,123 @@ func expandVars(d *RuntimeData, s string) string {
 		name := match[2 : len(match)-1]
 
 		if matchNum, err := strconv.Atoi(name); err == nil && matchNum >= 0 {
-			return d.MatchVariable(matchNum)
+			value := d.MatchVariable(matchNum)
+			d.traceVariable(name, value)
+			return value
 		}
 
 		value, err := d.Var(name)
 		if err != nil {
 			panic("attempt to use an unusable variable: " + name)
 		}
+		d.traceVariable(name, value)
 		return value
 	})
 	return expanded
 }
 
 type CmdSet struct {
+	lexer.Position
 	Name  string
 	Value string
 
-	ModifyValue func(string) string
+	// Modifiers lists value modifiers (e.g. "lower", "length") in the
+	// order they are applied.
+	Modifiers []string
+}
+
+// modifierPrecedence is the precedence of value modifiers defined
+// in RFC 5229, Section 4.1. Higher precedence modifiers are applied first.
+var modifierPrecedence = map[string]int{
+	"lower":         40,
+	"upper":         40,
+	"lowerfirst":    30,
+	"upperfirst":    30,
+	"quotewildcard": 20,
+	"length":        10,
+}
+
+var valueModifiers = map[string]func(string) string{
+	"length": func(s string) string {
+		// RFC mentions `characters' and not octets
+		return strconv.Itoa(len([]rune(s)))
+	},
+	"quotewildcard": func(s string) string {
+		escaped := strings.Builder{}
+		escaped.Grow(len(s))
+		for _, chr := range s {
+			switch chr {
+			case '\\', '*', '?':
+				escaped.WriteByte('\\')
+				escaped.WriteRune(chr)
+			default:
+				escaped.WriteRune(chr)
+			}
+		}
+		return escaped.String()
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"upperfirst": func(s string) string {
+		if len(s) == 0 {
+			return s
+		}
+		first := s[0]
+		if first >= 'a' && first <= 'z' {
+			first -= 'a' - 'A'
+		}
+		return string(first) + s[1:]
+	},
+	"lowerfirst": func(s string) string {
+		if len(s) == 0 {
+			return s
+		}
+		first := s[0]
+		if first >= 'A' && first <= 'Z' {
+			first += 'a' - 'A'
+		}
+		return string(first) + s[1:]
+	},
+}
+
+func (c CmdSet) modifyValue(d *RuntimeData, s string) string {
+	for _, name := range c.Modifiers {
+		s = valueModifiers[name](s)
+	}
+
+	// If last run modifier was quotewildcard - check
+	// whether created value would remain valid
+	// if truncated to MaxVariableLen. If so, truncate
+	// here and remove dangling backslashes (if any).
+	if len(c.Modifiers) != 0 && c.Modifiers[len(c.Modifiers)-1] == "quotewildcard" {
+		maxLen := d.options().MaxVariableLen
+		if len(s) > maxLen {
+			until := maxLen
+
+			// (Copy-pasted from RuntimeData.SetVar)
+			// If this truncated an otherwise valid Unicode character,
+			// remove the character altogether.
+			for until > 0 && s[until] >= 128 && s[until] < 192 /* second or further octet of UTF-8 encoding */ {
+				until--
+			}
+
+			if s[until-1] == '\\' {
+				until--
+			}
+
+			s = s[:until]
+		}
+	}
+
+	return s
 }
 
 func (c CmdSet) Execute(_ context.Context, d *RuntimeData) error {
-	return d.SetVar(c.Name, c.ModifyValue(expandVars(d, c.Value)))
+	return d.SetVar(c.Name, c.modifyValue(d, expandVars(d, c.Value)))
 }
 
 type TestString struct {
+	lexer.Position
 	matcherTest
 
 	Source []string
@@ -123,7 +217,3 @@ func (t TestString) Check(_ context.Context, d *RuntimeData) (bool, error) {
 
 	return false, nil
 }
-
-func init() {
-	gob.Register(TestString{})
-}
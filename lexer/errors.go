@@ -0,0 +1,111 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrorPosition returns the script position associated with err, if any.
+// Errors created using ErrorAt and Error values carry the position.
+func ErrorPosition(err error) (Position, bool) {
+	var posErr *Error
+	if errors.As(err, &posErr) {
+		return posErr.Position, true
+	}
+	var tokErr tokError
+	if errors.As(err, &tokErr) && tokErr.t != nil {
+		if p, ok := tokErr.t.(interface{ Pos() Position }); ok {
+			return p.Pos(), true
+		}
+		line, col := tokErr.t.LineCol()
+		return LineCol(line, col), true
+	}
+	return Position{}, false
+}
+
+// Error is an error annotated with the position in the script
+// where it was detected.
+type Error struct {
+	Position Position
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Position.String() + ": " + e.Message()
+}
+
+// Message returns the error text without the position.
+func (e *Error) Message() string {
+	if tokErr, ok := e.Err.(tokError); ok {
+		// Do not repeat the position.
+		return tokErr.text
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors collected while processing a script
+// in error-recovering mode (see parser.ParseRecover and
+// interp.LoadScriptRecover).
+type ErrorList []*Error
+
+// Add appends err to the list. If err does not carry a position,
+// defaultPos is used.
+func (l *ErrorList) Add(defaultPos Position, err error) {
+	if posErr, ok := err.(*Error); ok {
+		*l = append(*l, posErr)
+		return
+	}
+	if pos, ok := ErrorPosition(err); ok {
+		if pos.File == "" {
+			pos.File = defaultPos.File
+		}
+		defaultPos = pos
+	}
+	*l = append(*l, &Error{Position: defaultPos, Err: err})
+}
+
+// Sort sorts the list by position. Errors from different files are sorted
+// by file name first.
+func (l ErrorList) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		pi, pj := l[i].Position, l[j].Position
+		if pi.File != pj.File {
+			return pi.File < pj.File
+		}
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Col < pj.Col
+	})
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Err returns nil if the list is empty or the list itself otherwise.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
@@ -22,6 +22,12 @@ func (l Position) LineCol() (int, int) {
 	return l.Line, l.Col
 }
 
+// Pos returns the position itself. It allows to get full position
+// (including file name) of any value that embeds Position.
+func (l Position) Pos() Position {
+	return l
+}
+
 func LineCol(line, col int) Position {
 	return Position{Line: line, Col: col}
 }
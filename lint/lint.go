@@ -0,0 +1,115 @@
+// Package lint implements static analysis of Sieve scripts.
+//
+// Unlike the loader in the interp package, lint does not reject scripts.
+// It reports suspicious constructs that are valid Sieve but most likely
+// do not do what the script author intended.
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/foxcpp/go-sieve/lexer"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Code is a stable identifier of a diagnostic kind. Codes are never
+// renamed or reused so they can be used to filter or suppress diagnostics.
+type Code string
+
+const (
+	// CodeLoadError is used for errors reported by the script loader.
+	CodeLoadError Code = "load-error"
+	// CodeUnreachable is reported for commands that follow 'stop'.
+	CodeUnreachable Code = "unreachable"
+	// CodeConstantTest is reported for tests that are always true or always false.
+	CodeConstantTest Code = "constant-test"
+	// CodeDuplicateFileinto is reported when the same mailbox is used
+	// for fileinto more than once in the same block.
+	CodeDuplicateFileinto Code = "duplicate-fileinto"
+	// CodeUnusedRequire is reported for extensions that are required but never used.
+	CodeUnusedRequire Code = "unused-require"
+	// CodeUnsetVariable is reported for variables that are read before
+	// any 'set' command assigns them.
+	CodeUnsetVariable Code = "unset-variable"
+	// CodeMatchesNoWildcard is reported for :matches keys that contain no
+	// wildcards and therefore behave like :is.
+	CodeMatchesNoWildcard Code = "matches-no-wildcard"
+	// CodeDiscardKeep is reported when 'keep' follows 'discard' in the same block,
+	// making 'discard' ineffective.
+	CodeDiscardKeep Code = "discard-keep"
+)
+
+type Diagnostic struct {
+	Position lexer.Position
+	Severity Severity
+	Code     Code
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %v: %s [%s]", d.Position, d.Severity, d.Message, d.Code)
+}
+
+type linter struct {
+	diags []Diagnostic
+}
+
+func (l *linter) report(pos lexer.Position, sev Severity, code Code, format string, args ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{
+		Position: pos,
+		Severity: sev,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) result() []Diagnostic {
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		pi, pj := l.diags[i].Position, l.diags[j].Position
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Col < pj.Col
+	})
+	return l.diags
+}
+
+// hasWildcards reports whether the :matches pattern contains
+// any unescaped '*' or '?'.
+func hasWildcards(pattern string) bool {
+	escaped := false
+	for _, chr := range pattern {
+		if escaped {
+			escaped = false
+			continue
+		}
+		switch chr {
+		case '\\':
+			escaped = true
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package lint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/parser"
+)
+
+func testLint(t *testing.T, script string, codes []Code) {
+	t.Helper()
+
+	toks, err := lexer.Lex(strings.NewReader(script), &lexer.Options{})
+	if err != nil {
+		t.Fatal("Lexer failed:", err)
+	}
+	cmds, err := parser.Parse(lexer.NewStream(toks), &parser.Options{})
+	if err != nil {
+		t.Fatal("Parser failed:", err)
+	}
+
+	actual := []Code{}
+	for _, d := range Lint(cmds) {
+		actual = append(actual, d.Code)
+	}
+	if !reflect.DeepEqual(actual, codes) {
+		t.Errorf("Wrong diagnostics for script:\n%s", script)
+		t.Log("Actual:  ", actual)
+		t.Log("Expected:", codes)
+	}
+}
+
+func TestLint(t *testing.T) {
+	testLint(t, `require "fileinto";
+if header :contains "subject" "spam" {
+	fileinto "Spam";
+	stop;
+}
+keep;`, []Code{})
+
+	testLint(t, `stop;
+keep;
+discard;`, []Code{CodeUnreachable})
+
+	testLint(t, `if true { keep; }
+elsif anyof(false, not false) { keep; }
+elsif allof(true, header :is "a" "b") { keep; }`, []Code{CodeConstantTest, CodeConstantTest})
+
+	testLint(t, `require "fileinto";
+fileinto "a";
+fileinto "b";
+fileinto "a";
+if true {
+	fileinto "a";
+}`, []Code{CodeDuplicateFileinto, CodeConstantTest})
+
+	testLint(t, `require ["fileinto", "body", "copy"];
+fileinto :copy "a";`, []Code{CodeUnusedRequire})
+
+	testLint(t, `require ["variables", "comparator-i;ascii-numeric", "relational"];
+if string :value "gt" :comparator "i;ascii-numeric" "${a}" "1" {
+	set "a" "${b}";
+}
+set "b" "c";
+if string "${b}" "c" { keep; }`, []Code{CodeUnsetVariable, CodeUnsetVariable})
+
+	testLint(t, `if header :matches "subject" ["*money*", "lottery", "\\*"] { keep; }`,
+		[]Code{CodeMatchesNoWildcard, CodeMatchesNoWildcard})
+
+	testLint(t, `discard;
+keep;`, []Code{CodeDiscardKeep})
+
+	testLint(t, `require ["envelope", "variables"];
+if string "${envelope.from}" "" { keep; }`, []Code{})
+}
+
+func TestLintScript(t *testing.T) {
+	script, err := sieve.Load(strings.NewReader(`require ["fileinto", "encoded-character"];
+if anyof(true, header :is "a" "b") {
+	fileinto "a";
+	fileinto "a";
+	stop;
+	discard;
+}
+if header :matches "subject" "${hex:2a}" {
+	discard;
+	keep;
+}`), sieve.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := []string{}
+	for _, d := range LintScript(script) {
+		actual = append(actual, d.Position.String()+" "+string(d.Code)+" "+d.Message)
+	}
+	expected := []string{
+		"2:4 constant-test if condition is always true",
+		"4:2 duplicate-fileinto fileinto \"a\" is repeated in the same block",
+		"6:2 unreachable discard is never executed because it follows 'stop'",
+		"10:2 discard-keep keep after discard makes discard ineffective",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Log("Actual:  ", actual)
+		t.Log("Expected:", expected)
+		t.Fail()
+	}
+}
@@ -0,0 +1,158 @@
+package lint
+
+import (
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+type scriptLinter struct {
+	linter
+}
+
+// LintScript analyzes the loaded script.
+//
+// It complements Lint by checking values as the interpreter sees them
+// (e.g. after encoded-character decoding). Checks that need the original
+// source text (unused requires, variables used before set) are only
+// done by Lint.
+func LintScript(s *interp.Script) []Diagnostic {
+	l := &scriptLinter{}
+	l.block(s.Cmds())
+	return l.result()
+}
+
+func (l *scriptLinter) block(cmds []interp.Cmd) {
+	stopped := false
+	discarded := false
+	mailboxes := map[string]struct{}{}
+
+	for _, cmd := range cmds {
+		if stopped {
+			name := interp.NodeName(cmd)
+			if name == "" {
+				name = "command"
+			}
+			l.report(interp.NodePosition(cmd), SeverityWarning, CodeUnreachable,
+				"%s is never executed because it follows 'stop'", name)
+			stopped = false
+		}
+
+		switch cmd := cmd.(type) {
+		case interp.CmdStop:
+			stopped = true
+		case interp.CmdFileInto:
+			if _, dup := mailboxes[cmd.Mailbox]; dup {
+				l.report(cmd.Position, SeverityWarning, CodeDuplicateFileinto,
+					"fileinto %q is repeated in the same block", cmd.Mailbox)
+			}
+			mailboxes[cmd.Mailbox] = struct{}{}
+		case interp.CmdDiscard:
+			discarded = true
+		case interp.CmdKeep:
+			if discarded {
+				l.report(cmd.Position, SeverityWarning, CodeDiscardKeep,
+					"keep after discard makes discard ineffective")
+			}
+		case interp.CmdIf:
+			l.cond("if", cmd.Test)
+			l.block(cmd.Block)
+		case interp.CmdElsif:
+			l.cond("elsif", cmd.Test)
+			l.block(cmd.Block)
+		case interp.CmdElse:
+			l.block(cmd.Block)
+		}
+	}
+}
+
+func (l *scriptLinter) cond(name string, t interp.Test) {
+	if val, ok := constantLoadedTest(t); ok {
+		l.report(interp.NodePosition(t), SeverityWarning, CodeConstantTest,
+			"%s condition is always %v", name, val)
+	}
+	l.test(t)
+}
+
+func (l *scriptLinter) test(t interp.Test) {
+	var (
+		match interp.Match
+		key   []string
+	)
+	switch t := t.(type) {
+	case interp.AllOfTest:
+		for _, sub := range t.Tests {
+			l.test(sub)
+		}
+		return
+	case interp.AnyOfTest:
+		for _, sub := range t.Tests {
+			l.test(sub)
+		}
+		return
+	case interp.NotTest:
+		l.test(t.Test)
+		return
+	case interp.AddressTest:
+		match, key = t.Match, t.Key
+	case interp.EnvelopeTest:
+		match, key = t.Match, t.Key
+	case interp.HeaderTest:
+		match, key = t.Match, t.Key
+	case interp.TestString:
+		match, key = t.Match, t.Key
+	case interp.EnvironmentTest:
+		match, key = t.Match, t.Key
+	case interp.HasFlagTest:
+		match, key = t.Match, t.Key
+	case interp.BodyTest:
+		match, key = t.Match, t.Key
+	case interp.AttachmentTest:
+		match, key = t.Match, t.Key
+	default:
+		return
+	}
+
+	if match != interp.MatchMatches {
+		return
+	}
+	for _, k := range key {
+		if !hasWildcards(k) && !variableRef.MatchString(k) {
+			l.report(interp.NodePosition(t), SeverityInfo, CodeMatchesNoWildcard,
+				":matches key %q contains no wildcards, use :is instead", k)
+		}
+	}
+}
+
+func constantLoadedTest(t interp.Test) (value bool, ok bool) {
+	switch t := t.(type) {
+	case interp.TrueTest:
+		return true, true
+	case interp.FalseTest:
+		return false, true
+	case interp.NotTest:
+		val, ok := constantLoadedTest(t.Test)
+		return !val, ok
+	case interp.AllOfTest:
+		return constantListTest(t.Tests, false)
+	case interp.AnyOfTest:
+		return constantListTest(t.Tests, true)
+	}
+	return false, false
+}
+
+func constantListTest(tests []interp.Test, short bool) (bool, bool) {
+	allConst := true
+	for _, sub := range tests {
+		val, ok := constantLoadedTest(sub)
+		if !ok {
+			allConst = false
+			continue
+		}
+		if val == short {
+			return short, true
+		}
+	}
+	if allConst && len(tests) != 0 {
+		return !short, true
+	}
+	return false, false
+}
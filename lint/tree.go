@@ -0,0 +1,327 @@
+package lint
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/foxcpp/go-sieve/lexer"
+	"github.com/foxcpp/go-sieve/parser"
+)
+
+// variableRef matches variable references (RFC 5229), see interp/variables.go.
+var variableRef = regexp.MustCompile(`\${(?:[a-zA-Z_][a-zA-Z0-9_]*\.(?:(?:[a-zA-Z_][a-zA-Z0-9_]*|[0-9]+)\.)*)?(?:[a-zA-Z_][a-zA-Z0-9_]*|[0-9]+)}`)
+
+var encodedCharRef = regexp.MustCompile(`(?i)\${(hex|unicode):`)
+
+// extensionByCommand lists extensions that are "used" if a command
+// or a test with the corresponding name is present in the script.
+var extensionByCommand = map[string]string{
+	"fileinto":    "fileinto",
+	"envelope":    "envelope",
+	"setflag":     "imap4flags",
+	"addflag":     "imap4flags",
+	"removeflag":  "imap4flags",
+	"hasflag":     "imap4flags",
+	"set":         "variables",
+	"string":      "variables",
+	"reject":      "reject",
+	"ereject":     "ereject",
+	"environment": "environment",
+	"body":        "body",
+	"attachment":  "vnd.go-sieve.attachment",
+}
+
+// extensionByTag is the same as extensionByCommand, but for tagged arguments.
+var extensionByTag = map[string]string{
+	"value":  "relational",
+	"count":  "relational",
+	"copy":   "copy",
+	"flags":  "imap4flags",
+	"user":   "subaddress",
+	"detail": "subaddress",
+}
+
+type treeLinter struct {
+	linter
+
+	required     map[string]lexer.Position
+	requireOrder []string
+	used         map[string]struct{}
+
+	setVars      map[string]struct{}
+	reportedVars map[string]struct{}
+}
+
+// Lint analyzes the parsed script and returns the list of diagnostics
+// sorted by position.
+func Lint(cmds []parser.Cmd) []Diagnostic {
+	l := &treeLinter{
+		required:     map[string]lexer.Position{},
+		used:         map[string]struct{}{},
+		setVars:      map[string]struct{}{},
+		reportedVars: map[string]struct{}{},
+	}
+	l.block(cmds)
+	l.checkRequires()
+	return l.result()
+}
+
+func (l *treeLinter) block(cmds []parser.Cmd) {
+	stopped := false
+	var discardAt *lexer.Position
+	mailboxes := map[string]lexer.Position{}
+
+	for _, cmd := range cmds {
+		id := strings.ToLower(cmd.Id)
+
+		if stopped {
+			l.report(cmd.Position, SeverityWarning, CodeUnreachable,
+				"%s is never executed because it follows 'stop'", id)
+			stopped = false
+		}
+
+		if ext, ok := extensionByCommand[id]; ok {
+			l.use(ext)
+		}
+
+		switch id {
+		case "require":
+			for _, ext := range stringArgs(cmd.Args) {
+				if _, ok := l.required[ext]; !ok {
+					l.requireOrder = append(l.requireOrder, ext)
+				}
+				l.required[ext] = cmd.Position
+			}
+			continue
+		case "stop":
+			stopped = true
+		case "fileinto":
+			if mailbox, ok := lastString(cmd.Args); ok && !variableRef.MatchString(mailbox) {
+				if first, dup := mailboxes[mailbox]; dup {
+					l.report(cmd.Position, SeverityWarning, CodeDuplicateFileinto,
+						"fileinto %q is repeated in the same block (first used at %v)", mailbox, first)
+				} else {
+					mailboxes[mailbox] = cmd.Position
+				}
+			}
+		case "discard":
+			pos := cmd.Position
+			discardAt = &pos
+		case "keep":
+			if discardAt != nil {
+				l.report(cmd.Position, SeverityWarning, CodeDiscardKeep,
+					"keep after discard (at %v) makes discard ineffective", *discardAt)
+			}
+		}
+
+		l.args(cmd.Args)
+		for _, t := range cmd.Tests {
+			l.test(t)
+		}
+
+		switch id {
+		case "if", "elsif":
+			if len(cmd.Tests) == 1 {
+				if val, ok := constantTest(cmd.Tests[0]); ok {
+					l.report(cmd.Tests[0].Position, SeverityWarning, CodeConstantTest,
+						"%s condition is always %v", id, val)
+				}
+			}
+		case "set":
+			if strs := stringArgs(cmd.Args); len(strs) == 2 {
+				l.setVars[strings.ToLower(strs[0])] = struct{}{}
+			}
+		case "setflag", "addflag", "removeflag":
+			if positional := positionalStrings(cmd.Args); len(positional) == 2 && len(positional[0]) == 1 {
+				l.setVars[strings.ToLower(positional[0][0])] = struct{}{}
+			}
+		}
+
+		l.block(cmd.Block)
+	}
+}
+
+func (l *treeLinter) test(t parser.Test) {
+	id := strings.ToLower(t.Id)
+	if ext, ok := extensionByCommand[id]; ok {
+		l.use(ext)
+	}
+
+	l.args(t.Args)
+
+	for _, a := range t.Args {
+		tag, ok := a.(parser.TagArg)
+		if !ok || !strings.EqualFold(tag.Value, "matches") {
+			continue
+		}
+		key, pos, ok := lastStringList(t.Args)
+		if !ok {
+			break
+		}
+		for _, k := range key {
+			if !hasWildcards(k) && !variableRef.MatchString(k) {
+				l.report(pos, SeverityInfo, CodeMatchesNoWildcard,
+					":matches key %q contains no wildcards, use :is instead", k)
+			}
+		}
+	}
+
+	for _, sub := range t.Tests {
+		l.test(sub)
+	}
+}
+
+func (l *treeLinter) args(args []parser.Arg) {
+	var lastTag string
+	for _, a := range args {
+		switch a := a.(type) {
+		case parser.TagArg:
+			lastTag = strings.ToLower(a.Value)
+			if ext, ok := extensionByTag[lastTag]; ok {
+				l.use(ext)
+			}
+			continue
+		case parser.StringArg:
+			l.strings(a.Position, lastTag, []string{a.Value})
+		case parser.StringListArg:
+			l.strings(a.Position, lastTag, a.Value)
+		}
+		lastTag = ""
+	}
+}
+
+func (l *treeLinter) strings(pos lexer.Position, tag string, values []string) {
+	if tag == "comparator" {
+		for _, v := range values {
+			l.use("comparator-" + v)
+		}
+		return
+	}
+
+	for _, v := range values {
+		if encodedCharRef.MatchString(v) {
+			l.use("encoded-character")
+		}
+		for _, ref := range variableRef.FindAllString(v, -1) {
+			l.use("variables")
+
+			name := strings.ToLower(ref[2 : len(ref)-1])
+			if strings.HasPrefix(name, "envelope.") {
+				l.use("envelope")
+				continue
+			}
+			if strings.Contains(name, ".") || (name[0] >= '0' && name[0] <= '9') {
+				continue
+			}
+			if _, ok := l.setVars[name]; ok {
+				continue
+			}
+			if _, ok := l.reportedVars[name]; ok {
+				continue
+			}
+			l.reportedVars[name] = struct{}{}
+			l.report(pos, SeverityWarning, CodeUnsetVariable,
+				"variable %q is used before it is set", name)
+		}
+	}
+}
+
+func (l *treeLinter) use(ext string) {
+	l.used[ext] = struct{}{}
+}
+
+func (l *treeLinter) checkRequires() {
+	for _, ext := range l.requireOrder {
+		if strings.HasPrefix(ext, "vnd.") {
+			continue
+		}
+		if _, ok := l.used[ext]; ok {
+			continue
+		}
+		l.report(l.required[ext], SeverityWarning, CodeUnusedRequire,
+			"extension %q is required but never used", ext)
+	}
+}
+
+// constantTest reports whether the test result does not depend on the message
+// and, if so, what the result is.
+func constantTest(t parser.Test) (value bool, ok bool) {
+	switch strings.ToLower(t.Id) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	case "not":
+		if len(t.Tests) != 1 {
+			return false, false
+		}
+		val, ok := constantTest(t.Tests[0])
+		return !val, ok
+	case "allof", "anyof":
+		short := strings.EqualFold(t.Id, "anyof") // value that short-circuits the test
+		allConst := true
+		for _, sub := range t.Tests {
+			val, ok := constantTest(sub)
+			if !ok {
+				allConst = false
+				continue
+			}
+			if val == short {
+				return short, true
+			}
+		}
+		if allConst && len(t.Tests) != 0 {
+			return !short, true
+		}
+	}
+	return false, false
+}
+
+func stringArgs(args []parser.Arg) []string {
+	var res []string
+	for _, a := range args {
+		switch a := a.(type) {
+		case parser.StringArg:
+			res = append(res, a.Value)
+		case parser.StringListArg:
+			res = append(res, a.Value...)
+		}
+	}
+	return res
+}
+
+// positionalStrings returns string arguments that are not values of tagged
+// arguments. It is only accurate for commands that have no tags with values.
+func positionalStrings(args []parser.Arg) [][]string {
+	var res [][]string
+	for _, a := range args {
+		switch a := a.(type) {
+		case parser.StringArg:
+			res = append(res, []string{a.Value})
+		case parser.StringListArg:
+			res = append(res, a.Value)
+		}
+	}
+	return res
+}
+
+func lastString(args []parser.Arg) (string, bool) {
+	val, _, ok := lastStringList(args)
+	if !ok || len(val) != 1 {
+		return "", false
+	}
+	return val[0], true
+}
+
+func lastStringList(args []parser.Arg) ([]string, lexer.Position, bool) {
+	if len(args) == 0 {
+		return nil, lexer.Position{}, false
+	}
+	switch a := args[len(args)-1].(type) {
+	case parser.StringArg:
+		return []string{a.Value}, a.Position, true
+	case parser.StringListArg:
+		return a.Value, a.Position, true
+	}
+	return nil, lexer.Position{}, false
+}
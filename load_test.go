@@ -0,0 +1,52 @@
+package sieve
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadErrorList(t *testing.T) {
+	script := `require "fileinto";
+fileinto :flags "a" "b";
+if header :is "subject" { keep; }
+keep :;
+envelope;
+if true {
+	discard 1;
+}
+`
+	opts := DefaultOptions()
+	opts.Lexer.Filename = "test.sieve"
+	opts.MaxErrors = 10
+
+	_, err := Load(strings.NewReader(script), opts)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ErrorList, got %v", err)
+	}
+
+	lines := []int{}
+	for _, e := range errs {
+		if e.Position.File != "test.sieve" {
+			t.Errorf("wrong file name for %v", e)
+		}
+		lines = append(lines, e.Position.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{2, 3, 4, 5, 7}) {
+		t.Errorf("wrong error positions: %v (%v)", lines, errs)
+	}
+
+	opts.MaxErrors = 3
+	_, err = Load(strings.NewReader(script), opts)
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("MaxErrors is not respected: %v", err)
+	}
+
+	opts.MaxErrors = 0
+	_, err = Load(strings.NewReader(script), opts)
+	if err == nil || errors.As(err, &errs) {
+		t.Errorf("expected a single error without MaxErrors, got %v", err)
+	}
+}
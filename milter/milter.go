@@ -0,0 +1,344 @@
+// Package milter runs Sieve scripts of message recipients at SMTP time
+// using the milter protocol supported by Sendmail and Postfix.
+//
+// Envelope and Message are built from milter callbacks and the script of
+// each recipient is executed at the end of the message. Actions that can
+// be taken while the SMTP transaction is open are applied:
+//
+//   - reject and ereject refuse the message with a 5xx reply containing
+//     the reason if all recipients reject it. Otherwise the message is
+//     accepted for all of them and the rejection is left to the delivery
+//     agent, since a single SMTP reply cannot reject some recipients only.
+//   - discard silently drops the message if all recipients discard it,
+//     otherwise discarding recipients are removed from the transaction.
+//
+// Other actions (keep, fileinto, redirect) and partial rejections are left
+// to the delivery agent, see OnResult to record them. editheader is not supported by the
+// interpreter, so headers are never changed.
+package milter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// DefaultMaxMessageSize is used if Server.MaxMessageSize is zero.
+const DefaultMaxMessageSize = 32 * 1024 * 1024
+
+type Server struct {
+	// Script returns the script of the recipient, nil if the recipient
+	// has no script. If it fails, the message is temporarily rejected.
+	Script func(ctx context.Context, rcpt string) (*interp.Script, error)
+
+	// Policy and Env are passed to the scripts. Policy defaults to
+	// interp.DummyPolicy.
+	Policy interp.PolicyReader
+	Env    interp.Env
+
+	// MaxMessageSize is the maximum size of the message buffered for
+	// script execution. Larger messages are accepted without running
+	// the scripts.
+	MaxMessageSize int64
+
+	// OnResult, if set, is called with the actions requested by the
+	// recipient script.
+	OnResult func(rcpt string, actions []interp.AppliedAction)
+
+	// OnError, if set, is called if the recipient script fails. The message
+	// is accepted for such recipient.
+	OnError func(rcpt string, err error)
+
+	wg sync.WaitGroup
+}
+
+// Serve accepts milter connections from l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	defer s.wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(context.Background(), conn)
+		}()
+	}
+}
+
+// ServeConn handles a single milter connection. The connection is closed
+// when the MTA quits or an error occurs.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	sess := &session{srv: s, conn: conn, macros: make(map[string]string)}
+	for {
+		pkt, err := readPacket(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		quit, err := sess.handle(ctx, pkt)
+		if err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+type session struct {
+	srv  *Server
+	conn net.Conn
+
+	macros map[string]string
+
+	from    string
+	rcpts   []string
+	message bytes.Buffer
+	tooBig  bool
+}
+
+func (s *session) reset() {
+	s.from = ""
+	s.rcpts = nil
+	s.message.Reset()
+	s.tooBig = false
+}
+
+func (s *session) maxSize() int64 {
+	if s.srv.MaxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return s.srv.MaxMessageSize
+}
+
+func (s *session) write(data []byte) {
+	if s.tooBig {
+		return
+	}
+	if int64(s.message.Len()+len(data)) > s.maxSize() {
+		s.tooBig = true
+		s.message.Reset()
+		return
+	}
+	s.message.Write(data)
+}
+
+func (s *session) reply(code byte, data []byte) error {
+	return writePacket(s.conn, code, data)
+}
+
+func (s *session) handle(ctx context.Context, pkt *packet) (quit bool, err error) {
+	switch pkt.code {
+	case cmdOptNeg:
+		if len(pkt.data) < 12 {
+			return false, fmt.Errorf("milter: malformed option negotiation")
+		}
+		if v := binary.BigEndian.Uint32(pkt.data); v < 2 {
+			return false, fmt.Errorf("milter: unsupported protocol version %d", v)
+		}
+		data := make([]byte, 12)
+		binary.BigEndian.PutUint32(data, protocolVersion)
+		binary.BigEndian.PutUint32(data[4:], actionDelRcpt)
+		// No protocol steps are skipped.
+		binary.BigEndian.PutUint32(data[8:], 0)
+		return false, s.reply(respOptNeg, data)
+	case cmdMacro:
+		if len(pkt.data) == 0 {
+			return false, nil
+		}
+		kv := splitNUL(pkt.data[1:])
+		for i := 0; i+1 < len(kv); i += 2 {
+			s.macros[kv[i]] = kv[i+1]
+		}
+		return false, nil
+	case cmdConnect, cmdHelo, cmdData, cmdUnknown:
+		return false, s.reply(respContinue, nil)
+	case cmdMail:
+		s.reset()
+		if args := splitNUL(pkt.data); len(args) != 0 {
+			s.from = trimAddr(args[0])
+		}
+		return false, s.reply(respContinue, nil)
+	case cmdRcpt:
+		if args := splitNUL(pkt.data); len(args) != 0 {
+			s.rcpts = append(s.rcpts, trimAddr(args[0]))
+		}
+		return false, s.reply(respContinue, nil)
+	case cmdHeader:
+		kv := splitNUL(pkt.data)
+		if len(kv) == 2 {
+			value := strings.ReplaceAll(strings.ReplaceAll(kv[1], "\r\n", "\n"), "\n", "\r\n")
+			s.write([]byte(kv[0] + ": " + strings.TrimLeft(value, " ") + "\r\n"))
+		}
+		return false, s.reply(respContinue, nil)
+	case cmdEOH:
+		s.write([]byte("\r\n"))
+		return false, s.reply(respContinue, nil)
+	case cmdBody:
+		s.write(pkt.data)
+		return false, s.reply(respContinue, nil)
+	case cmdEOB:
+		s.write(pkt.data)
+		err := s.endOfMessage(ctx)
+		s.reset()
+		return false, err
+	case cmdAbort:
+		s.reset()
+		return false, nil
+	case cmdQuitNC:
+		s.reset()
+		s.macros = make(map[string]string)
+		return false, nil
+	case cmdQuit:
+		return true, nil
+	default:
+		return false, fmt.Errorf("milter: unknown command %q", pkt.code)
+	}
+}
+
+func trimAddr(addr string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "<"), ">")
+}
+
+type verdict int
+
+const (
+	verdictAccept verdict = iota
+	verdictDiscard
+	verdictReject
+)
+
+func (s *session) endOfMessage(ctx context.Context) error {
+	if s.tooBig || len(s.rcpts) == 0 {
+		return s.reply(respContinue, nil)
+	}
+
+	raw := s.message.Bytes()
+	msg, err := interp.NewMessageReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		// Let the MTA deal with malformed messages.
+		return s.reply(respContinue, nil)
+	}
+
+	var (
+		verdicts     = make([]verdict, len(s.rcpts))
+		rejectReason string
+	)
+	for i, rcpt := range s.rcpts {
+		v, reason, err := s.runScript(ctx, rcpt, msg)
+		if err != nil {
+			return s.reply(respReplyCode, replyCode("451", "4.3.0", "Temporary failure, try again later"))
+		}
+		if v == verdictReject && rejectReason == "" {
+			rejectReason = reason
+		}
+		verdicts[i] = v
+	}
+
+	allRejected, allDiscarded := true, true
+	for _, v := range verdicts {
+		if v != verdictReject {
+			allRejected = false
+		}
+		if v != verdictDiscard {
+			allDiscarded = false
+		}
+	}
+	switch {
+	case allRejected:
+		return s.reply(respReplyCode, replyCode("550", "5.7.1", rejectReason))
+	case allDiscarded:
+		return s.reply(respDiscard, nil)
+	}
+
+	// Rejecting recipients stay in the transaction, the delivery agent
+	// rejects the message for them.
+	for i, v := range verdicts {
+		if v != verdictDiscard {
+			continue
+		}
+		if err := s.reply(respDelRcpt, joinNUL("<"+s.rcpts[i]+">")); err != nil {
+			return err
+		}
+	}
+	return s.reply(respContinue, nil)
+}
+
+func (s *session) runScript(ctx context.Context, rcpt string, msg interp.Message) (verdict, string, error) {
+	script, err := s.srv.Script(ctx, rcpt)
+	if err != nil {
+		return verdictAccept, "", err
+	}
+	if script == nil {
+		return verdictAccept, "", nil
+	}
+
+	policy := s.srv.Policy
+	if policy == nil {
+		policy = interp.DummyPolicy{}
+	}
+	d := interp.NewRuntimeData(script, policy, interp.EnvelopeStatic{
+		From: s.from,
+		To:   rcpt,
+		Auth: s.macros["{auth_authen}"],
+	}, msg)
+	d.Env = s.srv.Env
+	if err := script.Execute(ctx, d); err != nil {
+		if s.srv.OnError != nil {
+			s.srv.OnError(rcpt, err)
+		}
+		return verdictAccept, "", nil
+	}
+	if s.srv.OnResult != nil {
+		s.srv.OnResult(rcpt, d.AppliedActions)
+	}
+
+	discarded, delivered := false, false
+	for _, act := range d.AppliedActions {
+		switch act := act.(type) {
+		case interp.ActionReject:
+			return verdictReject, act.Reason, nil
+		case interp.ActionEReject:
+			return verdictReject, act.Reason, nil
+		case interp.ActionDiscard:
+			discarded = true
+		case interp.ActionKeep, interp.ActionFileInto, interp.ActionRedirect:
+			delivered = true
+		}
+	}
+	if discarded && !delivered {
+		return verdictDiscard, "", nil
+	}
+	return verdictAccept, "", nil
+}
+
+// replyCode formats the SMTP reply for the 'y' response. The text is
+// limited to a single line and '%' is escaped as required by the MTA.
+func replyCode(code, enhanced, text string) []byte {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		text = "Message rejected"
+	}
+	text = strings.ReplaceAll(text, "%", "%%")
+	if len(text) > 400 {
+		text = text[:400]
+	}
+	return joinNUL(code + " " + enhanced + " " + text)
+}
@@ -0,0 +1,237 @@
+package milter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve"
+	"github.com/foxcpp/go-sieve/interp"
+)
+
+// fakeMTA drives the milter the way Postfix does.
+type fakeMTA struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newFakeMTA(t *testing.T, srv *Server) *fakeMTA {
+	mta, milter := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ServeConn(context.Background(), milter)
+	}()
+	t.Cleanup(func() {
+		writePacket(mta, cmdQuit, nil)
+		if err := <-done; err != nil {
+			t.Error("ServeConn:", err)
+		}
+		mta.Close()
+	})
+
+	m := &fakeMTA{t: t, conn: mta}
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data, protocolVersion)
+	binary.BigEndian.PutUint32(data[4:], 0x1ff)
+	binary.BigEndian.PutUint32(data[8:], 0x1fffff)
+	resp := m.send(cmdOptNeg, data)
+	if len(resp) != 1 || resp[0].code != respOptNeg {
+		t.Fatalf("unexpected option negotiation response: %v", resp)
+	}
+	if actions := binary.BigEndian.Uint32(resp[0].data[4:]); actions != actionDelRcpt {
+		t.Errorf("unexpected actions requested: %x", actions)
+	}
+	return m
+}
+
+// send sends the command and reads responses until the one that
+// finishes processing of the command.
+func (m *fakeMTA) send(code byte, data []byte) []*packet {
+	m.t.Helper()
+	if err := writePacket(m.conn, code, data); err != nil {
+		m.t.Fatal(err)
+	}
+	if code == cmdMacro || code == cmdAbort {
+		return nil
+	}
+	var resps []*packet
+	for {
+		pkt, err := readPacket(m.conn)
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		resps = append(resps, pkt)
+		if pkt.code != respDelRcpt {
+			return resps
+		}
+	}
+}
+
+func (m *fakeMTA) expectContinue(code byte, data []byte) {
+	m.t.Helper()
+	resp := m.send(code, data)
+	if len(resp) != 1 || resp[0].code != respContinue {
+		m.t.Fatalf("unexpected response to %q: %v", code, resp)
+	}
+}
+
+// transaction sends the message and returns responses to the end
+// of message.
+func (m *fakeMTA) transaction(from string, rcpts []string, header [][2]string, body string) []*packet {
+	m.t.Helper()
+	m.send(cmdMacro, append([]byte{cmdMail}, joinNUL("{auth_authen}", "alice")...))
+	m.expectContinue(cmdMail, joinNUL("<"+from+">", "SIZE=100"))
+	for _, rcpt := range rcpts {
+		m.expectContinue(cmdRcpt, joinNUL("<"+rcpt+">"))
+	}
+	m.expectContinue(cmdData, nil)
+	for _, kv := range header {
+		m.expectContinue(cmdHeader, joinNUL(kv[0], kv[1]))
+	}
+	m.expectContinue(cmdEOH, nil)
+	// Body is split into several chunks.
+	for len(body) > 10 {
+		m.expectContinue(cmdBody, []byte(body[:10]))
+		body = body[10:]
+	}
+	if body != "" {
+		m.expectContinue(cmdBody, []byte(body))
+	}
+	return m.send(cmdEOB, nil)
+}
+
+var testHeader = [][2]string{
+	{"From", "alice@example.org"},
+	{"Subject", " Cheap =?UTF-8?Q?p=C3=ADlls?=\n\tnow"},
+}
+
+const testBody = "Buy now, limited offer!\r\n"
+
+func testServer(t *testing.T, scripts map[string]string) *Server {
+	loaded := make(map[string]*sieve.Script, len(scripts))
+	for rcpt, src := range scripts {
+		script, err := sieve.Load(strings.NewReader(src), sieve.DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded[rcpt] = script
+	}
+	return &Server{
+		Script: func(_ context.Context, rcpt string) (*interp.Script, error) {
+			if rcpt == "broken@example.org" {
+				return nil, errors.New("storage is down")
+			}
+			return loaded[rcpt], nil
+		},
+	}
+}
+
+const (
+	erejectScript = `require ["ereject", "envelope", "body"];
+if allof(header :contains "subject" "pílls now", envelope "from" "spammer@example.org",
+		body :contains "limited offer") {
+	ereject "No spam, please";
+}`
+	discardScript = `if header :contains "subject" "pílls" { discard; }`
+	keepScript    = `keep;`
+)
+
+func TestMilterEReject(t *testing.T) {
+	mta := newFakeMTA(t, testServer(t, map[string]string{
+		"bob@example.org":   erejectScript,
+		"carol@example.org": erejectScript,
+	}))
+
+	resp := mta.transaction("spammer@example.org", []string{"bob@example.org", "carol@example.org"}, testHeader, testBody)
+	want := []*packet{{code: respReplyCode, data: joinNUL("550 5.7.1 No spam, please")}}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("unexpected response: %v", resp)
+	}
+
+	// The state is reset for the next message of the connection.
+	resp = mta.transaction("alice@example.org", []string{"bob@example.org"}, testHeader, testBody)
+	if len(resp) != 1 || resp[0].code != respContinue {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestMilterDiscard(t *testing.T) {
+	mta := newFakeMTA(t, testServer(t, map[string]string{
+		"bob@example.org":   discardScript,
+		"carol@example.org": discardScript,
+	}))
+
+	resp := mta.transaction("alice@example.org", []string{"bob@example.org", "carol@example.org"}, testHeader, testBody)
+	if len(resp) != 1 || resp[0].code != respDiscard {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestMilterMixedRecipients(t *testing.T) {
+	srv := testServer(t, map[string]string{
+		"bob@example.org":   erejectScript,
+		"carol@example.org": discardScript,
+		"dave@example.org":  keepScript,
+	})
+	var results []string
+	srv.OnResult = func(rcpt string, actions []interp.AppliedAction) {
+		results = append(results, rcpt)
+	}
+	mta := newFakeMTA(t, srv)
+
+	resp := mta.transaction("spammer@example.org",
+		[]string{"bob@example.org", "carol@example.org", "dave@example.org", "eve@example.org"},
+		testHeader, testBody)
+	// The rejecting recipient is not removed, it is left to the delivery agent.
+	want := []*packet{
+		{code: respDelRcpt, data: joinNUL("<carol@example.org>")},
+		{code: respContinue, data: []byte{}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	if !reflect.DeepEqual(results, []string{"bob@example.org", "carol@example.org", "dave@example.org"}) {
+		t.Errorf("unexpected OnResult calls: %v", results)
+	}
+
+	// Rejects together with discards must not drop the message silently.
+	resp = mta.transaction("spammer@example.org", []string{"bob@example.org", "carol@example.org"}, testHeader, testBody)
+	want = []*packet{
+		{code: respDelRcpt, data: joinNUL("<carol@example.org>")},
+		{code: respContinue, data: []byte{}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("unexpected response for reject and discard: %v", resp)
+	}
+}
+
+func TestMilterScriptLookupError(t *testing.T) {
+	mta := newFakeMTA(t, testServer(t, nil))
+
+	resp := mta.transaction("alice@example.org", []string{"broken@example.org"}, testHeader, testBody)
+	if len(resp) != 1 || resp[0].code != respReplyCode || !strings.HasPrefix(string(resp[0].data), "451 ") {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestMilterTooBig(t *testing.T) {
+	srv := testServer(t, map[string]string{"bob@example.org": discardScript})
+	srv.MaxMessageSize = 50
+	mta := newFakeMTA(t, srv)
+
+	resp := mta.transaction("alice@example.org", []string{"bob@example.org"}, testHeader, strings.Repeat(testBody, 10))
+	if len(resp) != 1 || resp[0].code != respContinue {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestReplyCode(t *testing.T) {
+	got := string(replyCode("550", "5.7.1", "100% spam\r\nreally"))
+	if got != "550 5.7.1 100%% spam really\x00" {
+		t.Errorf("unexpected reply: %q", got)
+	}
+}
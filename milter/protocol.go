@@ -0,0 +1,109 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Milter protocol version 6 as implemented by Sendmail and Postfix.
+const protocolVersion = 6
+
+// Commands sent by the MTA.
+const (
+	cmdAbort   = 'A'
+	cmdBody    = 'B'
+	cmdConnect = 'C'
+	cmdMacro   = 'D'
+	cmdEOB     = 'E'
+	cmdHelo    = 'H'
+	cmdQuitNC  = 'K'
+	cmdHeader  = 'L'
+	cmdMail    = 'M'
+	cmdEOH     = 'N'
+	cmdOptNeg  = 'O'
+	cmdQuit    = 'Q'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+	cmdUnknown = 'U'
+)
+
+// Responses sent by the milter.
+const (
+	respContinue  = 'c'
+	respDiscard   = 'd'
+	respReplyCode = 'y'
+	respDelRcpt   = '-'
+	respOptNeg    = 'O'
+)
+
+// Actions the milter may request (SMFIF_*).
+const (
+	actionDelRcpt = 0x08
+)
+
+// maxPacketSize limits the size of a single protocol packet.
+const maxPacketSize = 1 << 20
+
+var errPacketTooBig = errors.New("milter: packet too big")
+
+type packet struct {
+	code byte
+	data []byte
+}
+
+func readPacket(r io.Reader) (*packet, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, fmt.Errorf("milter: empty packet")
+	}
+	if length > maxPacketSize {
+		return nil, errPacketTooBig
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &packet{code: buf[0], data: buf[1:]}, nil
+}
+
+func writePacket(w io.Writer, code byte, data []byte) error {
+	buf := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(data)))
+	buf[4] = code
+	copy(buf[5:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// splitNUL splits NUL-terminated strings.
+func splitNUL(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+	parts := bytes.Split(data, []byte{0})
+	res := make([]string, len(parts))
+	for i, p := range parts {
+		res[i] = string(p)
+	}
+	return res
+}
+
+func joinNUL(values ...string) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		b.WriteString(v)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+func (p *packet) String() string {
+	return fmt.Sprintf("%c%q", p.code, p.data)
+}
@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"errors"
+
 	"github.com/foxcpp/go-sieve/lexer"
 )
 
@@ -9,67 +11,158 @@ type Options struct {
 	MaxTestNesting  int
 }
 
+// errTooManyErrors aborts parsing in error-recovering mode.
+var errTooManyErrors = errors.New("too many errors")
+
+type parserState struct {
+	opts      *Options
+	maxErrors int
+	errs      lexer.ErrorList
+}
+
 func Parse(stream *lexer.Stream, opts *Options) ([]Cmd, error) {
-	return parse(stream, 0, opts)
+	return parse(stream, 0, opts, 0)
+}
+
+// ParseRecover is like Parse, but skips malformed commands and continues
+// parsing. It returns successfully parsed commands together with
+// lexer.ErrorList containing up to maxErrors errors.
+func ParseRecover(stream *lexer.Stream, opts *Options, maxErrors int) ([]Cmd, error) {
+	return parse(stream, 0, opts, maxErrors)
 }
 
 // parse is a low-level parsing function, it creates
 // AST with very little interpretation of values.
-func parse(stream *lexer.Stream, nesting int, opts *Options) ([]Cmd, error) {
-	if opts.MaxBlockNesting != 0 && nesting > opts.MaxBlockNesting {
+func parse(stream *lexer.Stream, nesting int, opts *Options, maxErrors int) ([]Cmd, error) {
+	p := parserState{opts: opts, maxErrors: maxErrors}
+	cmds, err := p.parseBlock(stream, nesting)
+	if err != nil && !errors.Is(err, errTooManyErrors) {
+		return nil, err
+	}
+	if len(p.errs) != 0 {
+		return cmds, p.errs
+	}
+	return cmds, nil
+}
+
+// recover records the error and reports whether parsing should continue.
+func (p *parserState) recover(stream *lexer.Stream, err error) error {
+	if p.maxErrors == 0 || errors.Is(err, errTooManyErrors) {
+		return err
+	}
+	pos, _ := lexer.ErrorPosition(err)
+	p.errs.Add(pos, err)
+	if len(p.errs) >= p.maxErrors {
+		return errTooManyErrors
+	}
+	return nil
+}
+
+// skipCommand skips tokens until the end of the malformed command:
+// a semicolon or a closing brace of a block started by the command.
+// It returns true if the enclosing block was closed while skipping.
+func skipCommand(stream *lexer.Stream) (blockClosed bool) {
+	depth := 0
+	switch stream.Last().(type) {
+	case lexer.Semicolon:
+		return false
+	case lexer.BlockStart:
+		depth = 1
+	}
+
+	for {
+		tok := stream.Pop()
+		switch tok.(type) {
+		case nil:
+			return false
+		case lexer.Semicolon:
+			if depth == 0 {
+				return false
+			}
+		case lexer.BlockStart:
+			depth++
+		case lexer.BlockEnd:
+			if depth == 0 {
+				return true
+			}
+			depth--
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+}
+
+func (p *parserState) parseBlock(stream *lexer.Stream, nesting int) ([]Cmd, error) {
+	if p.opts.MaxBlockNesting != 0 && nesting > p.opts.MaxBlockNesting {
 		return nil, stream.Err("block nesting limit exceeded")
 	}
 	res := []Cmd{}
 	for {
-		curCmd := Cmd{}
-
-		idT := stream.Pop()
-		if idT == nil {
-			return res, nil
+		cmd, blockEnd, err := p.parseCmd(stream, nesting)
+		if err != nil {
+			if err := p.recover(stream, err); err != nil {
+				return res, err
+			}
+			if skipCommand(stream) {
+				return res, nil
+			}
+			continue
 		}
-		switch id := idT.(type) {
-		case lexer.Identifier:
-			curCmd.Id = id.Text
-			curCmd.Position = id.Position
-		case lexer.BlockEnd:
+		if blockEnd {
 			return res, nil
-		default:
-			return nil, stream.Err("reading command: expected an identifier or closing brace")
 		}
+		res = append(res, cmd)
+	}
+}
 
-		args, tests, err := readArguments(stream, false, 0, opts)
-		if err != nil {
-			return nil, err
-		}
-		curCmd.Args = args
-		curCmd.Tests = tests
+func (p *parserState) parseCmd(stream *lexer.Stream, nesting int) (curCmd Cmd, blockEnd bool, err error) {
+	idT := stream.Pop()
+	if idT == nil {
+		return Cmd{}, true, nil
+	}
+	switch id := idT.(type) {
+	case lexer.Identifier:
+		curCmd.Id = id.Text
+		curCmd.Position = id.Position
+	case lexer.BlockEnd:
+		return Cmd{}, true, nil
+	default:
+		return Cmd{}, false, stream.Err("reading command: expected an identifier or closing brace")
+	}
 
-		cmdEnd := stream.Pop()
-		if cmdEnd == nil {
-			return nil, stream.Err("reading command: expected semicolon or block")
-		}
-		switch cmdEnd.(type) {
-		case lexer.Semicolon:
-			// Ok.
-		case lexer.BlockStart:
-			cmds, err := parse(stream, nesting+1, opts)
-			if err != nil {
-				return nil, err
-			}
+	args, tests, err := readArguments(stream, false, 0, p.opts)
+	if err != nil {
+		return Cmd{}, false, err
+	}
+	curCmd.Args = args
+	curCmd.Tests = tests
 
-			// EOF vs } check
-			last := stream.Last()
-			if last == nil {
-				return nil, stream.Err("reading command: expected a closing brace")
-			}
+	cmdEnd := stream.Pop()
+	if cmdEnd == nil {
+		return Cmd{}, false, stream.Err("reading command: expected semicolon or block")
+	}
+	switch cmdEnd.(type) {
+	case lexer.Semicolon:
+		// Ok.
+	case lexer.BlockStart:
+		cmds, err := p.parseBlock(stream, nesting+1)
+		if err != nil {
+			return Cmd{}, false, err
+		}
 
-			curCmd.Block = cmds
-		default:
-			return nil, stream.Err("reading command: unexpected token")
+		// EOF vs } check
+		last := stream.Last()
+		if last == nil {
+			return Cmd{}, false, stream.Err("reading command: expected a closing brace")
 		}
 
-		res = append(res, curCmd)
+		curCmd.Block = cmds
+	default:
+		return Cmd{}, false, stream.Err("reading command: unexpected token")
 	}
+
+	return curCmd, false, nil
 }
 
 func readArguments(s *lexer.Stream, forTest bool, nesting int, opts *Options) ([]Arg, []Test, error) {
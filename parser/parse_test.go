@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -58,7 +59,7 @@ func testParse(t *testing.T, script string, cmds []Cmd) {
 		t.Fatal("Lexer failed:", err)
 	}
 	s := lexer.NewStream(toks)
-	actualCmds, err := parse(s, 0, &Options{})
+	actualCmds, err := parse(s, 0, &Options{}, 0)
 	if err != nil {
 		t.Error("parse failed:", err)
 		return
@@ -185,3 +186,54 @@ func TestParser(t *testing.T) {
 		},
 	})
 }
+
+func TestParseRecover(t *testing.T) {
+	script := `keep :;
+if true {
+	fileinto "a" "b" (;
+	keep;
+	stop;
+}
+discard ];
+redirect "a";
+`
+	toks, err := lexer.Lex(strings.NewReader(script), &lexer.Options{})
+	if err != nil {
+		t.Fatal("Lexer failed:", err)
+	}
+
+	cmds, err := ParseRecover(lexer.NewStream(toks), &Options{}, 10)
+	var errs lexer.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ErrorList, got %v", err)
+	}
+
+	lines := []int{}
+	for _, e := range errs {
+		lines = append(lines, e.Position.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{1, 3, 7}) {
+		t.Errorf("wrong error positions: %v (%v)", lines, errs)
+	}
+
+	ids := []string{}
+	for _, c := range cmds {
+		ids = append(ids, c.Id)
+		for _, c := range c.Block {
+			ids = append(ids, c.Id)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"if", "keep", "stop", "redirect"}) {
+		t.Errorf("wrong recovered commands: %v", ids)
+	}
+
+	_, err = ParseRecover(lexer.NewStream(toks), &Options{}, 2)
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("maxErrors is not respected: %v", err)
+	}
+
+	_, err = Parse(lexer.NewStream(toks), &Options{})
+	if err == nil || errors.As(err, &errs) {
+		t.Errorf("expected a single error from Parse, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package sieve
 
 import (
+	"errors"
 	"io"
 
 	"github.com/foxcpp/go-sieve/interp"
@@ -9,8 +10,9 @@ import (
 )
 
 type (
-	Script      = interp.Script
-	RuntimeData = interp.RuntimeData
+	Script       = interp.Script
+	RuntimeData  = interp.RuntimeData
+	RuntimeError = interp.RuntimeError
 
 	ActionFileInfo = interp.ActionFileInto
 	ActionRedirect = interp.ActionRedirect
@@ -21,10 +23,17 @@ type (
 	Message      = interp.Message
 	Envelope     = interp.Envelope
 
+	// ErrorList is returned by Load if error-recovering mode is enabled.
+	ErrorList = lexer.ErrorList
+
 	Options struct {
 		Lexer  lexer.Options
 		Parser parser.Options
 		Interp interp.Options
+
+		// MaxErrors enables error-recovering mode if non-zero. In this mode
+		// Load reports up to MaxErrors errors at once, returning them as ErrorList.
+		MaxErrors int
 	}
 )
 
@@ -49,17 +58,55 @@ func DefaultOptions() Options {
 func Load(r io.Reader, opts Options) (*Script, error) {
 	toks, err := lexer.Lex(r, &opts.Lexer)
 	if err != nil {
+		if opts.MaxErrors != 0 {
+			errs := ErrorList{}
+			errs.Add(lexer.Position{File: opts.Lexer.Filename}, err)
+			return nil, errs
+		}
 		return nil, err
 	}
 
-	cmds, err := parser.Parse(lexer.NewStream(toks), &opts.Parser)
+	cmds, err := parser.ParseRecover(lexer.NewStream(toks), &opts.Parser, opts.MaxErrors)
+	maxErrors := opts.MaxErrors
+	var errs ErrorList
 	if err != nil {
-		return nil, err
+		if !errors.As(err, &errs) {
+			return nil, err
+		}
+		// Error-recovering mode: also load successfully parsed commands
+		// to report loader errors.
+		if len(errs) >= maxErrors {
+			return nil, errs
+		}
+		maxErrors -= len(errs)
+	}
+
+	script, err := interp.LoadScriptRecover(cmds, &opts.Interp, maxErrors)
+	if err != nil {
+		var loadErrs ErrorList
+		switch {
+		case errors.As(err, &loadErrs):
+			errs = append(errs, loadErrs...)
+		case opts.MaxErrors != 0:
+			// Keep the parse errors collected so far.
+			errs.Add(lexer.Position{File: opts.Lexer.Filename}, err)
+		default:
+			return nil, err
+		}
+	}
+	if len(errs) != 0 {
+		errs.Sort()
+		return nil, errs
 	}
 
-	return interp.LoadScript(cmds, &opts.Interp)
+	return script, nil
 }
 
+// RestoreSaved loads the script saved using Script.Save or Script.SaveTo.
+//
+// If the blob was saved by an incompatible version of go-sieve or is
+// corrupted, *interp.IncompatibleSavedError is returned and the script
+// should be loaded from source again.
 func RestoreSaved(r io.Reader) (*Script, error) {
 	return interp.RestoreFrom(r)
 }
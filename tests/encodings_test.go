@@ -0,0 +1,14 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodingsRFC2047(t *testing.T) {
+	RunDovecotTest(t, filepath.Join("pigeonhole", "tests", "encodings", "rfc2047.svtest"))
+}
+
+func TestEncodings8bit(t *testing.T) {
+	RunDovecotTest(t, filepath.Join("pigeonhole", "tests", "encodings", "8bit.svtest"))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/foxcpp/go-sieve/delivery"
 	"github.com/foxcpp/go-sieve/interp"
 )
 
@@ -91,3 +92,11 @@ func (s *simpleExecuteRuntime) HasMailboxMessage(mailboxName string, index int)
 func TestExecute(t *testing.T) {
 	RunExecuteTests(t, &simpleExecuteRuntime{})
 }
+
+func TestExecuteMaildir(t *testing.T) {
+	store, err := delivery.NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunExecuteTests(t, delivery.NewTestEnvironment(store))
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/delivery"
+)
+
+func runMultiscriptTest(t *testing.T, name string) {
+	t.Helper()
+	store, err := delivery.NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunDovecotTest(t, filepath.Join("pigeonhole", "tests", "multiscript", name),
+		ExecuteTestRuntime(delivery.NewTestEnvironment(store)),
+	)
+}
+
+func TestMultiscriptBasic(t *testing.T) {
+	runMultiscriptTest(t, "basic.svtest")
+}
+
+func TestMultiscriptConflicts(t *testing.T) {
+	runMultiscriptTest(t, "conflicts.svtest")
+}
+
+func TestMultiscriptInline(t *testing.T) {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"before.sieve": `require ["fileinto", "copy"]; fileinto :copy "Archive"; stop;`,
+		"user.sieve":   `require "fileinto"; if header :contains "subject" "spam" { fileinto "Junk"; }`,
+		"after.sieve":  `require "fileinto"; fileinto "After";`,
+	}
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := delivery.NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RunDovecotTestInline(t, dir, `require "vnd.dovecot.testsuite";
+test_set "message" text:
+Subject: cheap spam
+
+Hello.
+.
+;
+test_mailbox_create "Archive";
+test_mailbox_create "Junk";
+test_mailbox_create "After";
+test "Chain" {
+	if not test_multiscript ["before.sieve", "user.sieve", "after.sieve"] {
+		test_fail "failed to execute scripts";
+	}
+	if not test_message :folder "Archive" 0 { test_fail "not filed into Archive"; }
+	if not test_message :folder "Junk" 0 { test_fail "not filed into Junk"; }
+	if test_message :folder "After" 0 { test_fail "script after fileinto is executed"; }
+	if test_message :folder "INBOX" 0 { test_fail "implicit keep is executed"; }
+}`, ExecuteTestRuntime(delivery.NewTestEnvironment(store)))
+}
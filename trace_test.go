@@ -0,0 +1,81 @@
+package sieve
+
+import (
+	"bufio"
+	"context"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-sieve/interp"
+	"github.com/foxcpp/go-sieve/lexer"
+)
+
+func TestTrace(t *testing.T) {
+	script := `require ["fileinto", "variables"];
+set "folder" "Spam";
+if anyof (false, header :contains "subject" "present") {
+  fileinto "${folder}";
+}
+`
+	loaded, err := Load(strings.NewReader(script), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgHdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(eml))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := interp.NewRuntimeData(loaded, interp.DummyPolicy{},
+		interp.EnvelopeStatic{}, interp.MessageStatic{Size: len(eml), Header: msgHdr})
+	rec := &interp.TraceRecorder{}
+	data.Tracer = rec
+
+	if err := loaded.Execute(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		Kind      interp.TraceKind
+		Position  lexer.Position
+		Name      string
+		Depth     int
+		Result    bool
+		Compared  []string
+		Variables []interp.TraceVariable
+		Actions   []int
+	}
+	expected := []entry{
+		{Kind: interp.TraceCommand, Position: lexer.LineCol(2, 1), Name: "set", Result: true},
+		{Kind: interp.TraceTest, Position: lexer.LineCol(3, 11), Name: "false", Depth: 2},
+		{Kind: interp.TraceTest, Position: lexer.LineCol(3, 18), Name: "header", Depth: 2, Result: true,
+			Compared: []string{"I have a present for you"}},
+		{Kind: interp.TraceTest, Position: lexer.LineCol(3, 4), Name: "anyof", Depth: 1, Result: true},
+		{Kind: interp.TraceCommand, Position: lexer.LineCol(3, 1), Name: "if", Result: true},
+		{Kind: interp.TraceCommand, Position: lexer.LineCol(4, 3), Name: "fileinto", Depth: 1, Result: true,
+			Variables: []interp.TraceVariable{{Name: "folder", Value: "Spam"}},
+			Actions:   []int{0}},
+	}
+	actual := make([]entry, 0, len(rec.Entries))
+	for _, e := range rec.Entries {
+		actual = append(actual, entry{
+			Kind:      e.Kind,
+			Position:  e.Position,
+			Name:      e.Name,
+			Depth:     e.Depth,
+			Result:    e.Result,
+			Compared:  e.Compared,
+			Variables: e.Variables,
+			Actions:   e.Actions,
+		})
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("wrong trace\nactual:   %+v\nexpected: %+v", actual, expected)
+	}
+
+	pos, ok := rec.ActionSource(0)
+	if !ok || pos != lexer.LineCol(4, 3) {
+		t.Errorf("wrong action source: %v, %v", pos, ok)
+	}
+}